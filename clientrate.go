@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"sort"
+	"time"
+)
+
+type ClientRateEvent struct {
+	Time      string  `json:"time" msg:"time"`
+	Client    string  `json:"client" msg:"client"`
+	State     string  `json:"state" msg:"state"`
+	Threshold float64 `json:"threshold" msg:"threshold"`
+	Rate      float64 `json:"rate" msg:"rate"`
+}
+
+type clientRate struct {
+	prev  uint64
+	cur   uint64
+	level int
+}
+
+// ClientRateTracker estimates the query rate per client with the sliding window counter,
+// the weighted sum of the previous and the current fixed windows.
+type ClientRateTracker struct {
+	window      time.Duration
+	thresholds  []float64
+	maxClients  int
+	windowStart time.Time
+	clients     map[string]*clientRate
+	overLimit   int
+}
+
+func NewClientRateTracker(window time.Duration, thresholds []float64, maxClients int) *ClientRateTracker {
+	t := &ClientRateTracker{
+		window:     window,
+		thresholds: append([]float64{}, thresholds...),
+		maxClients: maxClients,
+		clients:    map[string]*clientRate{},
+	}
+	sort.Float64s(t.thresholds)
+	return t
+}
+
+func (t *ClientRateTracker) rotate(now time.Time) {
+	if now.Before(t.windowStart.Add(t.window)) {
+		return
+	}
+	continuous := now.Before(t.windowStart.Add(2 * t.window))
+	t.windowStart = now.Truncate(t.window)
+	for key, c := range t.clients {
+		if continuous {
+			c.prev, c.cur = c.cur, 0
+		} else {
+			c.prev, c.cur = 0, 0
+		}
+		if c.prev == 0 && c.level == 0 {
+			delete(t.clients, key)
+		}
+	}
+}
+
+func (t *ClientRateTracker) rate(c *clientRate, now time.Time) float64 {
+	elapsed := float64(now.Sub(t.windowStart)) / float64(t.window)
+	return (float64(c.prev)*(1-elapsed) + float64(c.cur)) / t.window.Seconds()
+}
+
+// Add counts a query of the client, and returns the alert event if the client crosses a threshold.
+// New clients are not tracked when maxClients clients are tracked, then ok is false.
+func (t *ClientRateTracker) Add(key string, now time.Time) (ev *ClientRateEvent, ok bool) {
+	t.rotate(now)
+	c, ok := t.clients[key]
+	if !ok {
+		if len(t.clients) >= t.maxClients {
+			return nil, false
+		}
+		c = &clientRate{}
+		t.clients[key] = c
+	}
+	c.cur++
+	if c.level >= len(t.thresholds) {
+		return nil, true
+	}
+	rate := t.rate(c, now)
+	if rate < t.thresholds[c.level] {
+		return nil, true
+	}
+	if c.level == 0 {
+		t.overLimit++
+	}
+	for c.level < len(t.thresholds) && rate >= t.thresholds[c.level] {
+		c.level++
+	}
+	return &ClientRateEvent{
+		Time:      now.Format(time.RFC3339),
+		Client:    key,
+		State:     "alert",
+		Threshold: t.thresholds[c.level-1],
+		Rate:      rate,
+	}, true
+}
+
+// Expire returns the clear events of the clients whose rate fell below the lowest threshold.
+func (t *ClientRateTracker) Expire(now time.Time) []*ClientRateEvent {
+	t.rotate(now)
+	events := []*ClientRateEvent{}
+	for key, c := range t.clients {
+		if c.level == 0 {
+			continue
+		}
+		if rate := t.rate(c, now); rate < t.thresholds[0] {
+			c.level = 0
+			t.overLimit--
+			events = append(events, &ClientRateEvent{
+				Time:      now.Format(time.RFC3339),
+				Client:    key,
+				State:     "clear",
+				Threshold: t.thresholds[0],
+				Rate:      rate,
+			})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Client < events[j].Client })
+	return events
+}
+
+// OverLimit returns the number of clients over the lowest threshold.
+func (t *ClientRateTracker) OverLimit() int {
+	return t.overLimit
+}
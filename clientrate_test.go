@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestClientRateTracker(t *testing.T) {
+	tracker := dtap.NewClientRateTracker(10*time.Second, []float64{5, 1}, 2)
+	now := time.Unix(1600000000, 0)
+	var events []*dtap.ClientRateEvent
+	for i := 0; i < 50; i++ {
+		ev, ok := tracker.Add("192.168.0.0", now)
+		assert.True(t, ok)
+		if ev != nil {
+			events = append(events, ev)
+		}
+	}
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "alert", events[0].State)
+		assert.Equal(t, 1.0, events[0].Threshold)
+		assert.Equal(t, 5.0, events[1].Threshold)
+	}
+	assert.Equal(t, 1, tracker.OverLimit())
+
+	_, ok := tracker.Add("192.168.1.0", now)
+	assert.True(t, ok)
+	_, ok = tracker.Add("192.168.2.0", now)
+	assert.False(t, ok)
+
+	// the previous window is weighted by the remaining time
+	assert.Empty(t, tracker.Expire(now.Add(11*time.Second)))
+	clear := tracker.Expire(now.Add(25 * time.Second))
+	if assert.Len(t, clear, 1) {
+		assert.Equal(t, "192.168.0.0", clear[0].Client)
+		assert.Equal(t, "clear", clear[0].State)
+	}
+	assert.Equal(t, 0, tracker.OverLimit())
+}
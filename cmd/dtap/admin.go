@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mimuret/dtap"
+	log "github.com/sirupsen/logrus"
+)
+
+const adminPrefix = "/api/v1/"
+
+// handleAdmin adds the admin API and the health checks to the listener of the prometheus exporter.
+func handleAdmin(d *daemon) {
+	http.HandleFunc(adminPrefix+"config", adminMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		config, _ := d.current()
+		writeJSON(w, http.StatusOK, dtap.MaskedConfig(config))
+	}))
+	http.HandleFunc(adminPrefix+"status", adminMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.status())
+	}))
+	http.HandleFunc(adminPrefix+"outputs/", adminMethod(http.MethodPost, d.adminAuth(d.handleOutput)))
+	http.HandleFunc(adminPrefix+"rotate", adminMethod(http.MethodPost, d.adminAuth(d.handleRotate)))
+	http.HandleFunc(adminPrefix+"reload", adminMethod(http.MethodPost, d.adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		if err := d.reload(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, d.status())
+	})))
+	http.HandleFunc("/healthz", adminMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		d.handleHealth(w, func(c *dtap.HealthConfig) *dtap.HealthCheckConfig { return c.GetLiveness() })
+	}))
+	http.HandleFunc("/readyz", adminMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		d.handleHealth(w, func(c *dtap.HealthConfig) *dtap.HealthCheckConfig { return c.GetReadiness() })
+	}))
+	http.HandleFunc(adminPrefix+"salts/reload", adminMethod(http.MethodPost, d.adminAuth(d.handleReloadSalts)))
+}
+
+// adminAuth allows the POST endpoints only if Admin.Enable is set in the running config,
+// and checks the bearer token if Admin.Token is set.
+func (d *daemon) adminAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, _ := d.current()
+		if !config.Admin.Enable {
+			writeError(w, http.StatusForbidden, errors.New("admin API is disabled, set Admin.Enable"))
+			return
+		}
+		if token := config.Admin.Token; token != "" {
+			auth := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(auth, []byte("Bearer "+token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+func adminMethod(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method must be %s", method))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Debugf("failed to write admin response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+type daemonStatus struct {
+	ConfigPath string     `json:"config_path"`
+	Reloaded   *time.Time `json:"reloaded,omitempty"`
+	pipelineStatus
+}
+
+func (d *daemon) status() daemonStatus {
+	_, p := d.current()
+	s := daemonStatus{ConfigPath: d.configPath, pipelineStatus: p.status()}
+	d.mux.Lock()
+	if !d.reloaded.IsZero() {
+		t := d.reloaded
+		s.Reloaded = &t
+	}
+	d.mux.Unlock()
+	return s
+}
+
+// handleHealth checks the criteria of the running config, it fails with 503.
+func (d *daemon) handleHealth(w http.ResponseWriter, criteria func(*dtap.HealthConfig) *dtap.HealthCheckConfig) {
+	config, p := d.current()
+	s := criteria(&config.Health).Check(p.healthState(), time.Now())
+	code := http.StatusOK
+	if !s.Healthy() {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, s)
+}
+
+// handleOutput handles /api/v1/outputs/{name}/{pause,resume,flush,rotate}.
+func (d *daemon) handleOutput(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, adminPrefix+"outputs/")
+	n := strings.LastIndex(path, "/")
+	if n < 0 {
+		writeError(w, http.StatusNotFound, errors.New("path must be outputs/{name}/{action}"))
+		return
+	}
+	name, action := path[:n], path[n+1:]
+	_, p := d.current()
+	o := p.output(name)
+	if o == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown output: %s", name))
+		return
+	}
+	var err error
+	switch action {
+	case "pause":
+		o.Pause()
+	case "resume":
+		o.Resume()
+	case "flush":
+		err = o.Flush()
+	case "rotate":
+		err = o.Rotate()
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action: %s", action))
+		return
+	}
+	switch {
+	case err == dtap.ErrOutputNotSupported:
+		writeError(w, http.StatusBadRequest, err)
+	case err == dtap.ErrOutputNotConnected:
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, o.Status())
+	}
+}
+
+// handleRotate rotates the files of all outputs which support rotation.
+func (d *daemon) handleRotate(w http.ResponseWriter, r *http.Request) {
+	_, p := d.current()
+	res := struct {
+		Rotated []string          `json:"rotated"`
+		Errors  map[string]string `json:"errors,omitempty"`
+	}{Rotated: []string{}, Errors: map[string]string{}}
+	for _, o := range p.outputs {
+		err := o.Rotate()
+		switch {
+		case err == dtap.ErrOutputNotSupported:
+		case err != nil:
+			res.Errors[o.Name()] = err.Error()
+		default:
+			res.Rotated = append(res.Rotated, o.Name())
+		}
+	}
+	code := http.StatusOK
+	if len(res.Errors) > 0 {
+		code = http.StatusInternalServerError
+	}
+	writeJSON(w, code, res)
+}
+
+// handleReloadSalts reloads the salt files of all outputs.
+func (d *daemon) handleReloadSalts(w http.ResponseWriter, r *http.Request) {
+	config, _ := d.current()
+	res := struct {
+		Reloaded []string          `json:"reloaded"`
+		Errors   map[string]string `json:"errors,omitempty"`
+	}{Reloaded: []string{}, Errors: map[string]string{}}
+	for name, err := range config.ReloadSalts() {
+		if err != nil {
+			res.Errors[name] = err.Error()
+		} else {
+			res.Reloaded = append(res.Reloaded, name)
+		}
+	}
+	sort.Strings(res.Reloaded)
+	code := http.StatusOK
+	if len(res.Errors) > 0 {
+		code = http.StatusInternalServerError
+	}
+	writeJSON(w, code, res)
+}
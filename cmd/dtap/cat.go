@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+	"github.com/mimuret/dtap"
+)
+
+func init() {
+	subcommands["cat"] = &subcommand{
+		summary: "print messages of fstrm files as text or JSON",
+		run:     runCat,
+	}
+}
+
+func runCat(args []string) error {
+	fs := newSubcommandFlagSet("cat", "FILE...")
+	format := fs.String("f", "text", "output format(text,json,pbjson)")
+	head := fs.Int("head", 0, "print only the first N messages")
+	tail := fs.Int("tail", 0, "print only the last N messages")
+	flat := addFlatFlags(fs, 32, 128)
+	tr := addTimeRangeFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *head > 0 && *tail > 0 {
+		return errors.New("head and tail can't be used together")
+	}
+	if err := tr.parse(); err != nil {
+		return err
+	}
+	flatConfig, err := flat.config()
+	if err != nil {
+		return err
+	}
+	var formatter func(dt *dnstap.Dnstap) (string, error)
+	switch *format {
+	case "text":
+		formatter = formatText
+	case "json":
+		formatter = func(dt *dnstap.Dnstap) (string, error) {
+			data, err := dtap.FlatDnstap(dt, flatConfig)
+			if err != nil {
+				return "", err
+			}
+			buf, err := json.Marshal(data)
+			return string(buf), err
+		}
+	case "pbjson":
+		m := &jsonpb.Marshaler{}
+		formatter = func(dt *dnstap.Dnstap) (string, error) {
+			return m.MarshalToString(dt)
+		}
+	default:
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	var printed int
+	var last []string
+	err = readFrames(fs.Args(), func(frame []byte) error {
+		dt := &dnstap.Dnstap{}
+		if err := proto.Unmarshal(frame, dt); err != nil {
+			return fmt.Errorf("failed to parse dnstap frame: %w", err)
+		}
+		if !tr.contains(dtap.MessageTime(dt.GetMessage())) {
+			return nil
+		}
+		s, err := formatter(dt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skip message: %v\n", err)
+			return nil
+		}
+		if *tail > 0 {
+			last = append(last, s)
+			if len(last) > *tail {
+				last = last[1:]
+			}
+			return nil
+		}
+		fmt.Fprintln(w, s)
+		printed++
+		if *head > 0 && printed >= *head {
+			return errStopFrames
+		}
+		return nil
+	})
+	for _, s := range last {
+		fmt.Fprintln(w, s)
+	}
+	return err
+}
+
+// formatText returns the dig like text of the message.
+func formatText(dt *dnstap.Dnstap) (string, error) {
+	msg := dt.GetMessage()
+	if msg == nil {
+		return "", errors.New("no message")
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, ";; %s %s %s %s %s -> %s",
+		dtap.MessageTime(msg).UTC().Format(time.RFC3339Nano),
+		string(dt.GetIdentity()),
+		msg.GetType(),
+		msg.GetSocketProtocol(),
+		net.JoinHostPort(net.IP(msg.GetQueryAddress()).String(), fmt.Sprint(msg.GetQueryPort())),
+		net.JoinHostPort(net.IP(msg.GetResponseAddress()).String(), fmt.Sprint(msg.GetResponsePort())),
+	)
+	for _, wire := range [][]byte{msg.GetQueryMessage(), msg.GetResponseMessage()} {
+		if wire == nil {
+			continue
+		}
+		m := &dns.Msg{}
+		if err := m.Unpack(wire); err != nil {
+			fmt.Fprintf(buf, "\n;; failed to parse message: %v", err)
+			continue
+		}
+		fmt.Fprintf(buf, "\n%s", m.String())
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,334 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	strftime "github.com/jehiah/go-strftime"
+	"github.com/mimuret/dtap"
+	nats "github.com/nats-io/go-nats"
+)
+
+func init() {
+	subcommands["check"] = &subcommand{
+		summary: "check the config file and exit non-zero on problems",
+		run:     runCheck,
+	}
+}
+
+// strftimeDirectives are the directives supported by go-strftime.
+const strftimeDirectives = "BbmAadHIMSYypZzL%"
+
+// configChecker collects the problems of a config.
+type configChecker struct {
+	problems []string
+}
+
+func (c *configChecker) add(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+func runCheck(args []string) error {
+	fs := newSubcommandFlagSet("check", "")
+	configFile := fs.String("c", "dtap.toml", "config file path")
+	connect := fs.Bool("connect", false, "test connections to outputs")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of connection tests")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	buf, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		return err
+	}
+	c := &configChecker{}
+	keys, err := dtap.UnknownConfigKeys(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		c.add("%s:%d: unknown key %s", *configFile, key.Line, key.Key)
+	}
+	config, err := dtap.NewConfigFromReader(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	for _, err := range config.Validate() {
+		for _, line := range strings.Split(strings.TrimSpace(err.Error()), "\n") {
+			c.add("%s", line)
+		}
+	}
+	if len(config.InputUnix)+len(config.InputFile)+len(config.InputTCP)+len(config.InputGenerator) == 0 {
+		c.add("No input settings")
+	}
+	if configOutputs(config) == 0 {
+		c.add("No output settings")
+	}
+	c.checkFiles(config)
+	if *connect {
+		c.checkConnections(config, *timeout)
+	}
+
+	for _, p := range c.problems {
+		fmt.Println(p)
+	}
+	if len(c.problems) > 0 {
+		return fmt.Errorf("%d problems found", len(c.problems))
+	}
+	fmt.Printf("%s: ok\n", *configFile)
+	return nil
+}
+
+// configOutputs returns the number of output settings.
+func configOutputs(config *dtap.Config) int {
+	var n int
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if strings.HasPrefix(v.Type().Field(i).Name, "Output") {
+			n += v.Field(i).Len()
+		}
+	}
+	return n
+}
+
+func (c *configChecker) checkFiles(config *dtap.Config) {
+	for n, i := range config.InputFile {
+		if f, err := os.Open(i.GetPath()); err != nil {
+			c.add("InputFile[%d]: %v", n, err)
+		} else {
+			f.Close()
+		}
+	}
+	for n, i := range config.InputUnix {
+		c.checkDir(fmt.Sprintf("InputUnix[%d]", n), filepath.Dir(i.GetPath()))
+		c.checkUser(fmt.Sprintf("InputUnix[%d]", n), i.GetUser())
+	}
+	for n, i := range config.InputGenerator {
+		if i.QnamesPath != "" {
+			if f, err := os.Open(i.QnamesPath); err != nil {
+				c.add("InputGenerator[%d]: %v", n, err)
+			} else {
+				f.Close()
+			}
+		}
+	}
+	for n, o := range config.OutputFile {
+		c.checkTemplatePath(fmt.Sprintf("OutputFile[%d]", n), o.GetPath())
+		c.checkUser(fmt.Sprintf("OutputFile[%d]", n), o.GetUser())
+	}
+	for n, o := range config.OutputUnique {
+		c.checkTemplatePath(fmt.Sprintf("OutputUnique[%d]", n), o.GetPersistPath())
+	}
+	for n, o := range config.OutputSummary {
+		c.checkTemplatePath(fmt.Sprintf("OutputSummary[%d]", n), o.GetPath())
+	}
+	for n, o := range config.OutputRandomSubdomain {
+		c.checkTemplatePath(fmt.Sprintf("OutputRandomSubdomain[%d]", n), o.GetPath())
+	}
+	for n, o := range config.OutputClientRate {
+		c.checkTemplatePath(fmt.Sprintf("OutputClientRate[%d]", n), o.GetPath())
+	}
+	for n, o := range config.OutputTap {
+		if o.GetPath() != "" {
+			c.checkDir(fmt.Sprintf("OutputTap[%d]", n), filepath.Dir(o.GetPath()))
+			c.checkUser(fmt.Sprintf("OutputTap[%d]", n), o.GetUser())
+		}
+	}
+	for n, o := range config.OutputRSSAC002 {
+		c.checkDir(fmt.Sprintf("OutputRSSAC002[%d]", n), o.GetPath())
+	}
+	c.checkSalts(reflect.ValueOf(config).Elem(), "")
+}
+
+// checkSalts checks the salt files of the flat settings in v.
+func (c *configChecker) checkSalts(v reflect.Value, name string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			c.checkSalts(v.Elem(), name)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			c.checkSalts(v.Index(i), fmt.Sprintf("%s[%d]", name, i))
+		}
+	case reflect.Struct:
+		if flat, ok := v.Addr().Interface().(*dtap.FlatConfig); ok {
+			if path := flat.GetIPHashSaltPath(); path != "" {
+				if f, err := os.Open(path); err != nil {
+					c.add("%s: %v", name, err)
+				} else {
+					f.Close()
+				}
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			child := f.Name
+			if name != "" {
+				child = name + "." + f.Name
+			}
+			c.checkSalts(v.Field(i), child)
+		}
+	}
+}
+
+// checkTemplatePath checks the strftime directives of path and the directory of the current file.
+// Empty path is stdout.
+func (c *configChecker) checkTemplatePath(name, path string) {
+	if path == "" {
+		return
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' {
+			continue
+		}
+		if i+1 == len(path) || !strings.ContainsRune(strftimeDirectives, rune(path[i+1])) {
+			c.add("%s: unknown strftime directive in %s", name, path)
+			return
+		}
+		i++
+	}
+	c.checkDir(name, filepath.Dir(strftime.Format(path, time.Now())))
+}
+
+// checkDir checks that files can be created in dir.
+func (c *configChecker) checkDir(name, dir string) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		c.add("%s: %v", name, err)
+		return
+	}
+	if !st.IsDir() {
+		c.add("%s: %s is not a directory", name, dir)
+		return
+	}
+	f, err := ioutil.TempFile(dir, ".dtap-check-")
+	if err != nil {
+		c.add("%s: %s is not writable: %v", name, dir, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
+
+func (c *configChecker) checkUser(name, username string) {
+	if username == "" {
+		return
+	}
+	if _, err := user.Lookup(username); err != nil {
+		c.add("%s: %v", name, err)
+	}
+}
+
+func (c *configChecker) checkConnections(config *dtap.Config, timeout time.Duration) {
+	dial := func(name, network, address string) {
+		conn, err := net.DialTimeout(network, address, timeout)
+		if err != nil {
+			c.add("%s: %v", name, err)
+			return
+		}
+		conn.Close()
+	}
+	for n, o := range config.OutputUnix {
+		dial(fmt.Sprintf("OutputUnix[%d]", n), "unix", o.GetPath())
+	}
+	for n, o := range config.OutputTCP {
+		dial(fmt.Sprintf("OutputTCP[%d]", n), "tcp", o.GetAddress())
+	}
+	for n, o := range config.OutputFluent {
+		dial(fmt.Sprintf("OutputFluent[%d]", n), "tcp", net.JoinHostPort(o.Host, strconv.Itoa(o.GetPort())))
+	}
+	for n, o := range config.OutputKafka {
+		for _, host := range o.GetHosts() {
+			dial(fmt.Sprintf("OutputKafka[%d]", n), "tcp", host)
+		}
+		for _, registry := range o.GetSchemaRegistries() {
+			address, err := urlAddress(registry)
+			if err != nil {
+				c.add("OutputKafka[%d]: %v", n, err)
+				continue
+			}
+			dial(fmt.Sprintf("OutputKafka[%d]", n), "tcp", address)
+		}
+	}
+	for n, o := range config.OutputNats {
+		opts := []nats.Option{nats.Timeout(timeout)}
+		if o.GetToken() != "" {
+			opts = append(opts, nats.Token(o.GetToken()))
+		} else if o.GetUser() != "" {
+			opts = append(opts, nats.UserInfo(o.GetUser(), o.GetPassword()))
+		}
+		con, err := nats.Connect(o.GetHost(), opts...)
+		if err != nil {
+			c.add("OutputNats[%d]: %v", n, err)
+			continue
+		}
+		con.Close()
+	}
+	for n, o := range config.OutputSummary {
+		if o.GetFluentHost() != "" {
+			dial(fmt.Sprintf("OutputSummary[%d]", n), "tcp", net.JoinHostPort(o.GetFluentHost(), strconv.Itoa(o.GetFluentPort())))
+		}
+	}
+	for n, o := range config.OutputRandomSubdomain {
+		if o.FluentHost != "" {
+			dial(fmt.Sprintf("OutputRandomSubdomain[%d]", n), "tcp", net.JoinHostPort(o.FluentHost, strconv.Itoa(o.GetFluentPort())))
+		}
+	}
+	for n, o := range config.OutputClientRate {
+		if o.FluentHost != "" {
+			dial(fmt.Sprintf("OutputClientRate[%d]", n), "tcp", net.JoinHostPort(o.FluentHost, strconv.Itoa(o.GetFluentPort())))
+		}
+	}
+}
+
+// urlAddress returns host:port of the URL.
+func urlAddress(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", errors.New("invalid URL: " + s)
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "80"), nil
+}
@@ -0,0 +1,399 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	framestream "github.com/farsightsec/golang-framestream"
+	"github.com/golang/protobuf/proto"
+	"github.com/linkedin/goavro"
+	"github.com/mimuret/dtap"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func init() {
+	subcommands["convert"] = &subcommand{
+		summary: "convert fstrm or pcap files to fstrm, JSON lines, CSV, Avro or Parquet",
+		run:     runConvert,
+	}
+}
+
+// convertWriter writes converted messages.
+// rate is the sample rate of the message, 0 without sampling.
+type convertWriter interface {
+	write(frame []byte, dt *dnstap.Dnstap, rate float64) error
+	close() error
+}
+
+func runConvert(args []string) error {
+	fs := newSubcommandFlagSet("convert", "FILE...")
+	format := fs.String("f", "json", "output format(fstrm,json,csv,avro,parquet)")
+	output := fs.String("o", "-", "output file path. - is stdout")
+	configPath := fs.String("c", "", "config file path. Dedup and Transform settings and the Flat and Sampling settings of -output are applied")
+	outputName := fs.String("output", "", "output of the config file whose Flat and Sampling settings are applied, such as OutputFluent[0]. default is the first output with Flat settings")
+	samplingMode := fs.String("sampling", "", "sampling mode(random,client,qname,errors)")
+	samplingRate := fs.Float64("sampling-rate", 1, "sampling rate")
+	in := addInputFlags(fs)
+	flat := addFlatFlags(fs, 32, 128)
+	tr := addTimeRangeFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := in.validate(); err != nil {
+		return err
+	}
+	if err := tr.parse(); err != nil {
+		return err
+	}
+	sampling := &dtap.OutputSamplingConfig{Mode: *samplingMode, Rate: *samplingRate}
+	samplingSet := false
+	fs.Visit(func(f *flag.Flag) {
+		samplingSet = samplingSet || f.Name == "sampling" || f.Name == "sampling-rate"
+	})
+	var flatConfig *dtap.FlatConfig
+	var dedup *dtap.Deduplicator
+	var transform *dtap.Transformer
+	if *configPath != "" {
+		config, err := dtap.NewConfigFromFile(*configPath)
+		if err != nil {
+			return err
+		}
+		if verr := config.Dedup.Validate(); verr != nil {
+			return verr
+		}
+		if verr := config.Transform.Validate(); verr != nil {
+			return verr
+		}
+		dedup = dtap.NewDeduplicator(&config.Dedup)
+		transform = dtap.NewTransformer(&config.Transform)
+		outputFlat, outputSampling, err := config.OutputFilter(*outputName)
+		if err != nil && *outputName != "" {
+			return err
+		}
+		if outputFlat != nil {
+			// flags given in the command line override the config
+			if flatConfig, err = flat.override(fs, outputFlat); err != nil {
+				return err
+			}
+		}
+		if outputSampling != nil && !samplingSet {
+			sampling = outputSampling
+		}
+	} else if *outputName != "" {
+		return errors.New("-output needs -c")
+	}
+	if flatConfig == nil {
+		var err error
+		if flatConfig, err = flat.config(); err != nil {
+			return err
+		}
+	}
+	if verr := sampling.Validate(); verr != nil {
+		return verr
+	}
+	switch *format {
+	case "avro", "parquet":
+		// the schema of assets/flat.avsc has timestamps as strings
+		if f := flatConfig.TimestampFormat; f != "" && f != "rfc3339" {
+			return fmt.Errorf("%s format supports only rfc3339 time-format", *format)
+		}
+	}
+	if *format == "avro" && flatConfig.Fields.IsSet() {
+		return errors.New("avro format doesn't support fields")
+	}
+	sampler := dtap.NewSampler(sampling, "convert")
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create file %s err: %w", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	var cw convertWriter
+	var err error
+	switch *format {
+	case "fstrm":
+		cw, err = newFstrmConvertWriter(bw)
+	case "json":
+		cw = &jsonConvertWriter{w: bw, flat: flatConfig}
+	case "csv":
+		cw, err = newCSVConvertWriter(bw, flatConfig)
+	case "avro":
+		cw, err = newAvroConvertWriter(bw, flatConfig)
+	case "parquet":
+		cw, err = newParquetConvertWriter(bw, flatConfig)
+	default:
+		err = fmt.Errorf("unknown format: %s", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = in.read(fs.Args(), func(frame []byte) error {
+		dt := &dnstap.Dnstap{}
+		if err := proto.Unmarshal(frame, dt); err != nil {
+			return fmt.Errorf("failed to parse dnstap frame: %w", err)
+		}
+		t := dtap.MessageTime(dt.GetMessage())
+		if !tr.contains(t) {
+			return nil
+		}
+		// message times keep the dedup window of the original traffic
+		if dedup.Duplicate(frame, t) {
+			return nil
+		}
+		if transform != nil {
+			transformed, err := transform.Transform(frame)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "skip message: %v\n", err)
+				return nil
+			}
+			frame, dt = transformed, &dnstap.Dnstap{}
+			if err := proto.Unmarshal(frame, dt); err != nil {
+				return fmt.Errorf("failed to parse dnstap frame: %w", err)
+			}
+		}
+		rate, ok := sampler.Sample(dt)
+		if !ok {
+			return nil
+		}
+		return cw.write(frame, dt, rate)
+	})
+	if cerr := cw.close(); err == nil {
+		err = cerr
+	}
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+type fstrmConvertWriter struct {
+	enc *framestream.Encoder
+}
+
+func newFstrmConvertWriter(w io.Writer) (*fstrmConvertWriter, error) {
+	enc, err := framestream.NewEncoder(w, &framestream.EncoderOptions{ContentType: dnstap.FSContentType, Bidirectional: false})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create framestream encorder err: %w", err)
+	}
+	return &fstrmConvertWriter{enc: enc}, nil
+}
+
+func (c *fstrmConvertWriter) write(frame []byte, dt *dnstap.Dnstap, rate float64) error {
+	_, err := c.enc.Write(frame)
+	return err
+}
+
+func (c *fstrmConvertWriter) close() error {
+	if err := c.enc.Flush(); err != nil {
+		return err
+	}
+	return c.enc.Close()
+}
+
+type jsonConvertWriter struct {
+	w    io.Writer
+	flat *dtap.FlatConfig
+}
+
+func (c *jsonConvertWriter) write(frame []byte, dt *dnstap.Dnstap, rate float64) error {
+	data, err := dtap.FlatDnstap(dt, c.flat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skip message: %v\n", err)
+		return nil
+	}
+	data.SampleRate = rate
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	_, err = c.w.Write(buf)
+	return err
+}
+
+func (c *jsonConvertWriter) close() error {
+	return nil
+}
+
+// csvConvertWriter writes the flat fields in the order of FlatFieldNames.
+type csvConvertWriter struct {
+	w       *csv.Writer
+	flat    *dtap.FlatConfig
+	columns []string
+	header  bool
+}
+
+func newCSVConvertWriter(w io.Writer, flat *dtap.FlatConfig) (*csvConvertWriter, error) {
+	fields, err := flat.GetFields()
+	if err != nil {
+		return nil, err
+	}
+	return &csvConvertWriter{
+		w:       csv.NewWriter(w),
+		flat:    flat,
+		columns: fields.Names(dtap.FlatFieldNames),
+	}, nil
+}
+
+func (c *csvConvertWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write(c.columns)
+}
+
+func (c *csvConvertWriter) write(frame []byte, dt *dnstap.Dnstap, rate float64) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	data, err := dtap.FlatDnstap(dt, c.flat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skip message: %v\n", err)
+		return nil
+	}
+	data.SampleRate = rate
+	m := data.ToMapString()
+	record := make([]string, len(c.columns))
+	for i, name := range c.columns {
+		if v, ok := m[name]; ok && v != nil {
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvConvertWriter) close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// avroBlockSize is the number of records in an Avro block.
+const avroBlockSize = 1000
+
+// avroConvertWriter writes an Avro object container file of assets/flat.avsc.
+type avroConvertWriter struct {
+	w       *goavro.OCFWriter
+	flat    *dtap.FlatConfig
+	records []interface{}
+}
+
+func newAvroConvertWriter(w io.Writer, flat *dtap.FlatConfig) (*avroConvertWriter, error) {
+	codec, err := dtap.NewFlatAvroCodec()
+	if err != nil {
+		return nil, err
+	}
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{W: w, Codec: codec, CompressionName: goavro.CompressionDeflateLabel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create avro writer err: %w", err)
+	}
+	return &avroConvertWriter{w: ocf, flat: flat}, nil
+}
+
+func (c *avroConvertWriter) write(frame []byte, dt *dnstap.Dnstap, rate float64) error {
+	data, err := dtap.FlatDnstap(dt, c.flat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skip message: %v\n", err)
+		return nil
+	}
+	data.SampleRate = rate
+	c.records = append(c.records, data.ToMapString())
+	if len(c.records) < avroBlockSize {
+		return nil
+	}
+	return c.flush()
+}
+
+func (c *avroConvertWriter) close() error {
+	return c.flush()
+}
+
+func (c *avroConvertWriter) flush() error {
+	if len(c.records) == 0 {
+		return nil
+	}
+	err := c.w.Append(c.records)
+	c.records = c.records[:0]
+	return err
+}
+
+// parquetRowGroupSize is the number of records written at once to a Parquet file.
+const parquetRowGroupSize = 10000
+
+// parquetConvertWriter writes a Parquet file with the fields of assets/flat.avsc.
+type parquetConvertWriter struct {
+	w    *writer.JSONWriter
+	flat *dtap.FlatConfig
+	rows int
+}
+
+func newParquetConvertWriter(w io.Writer, flat *dtap.FlatConfig) (*parquetConvertWriter, error) {
+	schema, err := dtap.FlatParquetSchema()
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewJSONWriterFromWriter(schema, w, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parquet writer err: %w", err)
+	}
+	return &parquetConvertWriter{w: pw, flat: flat}, nil
+}
+
+func (c *parquetConvertWriter) write(frame []byte, dt *dnstap.Dnstap, rate float64) error {
+	data, err := dtap.FlatDnstap(dt, c.flat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skip message: %v\n", err)
+		return nil
+	}
+	data.SampleRate = rate
+	buf, err := json.Marshal(data.ToMapString())
+	if err != nil {
+		return err
+	}
+	if err := c.w.Write(string(buf)); err != nil {
+		return err
+	}
+	c.rows++
+	if c.rows%parquetRowGroupSize == 0 {
+		return c.w.Flush(true)
+	}
+	return nil
+}
+
+func (c *parquetConvertWriter) close() error {
+	return c.w.WriteStop()
+}
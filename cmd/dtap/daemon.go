@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mimuret/dtap"
+	log "github.com/sirupsen/logrus"
+)
+
+// daemon runs the pipeline of the config file and replaces it on reload.
+type daemon struct {
+	configPath string
+	reloadCh   chan chan error
+
+	mux      sync.Mutex
+	config   *dtap.Config
+	pipeline *pipeline
+	reloaded time.Time
+}
+
+func newDaemon(configPath string, config *dtap.Config, p *pipeline) *daemon {
+	return &daemon{
+		configPath: configPath,
+		reloadCh:   make(chan chan error),
+		config:     config,
+		pipeline:   p,
+	}
+}
+
+func (d *daemon) current() (*dtap.Config, *pipeline) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.config, d.pipeline
+}
+
+// reload asks the run loop to reload the config file and returns the result.
+func (d *daemon) reload() error {
+	res := make(chan error, 1)
+	d.reloadCh <- res
+	return <-res
+}
+
+// run waits for the signals, the reload requests and the end of inputs.
+func (d *daemon) run() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	for {
+		_, p := d.current()
+		select {
+		case <-sigCh:
+			log.Info("recieve signal")
+		case <-hupCh:
+			log.Info("recieve SIGHUP, reload config")
+			if err := d.doReload(); err != nil {
+				log.Error(err)
+			}
+			continue
+		case res := <-d.reloadCh:
+			res <- d.doReload()
+			continue
+		case err := <-p.errCh:
+			log.Error(err)
+		case <-p.inputFinish:
+			log.Debug("finish all input task")
+		}
+		p.stop()
+		return
+	}
+}
+
+// doReload replaces the pipeline with the new config.
+// The running pipeline is kept when the config is invalid, and restarted
+// with the old config when the new pipeline can't be created.
+func (d *daemon) doReload() error {
+	config, err := dtap.NewConfigFromFile(d.configPath)
+	if err != nil {
+		return err
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		msgs := []string{}
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return fmt.Errorf("invalid config: %s", strings.Join(msgs, ", "))
+	}
+	old, oldPipeline := d.current()
+	oldPipeline.stop()
+	p, err := newPipeline(config)
+	if err != nil {
+		p, rerr := newPipeline(old)
+		if rerr != nil {
+			log.Fatalf("failed to restart with the old config: %+v", rerr)
+		}
+		p.start()
+		d.mux.Lock()
+		d.pipeline = p
+		d.mux.Unlock()
+		return fmt.Errorf("failed to reload config, restart with the old config: %w", err)
+	}
+	p.start()
+	d.mux.Lock()
+	d.config, d.pipeline, d.reloaded = config, p, time.Now()
+	d.mux.Unlock()
+	log.Info("reload config")
+	return nil
+}
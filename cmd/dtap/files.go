@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/mimuret/dtap"
+)
+
+// errStopFrames stops readFrames without error.
+var errStopFrames = errors.New("stop")
+
+// readFrames calls fn with each frame of the plain or compressed fstrm files.
+func readFrames(paths []string, fn func(frame []byte) error) error {
+	for _, path := range paths {
+		if err := readFile(path, fn); err != nil {
+			if err == errStopFrames {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(path string, fn func(frame []byte) error) error {
+	i, err := dtap.NewDnstapFstrmFileInput(&dtap.InputFileConfig{Path: path})
+	if err != nil {
+		return err
+	}
+	defer i.Close()
+	for {
+		frame, err := i.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := fn(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// inputFlags select the reader of fstrm and pcap files.
+type inputFlags struct {
+	format   *string
+	pcapType *string
+	pcapPort *uint
+	identity *string
+}
+
+func addInputFlags(fs *flag.FlagSet) *inputFlags {
+	return &inputFlags{
+		format:   fs.String("in", "auto", "input format(auto,fstrm,pcap). auto reads .pcap and .cap files as pcap"),
+		pcapType: fs.String("pcap-type", "client", "message type of pcap messages(auth,resolver,client,forwarder,stub,tool,update)"),
+		pcapPort: fs.Uint("pcap-port", 53, "DNS server port of pcap messages"),
+		identity: fs.String("identity", "", "identity of pcap messages"),
+	}
+}
+
+func (f *inputFlags) validate() error {
+	switch *f.format {
+	case "auto", "fstrm", "pcap":
+	default:
+		return fmt.Errorf("unknown input format: %s", *f.format)
+	}
+	if _, ok := dnstap.Message_Type_value[strings.ToUpper(*f.pcapType)+"_QUERY"]; !ok {
+		return fmt.Errorf("unknown pcap-type: %s", *f.pcapType)
+	}
+	if *f.pcapPort == 0 || *f.pcapPort > 65535 {
+		return errors.New("invalid pcap-port")
+	}
+	return nil
+}
+
+func (f *inputFlags) isPcap(path string) bool {
+	if *f.format != "auto" {
+		return *f.format == "pcap"
+	}
+	for _, ext := range []string{"gz", "bz2", "xz"} {
+		path = strings.TrimSuffix(path, "."+ext)
+	}
+	switch filepath.Ext(path) {
+	case ".pcap", ".cap":
+		return true
+	}
+	return false
+}
+
+// read calls fn with each frame of the files.
+// pcap messages are converted to dnstap frames.
+func (f *inputFlags) read(paths []string, fn func(frame []byte) error) error {
+	for _, path := range paths {
+		var err error
+		if f.isPcap(path) {
+			err = f.readPcap(path, fn)
+		} else {
+			err = readFile(path, fn)
+		}
+		if err != nil {
+			if err == errStopFrames {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *inputFlags) readPcap(path string, fn func(frame []byte) error) error {
+	r, err := dtap.OpenCompressedFile(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	p, err := dtap.NewPcapReader(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	typ := strings.ToUpper(*f.pcapType)
+	p.QueryType = dnstap.Message_Type(dnstap.Message_Type_value[typ+"_QUERY"])
+	p.ResponseType = dnstap.Message_Type(dnstap.Message_Type_value[typ+"_RESPONSE"])
+	p.Port = uint16(*f.pcapPort)
+	if *f.identity != "" {
+		p.Identity = []byte(*f.identity)
+	}
+	for {
+		dt, err := p.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		frame, err := proto.Marshal(dt)
+		if err != nil {
+			return err
+		}
+		if err := fn(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// flatFlags are the FlatConfig settings of subcommands.
+type flatFlags struct {
+	ipv4Mask   *uint
+	ipv6Mask   *uint
+	enableECS  *bool
+	hashIP     *bool
+	saltPath   *string
+	timeFormat *string
+	timezone   *string
+}
+
+// addFlatFlags adds the flags with the default prefix lengths of addresses.
+func addFlatFlags(fs *flag.FlagSet, ipv4Mask, ipv6Mask uint) *flatFlags {
+	return &flatFlags{
+		ipv4Mask:   fs.Uint("ipv4-mask", ipv4Mask, "IPv4 prefix length of addresses"),
+		ipv6Mask:   fs.Uint("ipv6-mask", ipv6Mask, "IPv6 prefix length of addresses"),
+		enableECS:  fs.Bool("ecs", false, "add EDNS client subnet"),
+		hashIP:     fs.Bool("hash-ip", false, "add hashes of addresses"),
+		saltPath:   fs.String("salt", "", "salt file path of address hashes"),
+		timeFormat: fs.String("time-format", "rfc3339", "timestamp format(rfc3339,unix,unix_milli,unix_micro)"),
+		timezone:   fs.String("tz", "", "time zone of rfc3339 timestamps"),
+	}
+}
+
+// override sets the flags given in the command line to the FlatConfig of a config file.
+func (f *flatFlags) override(fs *flag.FlagSet, c *dtap.FlatConfig) (*dtap.FlatConfig, error) {
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "ipv4-mask":
+			c.IPv4Mask = uint8(*f.ipv4Mask)
+		case "ipv6-mask":
+			c.IPv6Mask = uint8(*f.ipv6Mask)
+		case "ecs":
+			c.EnableECS = *f.enableECS
+		case "hash-ip":
+			c.EnableHashIP = *f.hashIP
+		case "salt":
+			c.IPHashSaltPath = *f.saltPath
+		case "time-format":
+			c.TimestampFormat = *f.timeFormat
+		case "tz":
+			c.Timezone = *f.timezone
+		}
+	})
+	if *f.ipv4Mask > 32 || *f.ipv6Mask > 128 {
+		return nil, errors.New("invalid prefix length")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (f *flatFlags) config() (*dtap.FlatConfig, error) {
+	c := &dtap.FlatConfig{
+		IPv4Mask:        uint8(*f.ipv4Mask),
+		IPv6Mask:        uint8(*f.ipv6Mask),
+		EnableECS:       *f.enableECS,
+		EnableHashIP:    *f.hashIP,
+		IPHashSaltPath:  *f.saltPath,
+		TimestampFormat: *f.timeFormat,
+		Timezone:        *f.timezone,
+	}
+	if *f.ipv4Mask > 32 || *f.ipv6Mask > 128 {
+		return nil, errors.New("invalid prefix length")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// timeRange is the -since and -until flags.
+type timeRange struct {
+	since *string
+	until *string
+	start time.Time
+	end   time.Time
+}
+
+func addTimeRangeFlags(fs *flag.FlagSet) *timeRange {
+	return &timeRange{
+		since: fs.String("since", "", "skip messages before the time(RFC3339)"),
+		until: fs.String("until", "", "skip messages at or after the time(RFC3339)"),
+	}
+}
+
+func (r *timeRange) parse() error {
+	var err error
+	if *r.since != "" {
+		if r.start, err = time.Parse(time.RFC3339, *r.since); err != nil {
+			return fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if *r.until != "" {
+		if r.end, err = time.Parse(time.RFC3339, *r.until); err != nil {
+			return fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *timeRange) contains(t time.Time) bool {
+	if !r.start.IsZero() && t.Before(r.start) {
+		return false
+	}
+	if !r.end.IsZero() && !t.Before(r.end) {
+		return false
+	}
+	return true
+}
+
+// dedupFlags are the flags dropping identical frames.
+type dedupFlags struct {
+	enable *bool
+	window *time.Duration
+	key    *string
+}
+
+func addDedupFlags(fs *flag.FlagSet) *dedupFlags {
+	return &dedupFlags{
+		enable: fs.Bool("dedup", false, "drop identical frames within the dedup window of message time"),
+		window: fs.Duration("dedup-window", 5*time.Second, "dedup window"),
+		key:    fs.String("dedup-key", "payload", "dedup key(payload,message)"),
+	}
+}
+
+// deduplicator returns nil without -dedup.
+func (f *dedupFlags) deduplicator() (*dtap.Deduplicator, error) {
+	if *f.window < time.Second {
+		return nil, errors.New("dedup-window must be at least 1s")
+	}
+	c := &dtap.DedupConfig{
+		Enable: *f.enable,
+		Key:    *f.key,
+		Window: uint(*f.window / time.Second),
+	}
+	if verr := c.Validate(); verr != nil {
+		return nil, verr
+	}
+	return dtap.NewDeduplicator(c), nil
+}
+
+// parseSize parses a size with the K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	num, unit := s, int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		num = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * unit, nil
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/mimuret/dtap"
+)
+
+func init() {
+	subcommands["gen"] = &subcommand{
+		summary: "generate synthetic dnstap messages to a fstrm file or receiver",
+		run:     runGen,
+	}
+}
+
+func runGen(args []string) error {
+	fs := newSubcommandFlagSet("gen", "")
+	profile := fs.String("p", "", "generator profile path. the keys are the same as [[InputGenerator]]")
+	rate := fs.Uint("rate", 0, "queries per second. overrides the profile")
+	count := fs.Uint64("count", 0, "number of queries. overrides the profile")
+	duration := fs.Duration("duration", 0, "generate queries for the duration")
+	seed := fs.Int64("seed", 0, "random seed. overrides the profile")
+	start := fs.String("start", "", "time of the first query of a file(RFC3339). default is now")
+	output := fs.String("o", "", "write to the fstrm file as fast as possible. compressed by the gz or xz suffix")
+	unixPath := fs.String("unix", "", "send to the fstrm unix socket path in real time")
+	tcpAddress := fs.String("tcp", "", "send to the fstrm tcp address(host:port) in real time")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	config := &dtap.InputGeneratorConfig{}
+	if *profile != "" {
+		var err error
+		if config, err = dtap.NewInputGeneratorConfigFromFile(*profile); err != nil {
+			return err
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "rate":
+			config.Rate = *rate
+		case "count":
+			config.Count = *count
+		case "seed":
+			config.Seed = *seed
+		}
+	})
+	if *duration < 0 {
+		return errors.New("duration must not be negative")
+	}
+	if *duration > 0 {
+		n := uint64(duration.Seconds() * float64(config.GetRate()))
+		if config.Count == 0 || n < config.Count {
+			config.Count = n
+		}
+	}
+
+	switch {
+	case *output != "" && *unixPath == "" && *tcpAddress == "":
+		t := time.Now()
+		if *start != "" {
+			var err error
+			if t, err = time.Parse(time.RFC3339, *start); err != nil {
+				return fmt.Errorf("invalid start: %w", err)
+			}
+		}
+		if config.Count == 0 {
+			return errors.New("count or duration is required to write a file")
+		}
+		return genFile(config, *output, t)
+	case *unixPath != "" && *output == "" && *tcpAddress == "":
+		return genSocket(config, "unix", *unixPath)
+	case *tcpAddress != "" && *output == "" && *unixPath == "":
+		return genSocket(config, "tcp", *tcpAddress)
+	}
+	return errors.New("one of -o, -unix and -tcp is required")
+}
+
+// genFile writes queries with the virtual time from start.
+func genFile(config *dtap.InputGeneratorConfig, path string, start time.Time) error {
+	g, err := dtap.NewGenerator(config)
+	if err != nil {
+		return err
+	}
+	w, err := dtap.NewFstrmFileWriter(path)
+	if err != nil {
+		return err
+	}
+	write := func(dts []*dnstap.Dnstap) error {
+		for _, dt := range dts {
+			buf, err := proto.Marshal(dt)
+			if err != nil {
+				return err
+			}
+			if err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	interval := float64(time.Second) / float64(config.GetRate())
+	for n := uint64(0); n < config.GetCount(); n++ {
+		if err := write(g.Next(start.Add(time.Duration(float64(n) * interval)))); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := write(g.Flush()); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// genSocket sends queries at the rate with the current time until count or a signal.
+func genSocket(config *dtap.InputGeneratorConfig, network, address string) error {
+	i, err := dtap.NewDnstapGeneratorInput(config)
+	if err != nil {
+		return err
+	}
+	enc, err := dtap.DialFstrm(network, address)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+	err = i.Generate(ctx, func(frame []byte) error {
+		_, err := enc.Write(frame)
+		return err
+	})
+	if err != nil {
+		enc.Close()
+		return fmt.Errorf("failed to write frame: %w", err)
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	return enc.Close()
+}
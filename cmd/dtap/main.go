@@ -21,10 +21,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"runtime"
-	"sync"
-	"syscall"
+	"time"
 
 	"github.com/mimuret/dtap"
 	log "github.com/sirupsen/logrus"
@@ -43,12 +41,25 @@ var (
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s [OPTION]...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s SUBCOMMAND [OPTION]... [ARG]...\n", os.Args[0])
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "Subcommands:\n")
+	for _, name := range subcommandNames() {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, subcommands[name].summary)
+	}
 }
 
-func outputLoop(output []dtap.Output, irbuf *dtap.RBuf) {
+func outputLoop(output []dtap.Output, irbuf *dtap.RBuf, dedup *dtap.Deduplicator, transform *dtap.Transformer) {
 	log.Info("start outputLoop")
 	for frame := range irbuf.Read() {
+		if dedup.Duplicate(frame, time.Now()) {
+			continue
+		}
+		frame, err := transform.Transform(frame)
+		if err != nil {
+			log.Debug(err)
+			continue
+		}
 		for _, o := range output {
 			o.SetMessage(frame)
 		}
@@ -63,10 +74,11 @@ func fatalCheck(err error) {
 }
 
 func main() {
-	var err error
-
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	flag.Usage = usage
+	if runSubcommand(os.Args[1:]) {
+		return
+	}
 
 	flag.Parse()
 	// set log level
@@ -85,192 +97,22 @@ func main() {
 		usage()
 		os.Exit(1)
 	}
-	var input []dtap.Input
-	var output []dtap.Output
-	go prometheusExporter(context.Background(), *flagExporterListen)
 	config, err := dtap.NewConfigFromFile(*flagConfigFile)
 	fatalCheck(err)
-	for _, ic := range config.InputFile {
-		i, err := dtap.NewDnstapFstrmFileInput(ic)
-		fatalCheck(err)
-		input = append(input, i)
-	}
-
-	for _, ic := range config.InputTCP {
-		i, err := dtap.NewDnstapFstrmTCPSocketInput(ic)
-		fatalCheck(err)
-		input = append(input, i)
-	}
-
-	for _, ic := range config.InputUnix {
-		i, err := dtap.NewDnstapFstrmUnixSocketInput(ic)
-		fatalCheck(err)
-		input = append(input, i)
-	}
-
-	if len(input) == 0 {
-		log.Fatal("No input settings")
-	}
-
-	for _, oc := range config.OutputFile {
-		params := &dtap.DnstapOutputParams{
-			BufferSize:  oc.Buffer.GetBufferSize(),
-			InCounter:   TotalRecvOutputFrame,
-			LostCounter: TotalLostInputFrame,
-		}
-		o := dtap.NewDnstapFstrmFileOutput(oc, params)
-		output = append(output, o)
-	}
-
-	for _, oc := range config.OutputTCP {
-		params := &dtap.DnstapOutputParams{
-			BufferSize:  oc.Buffer.GetBufferSize(),
-			InCounter:   TotalRecvOutputFrame,
-			LostCounter: TotalLostInputFrame,
-		}
-		o := dtap.NewDnstapFstrmTCPSocketOutput(oc, params)
-		output = append(output, o)
-	}
-
-	for _, oc := range config.OutputUnix {
-		params := &dtap.DnstapOutputParams{
-			BufferSize:  oc.Buffer.GetBufferSize(),
-			InCounter:   TotalRecvOutputFrame,
-			LostCounter: TotalLostInputFrame,
-		}
-		o := dtap.NewDnstapFstrmUnixSockOutput(oc, params)
-		output = append(output, o)
-	}
-
-	for _, oc := range config.OutputFluent {
-		params := &dtap.DnstapOutputParams{
-			BufferSize:  oc.Buffer.GetBufferSize(),
-			InCounter:   TotalRecvOutputFrame,
-			LostCounter: TotalLostInputFrame,
-		}
-		o := dtap.NewDnstapFluentdOutput(oc, params)
-		output = append(output, o)
-		if oc.Flat.GetIPHashSaltPath() != "" {
-			ready := make(chan struct{})
-			go oc.Flat.WatchSalt(context.Background(), ready)
-			log.Info("wait for ready to watch salt files")
-			<-ready
-		}
-	}
-
-	for _, oc := range config.OutputKafka {
-		params := &dtap.DnstapOutputParams{
-			BufferSize:  oc.Buffer.GetBufferSize(),
-			InCounter:   TotalRecvOutputFrame,
-			LostCounter: TotalLostInputFrame,
+	if errs := config.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
 		}
-		o, err := dtap.NewDnstapKafkaOutput(oc, params)
-		if err != nil {
-			log.Fatal(err)
-		}
-		output = append(output, o)
-	}
-
-	for _, oc := range config.OutputNats {
-		params := &dtap.DnstapOutputParams{
-			BufferSize:  oc.Buffer.GetBufferSize(),
-			InCounter:   TotalRecvOutputFrame,
-			LostCounter: TotalLostInputFrame,
-		}
-		o := dtap.NewDnstapNatsOutput(oc, params)
-		output = append(output, o)
-		if oc.Flat.GetIPHashSaltPath() != "" {
-			ready := make(chan struct{})
-			go oc.Flat.WatchSalt(context.Background(), ready)
-			log.Info("wait for ready to watch salt files")
-			<-ready
-		}
-	}
-
-	for _, oc := range config.OutputPrometheus {
-		params := &dtap.DnstapOutputParams{
-			BufferSize:  oc.Buffer.GetBufferSize(),
-			InCounter:   TotalRecvOutputFrame,
-			LostCounter: TotalLostInputFrame,
-		}
-		o := dtap.NewDnstapPrometheusOutput(oc, params)
-		output = append(output, o)
-	}
-	for _, oc := range config.OutputStdout {
-		params := &dtap.DnstapOutputParams{
-			BufferSize:  oc.Buffer.GetBufferSize(),
-			InCounter:   TotalRecvOutputFrame,
-			LostCounter: TotalLostInputFrame,
-		}
-		o := dtap.NewDnstapStdoutOutput(oc, params)
-		output = append(output, o)
-	}
-
-	if len(output) == 0 {
-		log.Fatal("No output settings")
-	}
-
-	iRBuf := dtap.NewRbuf(config.InputMsgBuffer, TotalRecvInputFrame, TotalLostInputFrame)
-	fatalCh := make(chan error)
-
-	outputCtx, outputCancel := context.WithCancel(context.Background())
-	owg := &sync.WaitGroup{}
-	for _, o := range output {
-		child, _ := context.WithCancel(outputCtx)
-		owg.Add(1)
-		go func(o dtap.Output) {
-			o.Run(child)
-			owg.Done()
-		}(o)
+		log.Fatalf("invalid config, run `%s check -c %s` for details", os.Args[0], *flagConfigFile)
 	}
-	go outputLoop(output, iRBuf)
-
-	inputCtx, intputCancel := context.WithCancel(context.Background())
-
-	iwg := &sync.WaitGroup{}
-	for _, i := range input {
-		child, _ := context.WithCancel(inputCtx)
-		iwg.Add(1)
-		go func(i dtap.Input) {
-			err := i.Run(child, iRBuf)
-			if err != nil {
-				log.Error(err)
-				fatalCh <- err
-			}
-			iwg.Done()
-		}(i)
-	}
-	inputFinish := make(chan struct{})
-	go func() {
-		iwg.Wait()
-		close(inputFinish)
-	}()
-
+	p, err := newPipeline(config)
+	fatalCheck(err)
+	d := newDaemon(*flagConfigFile, config, p)
+	handleAdmin(d)
+	go prometheusExporter(context.Background(), *flagExporterListen)
+	p.start()
 	log.Info("finish boot dtap")
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT)
-
-	select {
-	case <-sigCh:
-		log.Info("recieve signal")
-	case err := <-fatalCh:
-		log.Error(err)
-	case <-inputFinish:
-		log.Debug("finish all input task")
-	}
-
-	log.Info("wait finish input task")
-	intputCancel()
-	iwg.Wait()
-	log.Info("done")
-
-	log.Info("wait finish output task")
-	outputCancel()
-	owg.Wait()
-	log.Info("done")
-
-	iRBuf.Close()
-
+	d.run()
 	os.Exit(0)
 }
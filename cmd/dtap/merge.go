@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mimuret/dtap"
+)
+
+func init() {
+	subcommands["merge"] = &subcommand{
+		summary: "merge fstrm files into a time-ordered fstrm file",
+		run:     runMerge,
+	}
+}
+
+func runMerge(args []string) error {
+	fs := newSubcommandFlagSet("merge", "FILE...")
+	output := fs.String("o", "", "output file path. compressed by the gz or xz suffix")
+	window := fs.Duration("reorder", 5*time.Second, "reorder window of the message time. frames older than the written ones by more than it are written out of order")
+	dd := addDedupFlags(fs)
+	tr := addTimeRangeFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 || *output == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := tr.parse(); err != nil {
+		return err
+	}
+	dedup, err := dd.deduplicator()
+	if err != nil {
+		return err
+	}
+
+	sources := []dtap.FrameSource{}
+	for _, path := range fs.Args() {
+		i, err := dtap.NewDnstapFstrmFileInput(&dtap.InputFileConfig{Path: path})
+		if err != nil {
+			return err
+		}
+		defer i.Close()
+		path := path
+		sources = append(sources, func() ([]byte, error) {
+			frame, err := i.Next()
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return frame, err
+		})
+	}
+	w, err := dtap.NewFstrmFileWriter(*output)
+	if err != nil {
+		return err
+	}
+	var frames, duplicates uint64
+	inversions, err := dtap.MergeFrames(sources, *window, func(frame []byte, t time.Time) error {
+		if !tr.contains(t) {
+			return nil
+		}
+		if dedup.Duplicate(frame, t) {
+			duplicates++
+			return nil
+		}
+		frames++
+		return w.Write(frame)
+	})
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if duplicates > 0 {
+		fmt.Fprintf(os.Stderr, "drop %d duplicate frames\n", duplicates)
+	}
+	if inversions > 0 {
+		fmt.Fprintf(os.Stderr, "write %d frames out of order, make -reorder larger\n", inversions)
+	}
+	if err == nil {
+		fmt.Printf("%s\t%d\n", *output, frames)
+	}
+	return err
+}
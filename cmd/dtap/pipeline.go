@@ -0,0 +1,378 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mimuret/dtap"
+	log "github.com/sirupsen/logrus"
+)
+
+// pipelineInput is an input with the status.
+type pipelineInput struct {
+	name  string
+	input dtap.Input
+
+	mux           sync.Mutex
+	running       bool
+	lastError     error
+	lastErrorTime time.Time
+}
+
+func (i *pipelineInput) run(ctx context.Context, rbuf *dtap.RBuf) error {
+	i.mux.Lock()
+	i.running = true
+	i.mux.Unlock()
+	err := i.input.Run(ctx, rbuf)
+	i.mux.Lock()
+	i.running = false
+	if err != nil {
+		i.lastError, i.lastErrorTime = err, time.Now()
+	}
+	i.mux.Unlock()
+	return err
+}
+
+func (i *pipelineInput) status() dtap.InputStatus {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	s := dtap.InputStatus{Name: i.name, Running: i.running}
+	if i.lastError != nil {
+		t := i.lastErrorTime
+		s.LastError, s.LastErrorTime = i.lastError.Error(), &t
+	}
+	return s
+}
+
+// pipeline is the inputs and outputs of a config.
+type pipeline struct {
+	config  *dtap.Config
+	inputs  []*pipelineInput
+	outputs []*dtap.DnstapOutput
+	iRBuf   *dtap.RBuf
+
+	errCh       chan error
+	inputFinish chan struct{}
+	loopFinish  chan struct{}
+	started     time.Time
+
+	inputCancel  context.CancelFunc
+	outputCancel context.CancelFunc
+	saltCancel   context.CancelFunc
+	iwg          *sync.WaitGroup
+	owg          *sync.WaitGroup
+}
+
+// newPipeline creates the inputs and outputs of the config.
+// The listeners created before an error are closed.
+func newPipeline(config *dtap.Config) (*pipeline, error) {
+	p := &pipeline{config: config}
+	if err := p.build(); err != nil {
+		p.release()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *pipeline) addInput(name string, i dtap.Input, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	p.inputs = append(p.inputs, &pipelineInput{name: name, input: i})
+	return nil
+}
+
+func (p *pipeline) addOutput(name string, o *dtap.DnstapOutput, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	p.outputs = append(p.outputs, o)
+	return nil
+}
+
+func (p *pipeline) params(name string, buffer *dtap.OutputBufferConfig) *dtap.DnstapOutputParams {
+	return &dtap.DnstapOutputParams{
+		Name:        name,
+		BufferSize:  buffer.GetBufferSize(),
+		InCounter:   TotalRecvOutputFrame,
+		LostCounter: TotalLostInputFrame,
+	}
+}
+
+func (p *pipeline) build() error {
+	config := p.config
+	for n, ic := range config.InputFile {
+		i, err := dtap.NewDnstapFstrmFileInput(ic)
+		if err := p.addInput(fmt.Sprintf("InputFile[%d]", n), i, err); err != nil {
+			return err
+		}
+	}
+	for n, ic := range config.InputTCP {
+		i, err := dtap.NewDnstapFstrmTCPSocketInput(ic)
+		if err := p.addInput(fmt.Sprintf("InputTCP[%d]", n), i, err); err != nil {
+			return err
+		}
+	}
+	for n, ic := range config.InputUnix {
+		i, err := dtap.NewDnstapFstrmUnixSocketInput(ic)
+		if err := p.addInput(fmt.Sprintf("InputUnix[%d]", n), i, err); err != nil {
+			return err
+		}
+	}
+	for n, ic := range config.InputGenerator {
+		i, err := dtap.NewDnstapGeneratorInput(ic)
+		if err := p.addInput(fmt.Sprintf("InputGenerator[%d]", n), i, err); err != nil {
+			return err
+		}
+	}
+	if len(p.inputs) == 0 {
+		return errors.New("No input settings")
+	}
+
+	for n, oc := range config.OutputFile {
+		name := fmt.Sprintf("OutputFile[%d]", n)
+		p.addOutput(name, dtap.NewDnstapFstrmFileOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputTCP {
+		name := fmt.Sprintf("OutputTCP[%d]", n)
+		p.addOutput(name, dtap.NewDnstapFstrmTCPSocketOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputUnix {
+		name := fmt.Sprintf("OutputUnix[%d]", n)
+		p.addOutput(name, dtap.NewDnstapFstrmUnixSockOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputFluent {
+		name := fmt.Sprintf("OutputFluent[%d]", n)
+		p.addOutput(name, dtap.NewDnstapFluentdOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputKafka {
+		name := fmt.Sprintf("OutputKafka[%d]", n)
+		o, err := dtap.NewDnstapKafkaOutput(oc, p.params(name, &oc.Buffer))
+		if err := p.addOutput(name, o, err); err != nil {
+			return err
+		}
+	}
+	for n, oc := range config.OutputNats {
+		name := fmt.Sprintf("OutputNats[%d]", n)
+		p.addOutput(name, dtap.NewDnstapNatsOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputPrometheus {
+		name := fmt.Sprintf("OutputPrometheus[%d]", n)
+		p.addOutput(name, dtap.NewDnstapPrometheusOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputStdout {
+		name := fmt.Sprintf("OutputStdout[%d]", n)
+		p.addOutput(name, dtap.NewDnstapStdoutOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputTopK {
+		name := fmt.Sprintf("OutputTopK[%d]", n)
+		p.addOutput(name, dtap.NewDnstapTopKOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputUnique {
+		name := fmt.Sprintf("OutputUnique[%d]", n)
+		p.addOutput(name, dtap.NewDnstapUniqueOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputSummary {
+		name := fmt.Sprintf("OutputSummary[%d]", n)
+		p.addOutput(name, dtap.NewDnstapSummaryOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputRSSAC002 {
+		name := fmt.Sprintf("OutputRSSAC002[%d]", n)
+		p.addOutput(name, dtap.NewDnstapRSSAC002Output(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputRandomSubdomain {
+		name := fmt.Sprintf("OutputRandomSubdomain[%d]", n)
+		p.addOutput(name, dtap.NewDnstapRandomSubdomainOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputClientRate {
+		name := fmt.Sprintf("OutputClientRate[%d]", n)
+		p.addOutput(name, dtap.NewDnstapClientRateOutput(oc, p.params(name, &oc.Buffer)), nil)
+	}
+	for n, oc := range config.OutputTap {
+		name := fmt.Sprintf("OutputTap[%d]", n)
+		o, err := dtap.NewDnstapTapOutput(oc, p.params(name, &oc.Buffer))
+		if err := p.addOutput(name, o, err); err != nil {
+			return err
+		}
+	}
+	if len(p.outputs) == 0 {
+		return errors.New("No output settings")
+	}
+	return nil
+}
+
+// release closes the listeners of the pipeline which is not started.
+func (p *pipeline) release() {
+	for _, i := range p.inputs {
+		if c, ok := i.input.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	for _, o := range p.outputs {
+		o.Release()
+	}
+}
+
+// watchSalts starts the salt file watchers of the Fluent and Nats outputs.
+func (p *pipeline) watchSalts(ctx context.Context) {
+	var flats []*dtap.FlatConfig
+	for _, oc := range p.config.OutputFluent {
+		flats = append(flats, &oc.Flat)
+	}
+	for _, oc := range p.config.OutputNats {
+		flats = append(flats, &oc.Flat)
+	}
+	for _, flat := range flats {
+		if flat.GetIPHashSaltPath() != "" {
+			ready := make(chan struct{})
+			go flat.WatchSalt(ctx, ready)
+			log.Info("wait for ready to watch salt files")
+			<-ready
+		}
+	}
+}
+
+func (p *pipeline) start() {
+	p.started = time.Now()
+	p.iRBuf = dtap.NewRbuf(p.config.InputMsgBuffer, TotalRecvInputFrame, TotalLostInputFrame)
+	p.errCh = make(chan error, len(p.inputs))
+	p.inputFinish = make(chan struct{})
+
+	var saltCtx context.Context
+	saltCtx, p.saltCancel = context.WithCancel(context.Background())
+	p.watchSalts(saltCtx)
+
+	var outputCtx context.Context
+	outputCtx, p.outputCancel = context.WithCancel(context.Background())
+	p.owg = &sync.WaitGroup{}
+	output := make([]dtap.Output, len(p.outputs))
+	for n, o := range p.outputs {
+		output[n] = o
+		p.owg.Add(1)
+		go func(o dtap.Output) {
+			o.Run(outputCtx)
+			p.owg.Done()
+		}(o)
+	}
+	p.loopFinish = make(chan struct{})
+	go func() {
+		outputLoop(output, p.iRBuf, dtap.NewDeduplicator(&p.config.Dedup), dtap.NewTransformer(&p.config.Transform))
+		close(p.loopFinish)
+	}()
+
+	var inputCtx context.Context
+	inputCtx, p.inputCancel = context.WithCancel(context.Background())
+	p.iwg = &sync.WaitGroup{}
+	for _, i := range p.inputs {
+		p.iwg.Add(1)
+		go func(i *pipelineInput) {
+			if err := i.run(inputCtx, p.iRBuf); err != nil {
+				log.Error(err)
+				p.errCh <- err
+			}
+			p.iwg.Done()
+		}(i)
+	}
+	go func() {
+		p.iwg.Wait()
+		close(p.inputFinish)
+	}()
+}
+
+// stop stops the inputs before the outputs.
+// The frames in the input buffer and the output buffers are written before the outputs are stopped.
+func (p *pipeline) stop() {
+	log.Info("wait finish input task")
+	p.inputCancel()
+	p.iwg.Wait()
+	log.Info("done")
+
+	log.Info("wait drain buffers")
+	p.iRBuf.Close()
+	<-p.loopFinish
+	wg := &sync.WaitGroup{}
+	for _, o := range p.outputs {
+		wg.Add(1)
+		go func(o *dtap.DnstapOutput) {
+			if err := o.Drain(); err != nil && err != dtap.ErrOutputNotConnected {
+				log.Warnf("failed to drain output %s: %v", o.Name(), err)
+			}
+			wg.Done()
+		}(o)
+	}
+	wg.Wait()
+	log.Info("done")
+
+	log.Info("wait finish output task")
+	p.outputCancel()
+	p.owg.Wait()
+	log.Info("done")
+
+	p.saltCancel()
+}
+
+// output returns the output of the name such as OutputFile[0].
+func (p *pipeline) output(name string) *dtap.DnstapOutput {
+	for _, o := range p.outputs {
+		if o.Name() == name {
+			return o
+		}
+	}
+	return nil
+}
+
+func (p *pipeline) healthState() *dtap.HealthState {
+	s := p.status()
+	return &dtap.HealthState{
+		Started:        s.Started,
+		Inputs:         s.Inputs,
+		Outputs:        s.Outputs,
+		InputBufferLen: s.InputBufferLen,
+		InputBufferCap: s.InputBufferCap,
+	}
+}
+
+type pipelineStatus struct {
+	Started        time.Time           `json:"started"`
+	Inputs         []dtap.InputStatus  `json:"inputs"`
+	Outputs        []dtap.OutputStatus `json:"outputs"`
+	InputBufferLen int                 `json:"input_buffer_len"`
+	InputBufferCap int                 `json:"input_buffer_cap"`
+}
+
+func (p *pipeline) status() pipelineStatus {
+	s := pipelineStatus{
+		Started:        p.started,
+		Inputs:         []dtap.InputStatus{},
+		Outputs:        []dtap.OutputStatus{},
+		InputBufferLen: p.iRBuf.Len(),
+		InputBufferCap: p.iRBuf.Cap(),
+	}
+	for _, i := range p.inputs {
+		s.Inputs = append(s.Inputs, i.status())
+	}
+	for _, o := range p.outputs {
+		s.Outputs = append(s.Outputs, o.Status())
+	}
+	return s
+}
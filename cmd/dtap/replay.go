@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	framestream "github.com/farsightsec/golang-framestream"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+	"github.com/mimuret/dtap"
+)
+
+func init() {
+	subcommands["replay"] = &subcommand{
+		summary: "resend messages of fstrm files to a fstrm receiver or a DNS server",
+		run:     runReplay,
+	}
+}
+
+// replaySender sends a replayed message.
+// send returns false if the message is skipped.
+type replaySender interface {
+	send(frame []byte, dt *dnstap.Dnstap) (bool, error)
+	flush() error
+	close()
+}
+
+func runReplay(args []string) error {
+	fs := newSubcommandFlagSet("replay", "FILE...")
+	unixPath := fs.String("unix", "", "fstrm unix socket path of the receiver")
+	tcpAddress := fs.String("tcp", "", "fstrm tcp address(host:port) of the receiver")
+	dnsAddress := fs.String("dns", "", "send queries to the DNS server address(host:port)")
+	dnsNet := fs.String("dns-net", "udp", "transport of DNS queries(udp,tcp)")
+	speed := fs.Float64("speed", 1, "replay speed multiplier. 0 sends as fast as possible")
+	loop := fs.Int("loop", 1, "number of replays. 0 replays forever")
+	rate := fs.Float64("rate", 0, "maximum messages per second. 0 is unlimited")
+	report := fs.Duration("report", 10*time.Second, "interval of progress reports. 0 disables them")
+	tr := addTimeRangeFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *speed < 0 || *rate < 0 || *loop < 0 {
+		return errors.New("speed, rate and loop must not be negative")
+	}
+	if err := tr.parse(); err != nil {
+		return err
+	}
+
+	var sender replaySender
+	var err error
+	switch {
+	case *unixPath != "" && *tcpAddress == "" && *dnsAddress == "":
+		sender, err = newFstrmReplaySender("unix", *unixPath)
+	case *tcpAddress != "" && *unixPath == "" && *dnsAddress == "":
+		sender, err = newFstrmReplaySender("tcp", *tcpAddress)
+	case *dnsAddress != "" && *unixPath == "" && *tcpAddress == "":
+		sender, err = newDNSReplaySender(*dnsNet, *dnsAddress)
+	default:
+		return errors.New("one of unix, tcp and dns is required")
+	}
+	if err != nil {
+		return err
+	}
+	defer sender.close()
+
+	r := &replayer{
+		sender: sender,
+		speed:  *speed,
+		report: *report,
+		tr:     tr,
+		start:  time.Now(),
+	}
+	if *rate > 0 {
+		r.interval = time.Duration(float64(time.Second) / *rate)
+	}
+	r.lastReport = r.start
+	for n := 0; *loop == 0 || n < *loop; n++ {
+		r.base = time.Time{}
+		if err := readFrames(fs.Args(), r.replay); err != nil {
+			return err
+		}
+	}
+	if err := sender.flush(); err != nil {
+		return err
+	}
+	r.printReport("done")
+	return nil
+}
+
+// replayer paces messages by the message times.
+type replayer struct {
+	sender     replaySender
+	speed      float64
+	interval   time.Duration
+	report     time.Duration
+	tr         *timeRange
+	start      time.Time
+	base       time.Time
+	baseWall   time.Time
+	next       time.Time
+	lastReport time.Time
+	sent       uint64
+	skipped    uint64
+}
+
+func (r *replayer) replay(frame []byte) error {
+	dt := &dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, dt); err != nil {
+		return fmt.Errorf("failed to parse dnstap frame: %w", err)
+	}
+	t := dtap.MessageTime(dt.GetMessage())
+	if !t.IsZero() && !r.tr.contains(t) {
+		return nil
+	}
+	r.wait(t)
+	ok, err := r.sender.send(frame, dt)
+	if err != nil {
+		return err
+	}
+	if ok {
+		r.sent++
+	} else {
+		r.skipped++
+	}
+	if r.report > 0 && time.Since(r.lastReport) >= r.report {
+		r.lastReport = time.Now()
+		r.printReport("progress")
+	}
+	return nil
+}
+
+// wait sleeps until the original offset of t divided by speed,
+// and until the rate limit allows the next message.
+func (r *replayer) wait(t time.Time) {
+	now := time.Now()
+	var until time.Time
+	if r.speed > 0 && !t.IsZero() {
+		if r.base.IsZero() {
+			r.base, r.baseWall = t, now
+		}
+		until = r.baseWall.Add(time.Duration(float64(t.Sub(r.base)) / r.speed))
+	}
+	if r.interval > 0 {
+		if r.next.After(until) {
+			until = r.next
+		}
+	}
+	if d := until.Sub(now); d > 0 {
+		// the receiver gets buffered frames before idling
+		if err := r.sender.flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to flush: %v\n", err)
+		}
+		time.Sleep(d)
+		now = time.Now()
+	}
+	if r.interval > 0 {
+		r.next = now.Add(r.interval)
+	}
+}
+
+func (r *replayer) printReport(state string) {
+	elapsed := time.Since(r.start)
+	var achieved float64
+	if elapsed > 0 {
+		achieved = float64(r.sent) / elapsed.Seconds()
+	}
+	fmt.Fprintf(os.Stderr, "%s: sent %d skipped %d in %s (%.1f msg/s)\n",
+		state, r.sent, r.skipped, elapsed.Truncate(time.Millisecond), achieved)
+}
+
+// fstrmReplaySender sends frames with the encoder of the fstrm socket outputs.
+type fstrmReplaySender struct {
+	enc *framestream.Encoder
+}
+
+func newFstrmReplaySender(network, address string) (*fstrmReplaySender, error) {
+	enc, err := dtap.DialFstrm(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &fstrmReplaySender{enc: enc}, nil
+}
+
+func (s *fstrmReplaySender) send(frame []byte, dt *dnstap.Dnstap) (bool, error) {
+	if _, err := s.enc.Write(frame); err != nil {
+		return false, fmt.Errorf("failed to write frame: %w", err)
+	}
+	return true, nil
+}
+
+func (s *fstrmReplaySender) flush() error {
+	return s.enc.Flush()
+}
+
+func (s *fstrmReplaySender) close() {
+	s.enc.Close()
+}
+
+// dnsReplaySender sends query messages to a DNS server.
+// Responses are read and discarded.
+type dnsReplaySender struct {
+	conn *dns.Conn
+}
+
+func newDNSReplaySender(network, address string) (*dnsReplaySender, error) {
+	switch strings.ToLower(network) {
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("unknown dns-net: %s", network)
+	}
+	conn, err := dns.Dial(strings.ToLower(network), address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect DNS server, address: %s err: %w", address, err)
+	}
+	conn.UDPSize = dns.MaxMsgSize
+	go func() {
+		for {
+			if _, err := conn.ReadMsgHeader(nil); err != nil {
+				if err == dns.ErrShortRead {
+					continue
+				}
+				return
+			}
+		}
+	}()
+	return &dnsReplaySender{conn: conn}, nil
+}
+
+func (s *dnsReplaySender) send(frame []byte, dt *dnstap.Dnstap) (bool, error) {
+	msg := dt.GetMessage()
+	if msg == nil || msg.GetQueryMessage() == nil {
+		return false, nil
+	}
+	switch msg.GetType() {
+	case dnstap.Message_AUTH_QUERY, dnstap.Message_RESOLVER_QUERY,
+		dnstap.Message_CLIENT_QUERY, dnstap.Message_FORWARDER_QUERY,
+		dnstap.Message_STUB_QUERY, dnstap.Message_TOOL_QUERY,
+		dnstap.Message_UPDATE_QUERY:
+	default:
+		return false, nil
+	}
+	if _, err := s.conn.Write(msg.GetQueryMessage()); err != nil {
+		return false, fmt.Errorf("failed to send query: %w", err)
+	}
+	return true, nil
+}
+
+func (s *dnsReplaySender) flush() error {
+	return nil
+}
+
+func (s *dnsReplaySender) close() {
+	s.conn.Close()
+}
@@ -0,0 +1,255 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	strftime "github.com/jehiah/go-strftime"
+	"github.com/mimuret/dtap"
+)
+
+func init() {
+	subcommands["split"] = &subcommand{
+		summary: "split fstrm files by time window, identity or size",
+		run:     runSplit,
+	}
+}
+
+// splitFile is the current output file of a window and identity.
+type splitFile struct {
+	writer *dtap.FstrmFileWriter
+	path   string
+	start  time.Time
+	seq    int
+	frames uint64
+}
+
+type splitter struct {
+	template   string
+	interval   time.Duration
+	byIdentity bool
+	size       int64
+	files      map[string]*splitFile
+	paths      map[string]uint64
+	// latest is the start of the latest window, the windows before the previous one are closed.
+	latest time.Time
+	late   uint64
+}
+
+func runSplit(args []string) error {
+	fs := newSubcommandFlagSet("split", "FILE...")
+	output := fs.String("o", "", "output path template. strftime directives are the window start, {identity} and {seq} are replaced")
+	interval := fs.Duration("interval", 0, "split by the time window of message time")
+	byIdentity := fs.Bool("by-identity", false, "split by identity")
+	size := fs.String("size", "", "split by the uncompressed size such as 100M")
+	in := addInputFlags(fs)
+	dd := addDedupFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 || *output == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	s := &splitter{
+		template:   *output,
+		interval:   *interval,
+		byIdentity: *byIdentity,
+		files:      map[string]*splitFile{},
+		paths:      map[string]uint64{},
+	}
+	if *size != "" {
+		var err error
+		if s.size, err = parseSize(*size); err != nil {
+			return err
+		}
+	}
+	if s.interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	if s.interval == 0 && !s.byIdentity && s.size == 0 {
+		return errors.New("one of -interval, -by-identity and -size is required")
+	}
+	if s.interval > 0 && !strings.Contains(s.template, "%") {
+		return errors.New("output must have strftime directives with -interval")
+	}
+	if s.byIdentity && !strings.Contains(s.template, "{identity}") {
+		return errors.New("output must have {identity} with -by-identity")
+	}
+	if s.size > 0 && !strings.Contains(s.template, "{seq}") {
+		return errors.New("output must have {seq} with -size")
+	}
+	if err := in.validate(); err != nil {
+		return err
+	}
+	dedup, err := dd.deduplicator()
+	if err != nil {
+		return err
+	}
+
+	var last time.Time
+	var untimed, duplicates uint64
+	err = in.read(fs.Args(), func(frame []byte) error {
+		dt := &dnstap.Dnstap{}
+		if err := proto.Unmarshal(frame, dt); err != nil {
+			return fmt.Errorf("failed to parse dnstap frame: %w", err)
+		}
+		// frames without time follow the previous frame
+		if t := dtap.MessageTime(dt.GetMessage()); !t.IsZero() {
+			last = t
+		}
+		if dedup.Duplicate(frame, last) {
+			duplicates++
+			return nil
+		}
+		var start time.Time
+		if s.interval > 0 {
+			if last.IsZero() {
+				untimed++
+				return nil
+			}
+			start = last.Truncate(s.interval).UTC()
+		}
+		return s.write(frame, start, string(dt.GetIdentity()))
+	})
+	if cerr := s.close(); err == nil {
+		err = cerr
+	}
+	if untimed > 0 {
+		fmt.Fprintf(os.Stderr, "skip %d messages without time\n", untimed)
+	}
+	if duplicates > 0 {
+		fmt.Fprintf(os.Stderr, "drop %d duplicate frames\n", duplicates)
+	}
+	if s.late > 0 {
+		fmt.Fprintf(os.Stderr, "skip %d messages of closed windows, merge the files by time with dtap merge\n", s.late)
+	}
+	return err
+}
+
+func (s *splitter) write(frame []byte, start time.Time, identity string) error {
+	if !s.byIdentity {
+		identity = ""
+	}
+	if s.interval > 0 {
+		if start.After(s.latest) {
+			s.latest = start
+			if err := s.closeWindows(start.Add(-s.interval)); err != nil {
+				return err
+			}
+		} else if start.Before(s.latest.Add(-s.interval)) {
+			s.late++
+			return nil
+		}
+	}
+	key := start.Format(time.RFC3339Nano) + "/" + identity
+	f, ok := s.files[key]
+	if ok && s.size > 0 && f.writer.Size() >= s.size {
+		delete(s.files, key)
+		if err := s.closeFile(f); err != nil {
+			return err
+		}
+		ok = false
+	}
+	if !ok {
+		seq := 0
+		if f != nil {
+			seq = f.seq + 1
+		}
+		var err error
+		if f, err = s.openFile(start, identity, seq); err != nil {
+			return err
+		}
+		s.files[key] = f
+	}
+	f.frames++
+	return f.writer.Write(frame)
+}
+
+func (s *splitter) openFile(start time.Time, identity string, seq int) (*splitFile, error) {
+	path := s.path(start, identity, seq)
+	if _, used := s.paths[path]; used {
+		return nil, fmt.Errorf("output path %s is reused, add directives to the output template", path)
+	}
+	w, err := dtap.NewFstrmFileWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	s.paths[path] = 0
+	return &splitFile{writer: w, path: path, start: start, seq: seq}, nil
+}
+
+func (s *splitter) path(start time.Time, identity string, seq int) string {
+	path := s.template
+	if s.interval > 0 {
+		path = strftime.Format(path, start)
+	}
+	if identity == "" {
+		identity = "unknown"
+	}
+	identity = strings.NewReplacer("/", "_", string(os.PathSeparator), "_").Replace(identity)
+	return strings.NewReplacer("{identity}", identity, "{seq}", strconv.Itoa(seq)).Replace(path)
+}
+
+func (s *splitter) closeFile(f *splitFile) error {
+	s.paths[f.path] = f.frames
+	if err := f.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close %s err: %w", f.path, err)
+	}
+	return nil
+}
+
+// closeWindows closes the files of the windows before start.
+// The input is ordered by time, so the previous window is kept open for the messages slightly out of order.
+func (s *splitter) closeWindows(start time.Time) error {
+	for key, f := range s.files {
+		if f.start.Before(start) {
+			delete(s.files, key)
+			if err := s.closeFile(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// close closes the files and prints the paths with the number of frames.
+func (s *splitter) close() error {
+	var err error
+	for _, f := range s.files {
+		if cerr := s.closeFile(f); err == nil {
+			err = cerr
+		}
+	}
+	s.files = map[string]*splitFile{}
+	paths := make([]string, 0, len(s.paths))
+	for path := range s.paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Printf("%s\t%d\n", path, s.paths[path])
+	}
+	return err
+}
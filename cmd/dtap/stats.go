@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/mimuret/dtap"
+)
+
+func init() {
+	subcommands["stats"] = &subcommand{
+		summary: "print summary tables of fstrm or pcap files",
+		run:     runStats,
+	}
+}
+
+func runStats(args []string) error {
+	fs := newSubcommandFlagSet("stats", "FILE...")
+	format := fs.String("f", "text", "output format(text,json)")
+	interval := fs.Duration("interval", 0, "bucket the tables by the interval. 0 is the whole files")
+	top := fs.Int("top", 10, "number of top qnames and clients")
+	capacity := fs.Int("capacity", 10000, "number of counters estimating top qnames and clients")
+	in := addInputFlags(fs)
+	flat := addFlatFlags(fs, 24, 48)
+	tr := addTimeRangeFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+	if *interval < 0 || *top <= 0 || *capacity < *top {
+		return errors.New("interval must not be negative, top must be positive and capacity must not be less than top")
+	}
+	if err := in.validate(); err != nil {
+		return err
+	}
+	if err := tr.parse(); err != nil {
+		return err
+	}
+	flatConfig, err := flat.config()
+	if err != nil {
+		return err
+	}
+
+	buckets := map[time.Time]*dtap.Stats{}
+	var untimed uint64
+	err = in.read(fs.Args(), func(frame []byte) error {
+		dt := &dnstap.Dnstap{}
+		if err := proto.Unmarshal(frame, dt); err != nil {
+			return fmt.Errorf("failed to parse dnstap frame: %w", err)
+		}
+		t := dtap.MessageTime(dt.GetMessage())
+		if !tr.contains(t) {
+			return nil
+		}
+		var start time.Time
+		if *interval > 0 {
+			if t.IsZero() {
+				untimed++
+				return nil
+			}
+			start = t.Truncate(*interval)
+		}
+		data, err := dtap.FlatDnstap(dt, flatConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skip message: %v\n", err)
+			return nil
+		}
+		s, ok := buckets[start]
+		if !ok {
+			s = dtap.NewStats(*capacity)
+			buckets[start] = s
+		}
+		s.Add(data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if untimed > 0 {
+		fmt.Fprintf(os.Stderr, "skip %d messages without time\n", untimed)
+	}
+
+	starts := make([]time.Time, 0, len(buckets))
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	enc := json.NewEncoder(os.Stdout)
+	for i, start := range starts {
+		r := buckets[start].Report(*top)
+		if *interval > 0 {
+			s, e := start.UTC(), start.Add(*interval).UTC()
+			r.Start, r.End = &s, &e
+		}
+		if *format == "json" {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printStatsReport(os.Stdout, r)
+	}
+	return nil
+}
+
+func printStatsReport(w io.Writer, r *dtap.StatsReport) {
+	if r.Start != nil {
+		fmt.Fprintf(w, "# %s - %s\n", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "messages: %d\n", r.Messages)
+	var queries, responses uint64
+	for _, row := range r.Qtypes {
+		queries += row.Count
+	}
+	for _, row := range r.Rcodes {
+		responses += row.Count
+	}
+	// the shares are of all messages, queries or responses
+	for _, t := range []struct {
+		name  string
+		rows  []dtap.StatsRow
+		total uint64
+	}{
+		{"type", r.Types, r.Messages},
+		{"qtype", r.Qtypes, queries},
+		{"rcode", r.Rcodes, responses},
+		{"transport", r.Transports, queries},
+		{"top qname", r.TopQnames, queries},
+		{"top client", r.TopClients, queries},
+		{"query size", r.QuerySizes, queries},
+		{"response size", r.ResponseSizes, responses},
+	} {
+		if len(t.rows) == 0 {
+			continue
+		}
+		width := 0
+		for _, row := range t.rows {
+			if len(row.Key) > width {
+				width = len(row.Key)
+			}
+		}
+		fmt.Fprintf(w, "\n%s\n", t.name)
+		for _, row := range t.rows {
+			fmt.Fprintf(w, "  %-*s %10d %6.1f%%\n", width, row.Key, row.Count, float64(row.Count)*100/float64(t.total))
+		}
+	}
+	if l := r.Latency; l != nil {
+		fmt.Fprintf(w, "\nlatency(ms)\n  count %d  p50 %.3f  p90 %.3f  p99 %.3f  max %.3f\n", l.Count, l.P50, l.P90, l.P99, l.Max)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// subcommand is a tool mode of dtap.
+// Without a subcommand dtap runs as the router.
+type subcommand struct {
+	summary string
+	run     func(args []string) error
+}
+
+var subcommands = map[string]*subcommand{}
+
+func subcommandNames() []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runSubcommand runs the subcommand named by args[0].
+// It returns false if args[0] isn't a subcommand.
+func runSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := subcommands[args[0]]
+	if !ok {
+		return false
+	}
+	if err := cmd.run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", os.Args[0], args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func newSubcommandFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [OPTION]... %s\n", os.Args[0], name, args)
+		fmt.Fprintf(os.Stderr, "%s\n", subcommands[name].summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
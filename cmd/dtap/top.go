@@ -0,0 +1,461 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/mimuret/dtap"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func init() {
+	subcommands["top"] = &subcommand{
+		summary: "live view of top qnames, clients, qtypes, rcodes and QPS",
+		run:     runTop,
+	}
+}
+
+// topBufferSize is the number of frames buffered between the input and the view.
+const topBufferSize = 100000
+
+// topView counts the flattened messages matching the filter.
+type topView struct {
+	mux       sync.Mutex
+	capacity  int
+	filter    string
+	stats     *dtap.Stats
+	queries   uint64
+	responses uint64
+	start     time.Time
+	last      time.Time
+	lastQ     uint64
+	lastR     uint64
+	qps       float64
+	rps       float64
+}
+
+func newTopView(capacity int, filter string) *topView {
+	v := &topView{capacity: capacity, filter: strings.ToLower(filter)}
+	v.reset()
+	return v
+}
+
+func (v *topView) reset() {
+	v.mux.Lock()
+	v.stats = dtap.NewStats(v.capacity)
+	v.queries, v.responses, v.lastQ, v.lastR = 0, 0, 0, 0
+	v.qps, v.rps = 0, 0
+	v.start, v.last = time.Now(), time.Now()
+	v.mux.Unlock()
+}
+
+// setFilter resets the counters because they are counted with the filter.
+func (v *topView) setFilter(filter string) {
+	v.mux.Lock()
+	v.filter = strings.ToLower(filter)
+	v.mux.Unlock()
+	v.reset()
+}
+
+// match returns true if the qname, client, qtype or rcode contains the filter.
+func (v *topView) match(data *dtap.DnstapFlatT) bool {
+	if v.filter == "" {
+		return true
+	}
+	for _, s := range []string{data.Qname, data.QueryAddress.String(), data.Qtype, data.Rcode} {
+		if strings.Contains(strings.ToLower(s), v.filter) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *topView) add(data *dtap.DnstapFlatT) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if !v.match(data) {
+		return
+	}
+	if strings.HasSuffix(data.Type, "_QUERY") {
+		v.queries++
+	} else {
+		v.responses++
+	}
+	v.stats.Add(data)
+}
+
+// tick updates the rates since the previous tick.
+func (v *topView) tick(now time.Time) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if elapsed := now.Sub(v.last).Seconds(); elapsed > 0 {
+		v.qps = float64(v.queries-v.lastQ) / elapsed
+		v.rps = float64(v.responses-v.lastR) / elapsed
+	}
+	v.last, v.lastQ, v.lastR = now, v.queries, v.responses
+}
+
+// topScreen is the state of the display.
+type topScreen struct {
+	source  string
+	sortKey string
+	paused  bool
+	batch   bool
+	editing bool
+	input   string
+}
+
+func runTop(args []string) error {
+	fs := newSubcommandFlagSet("top", "")
+	unixPath := fs.String("unix", "", "receive fstrm on the unix socket path")
+	tcpAddress := fs.String("tcp", "", "receive fstrm on the tcp address(host:port)")
+	attach := fs.String("attach", "", "attach to the OutputTap of a running dtap, a unix socket path or host:port")
+	interval := fs.Duration("interval", time.Second, "refresh interval")
+	rows := fs.Int("top", 0, "number of rows of tables. 0 fits the terminal")
+	capacity := fs.Int("capacity", 10000, "number of counters estimating top qnames and clients")
+	sortKey := fs.String("sort", "count", "sort key of rows(count,name)")
+	filter := fs.String("filter", "", "count messages whose qname, client, qtype or rcode contains the string")
+	batch := fs.Bool("batch", false, "print the tables every interval without the terminal control")
+	flat := addFlatFlags(fs, 24, 48)
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *interval <= 0 || *rows < 0 || *capacity <= 0 {
+		return errors.New("interval and capacity must be positive and top must not be negative")
+	}
+	if *sortKey != "count" && *sortKey != "name" {
+		return fmt.Errorf("unknown sort key: %s", *sortKey)
+	}
+	flatConfig, err := flat.config()
+	if err != nil {
+		return err
+	}
+
+	var input dtap.Input
+	var source string
+	switch {
+	case *unixPath != "" && *tcpAddress == "" && *attach == "":
+		source = *unixPath
+		input, err = dtap.NewDnstapFstrmUnixSocketInput(&dtap.InputUnixSocketConfig{Path: *unixPath})
+	case *tcpAddress != "" && *unixPath == "" && *attach == "":
+		source = *tcpAddress
+		input, err = newTopTCPInput(*tcpAddress)
+	case *attach != "" && *unixPath == "" && *tcpAddress == "":
+		source = *attach
+		input = &tapInput{address: *attach}
+	default:
+		return errors.New("one of -unix, -tcp and -attach is required")
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rbuf := dtap.NewRbuf(topBufferSize, TotalRecvInputFrame, TotalLostInputFrame)
+	errCh := make(chan error, 1)
+	go func() {
+		if err := input.Run(ctx, rbuf); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- errors.New("input closed")
+	}()
+	view := newTopView(*capacity, *filter)
+	go func() {
+		for frame := range rbuf.Read() {
+			dt := &dnstap.Dnstap{}
+			if err := proto.Unmarshal(frame, dt); err != nil {
+				continue
+			}
+			data, err := dtap.FlatDnstap(dt, flatConfig)
+			if err != nil {
+				continue
+			}
+			view.add(data)
+		}
+	}()
+
+	screen := &topScreen{source: source, sortKey: *sortKey}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	fd := int(os.Stdin.Fd())
+	if *batch || !terminal.IsTerminal(fd) || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		screen.batch = true
+		n := *rows
+		if n == 0 {
+			n = 10
+		}
+		for {
+			select {
+			case <-sigCh:
+				return nil
+			case err := <-errCh:
+				return err
+			case now := <-ticker.C:
+				view.tick(now)
+				fmt.Println(strings.Join(screen.render(view, 80, n), "\n"))
+				fmt.Println()
+			}
+		}
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	// alternate screen without the cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		terminal.Restore(fd, state)
+	}()
+	keyCh := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keyCh)
+				return
+			}
+			keyCh <- buf[0]
+		}
+	}()
+
+	draw := func() {
+		width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+		n := *rows
+		if n == 0 {
+			// 4 header lines and 2 sections of a title, rows and a blank line
+			if n = (height - 4 - 4) / 2; n < 1 {
+				n = 1
+			}
+		}
+		lines := screen.render(view, width, n)
+		if len(lines) > height {
+			lines = lines[:height]
+		}
+		fmt.Print("\x1b[H\x1b[2J" + strings.Join(lines, "\r\n"))
+	}
+	draw()
+	for {
+		select {
+		case <-sigCh:
+			return nil
+		case err := <-errCh:
+			return err
+		case now := <-ticker.C:
+			view.tick(now)
+			if !screen.paused {
+				draw()
+			}
+		case key, ok := <-keyCh:
+			if !ok {
+				return nil
+			}
+			if screen.key(key, view) {
+				return nil
+			}
+			draw()
+		}
+	}
+}
+
+// key handles the key and returns true to quit.
+func (s *topScreen) key(key byte, view *topView) bool {
+	if s.editing {
+		switch key {
+		case '\r', '\n':
+			s.editing = false
+			view.setFilter(s.input)
+		case 0x1b:
+			s.editing = false
+		case 0x7f, 0x08:
+			if len(s.input) > 0 {
+				s.input = s.input[:len(s.input)-1]
+			}
+		case 0x03:
+			return true
+		default:
+			if key >= 0x20 && key < 0x7f {
+				s.input += string(key)
+			}
+		}
+		return false
+	}
+	switch key {
+	case 'q', 0x03:
+		return true
+	case 's':
+		if s.sortKey == "count" {
+			s.sortKey = "name"
+		} else {
+			s.sortKey = "count"
+		}
+	case '/':
+		s.editing = true
+		s.input = ""
+	case 'c':
+		view.setFilter("")
+	case 'r':
+		view.reset()
+	case 'p':
+		s.paused = !s.paused
+	}
+	return false
+}
+
+// render returns the lines of the screen with rows of tables.
+func (s *topScreen) render(v *topView, width, rows int) []string {
+	v.mux.Lock()
+	r := v.stats.Report(rows)
+	queries, responses := v.queries, v.responses
+	qps, rps := v.qps, v.rps
+	uptime := time.Since(v.start).Truncate(time.Second)
+	filter := v.filter
+	v.mux.Unlock()
+
+	if s.editing {
+		filter = s.input + "_"
+	} else if filter == "" {
+		filter = "-"
+	}
+	status := ""
+	if s.paused {
+		status = "  [paused]"
+	}
+	lines := []string{
+		fmt.Sprintf("dtap top  %s  up %s  queries %d (%.1f/s)  responses %d (%.1f/s)", s.source, uptime, queries, qps, responses, rps),
+		fmt.Sprintf("sort: %s  filter: %s%s", s.sortKey, filter, status),
+	}
+	if !s.batch {
+		lines = append(lines, "keys: q quit  s sort  / filter  c clear filter  r reset  p pause")
+	}
+	lines = append(lines, "")
+	col := (width - 2) / 2
+	for _, pair := range [][2]topTable{
+		{{"top qname", r.TopQnames, queries}, {"top client", r.TopClients, queries}},
+		{{"qtype", r.Qtypes, queries}, {"rcode", r.Rcodes, responses}},
+	} {
+		left, right := s.table(pair[0], col, rows), s.table(pair[1], col, rows)
+		for i := range left {
+			lines = append(lines, fmt.Sprintf("%-*s  %s", col, left[i], right[i]))
+		}
+		lines = append(lines, "")
+	}
+	for i := range lines {
+		if len(lines[i]) > width {
+			lines[i] = lines[i][:width]
+		}
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	return lines
+}
+
+type topTable struct {
+	title string
+	rows  []dtap.StatsRow
+	total uint64
+}
+
+// table returns a title and n lines of the table with the width.
+func (s *topScreen) table(t topTable, width, n int) []string {
+	rows := t.rows
+	if len(rows) > n {
+		rows = rows[:n]
+	}
+	if s.sortKey == "name" {
+		rows = append([]dtap.StatsRow{}, rows...)
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Key < rows[j].Key })
+	}
+	keyWidth := width - 18
+	if keyWidth < 8 {
+		keyWidth = 8
+	}
+	lines := []string{fmt.Sprintf("%-*s %10s %6s", keyWidth, t.title, "count", "%")}
+	for _, row := range rows {
+		key := row.Key
+		if len(key) > keyWidth {
+			key = key[:keyWidth-1] + "~"
+		}
+		var share float64
+		if t.total > 0 {
+			share = float64(row.Count) * 100 / float64(t.total)
+		}
+		lines = append(lines, fmt.Sprintf("%-*s %10d %5.1f%%", keyWidth, key, row.Count, share))
+	}
+	for len(lines) < n+1 {
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+func newTopTCPInput(address string) (dtap.Input, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %s", port)
+	}
+	return dtap.NewDnstapFstrmTCPSocketInput(&dtap.InputTCPSocketConfig{Address: host, Port: uint16(p)})
+}
+
+// tapInput reads the frames from the OutputTap of a running dtap.
+type tapInput struct {
+	address string
+}
+
+func (i *tapInput) Run(ctx context.Context, rbuf *dtap.RBuf) error {
+	network := "unix"
+	if _, _, err := net.SplitHostPort(i.address); err == nil && !strings.Contains(i.address, "/") {
+		network = "tcp"
+	}
+	conn, err := net.Dial(network, i.address)
+	if err != nil {
+		return fmt.Errorf("failed to attach %s: %w", i.address, err)
+	}
+	input, err := dtap.NewDnstapFstrmInput(conn, true)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to attach %s: %w", i.address, err)
+	}
+	return input.Read(ctx, rbuf)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mimuret/dtap"
+)
+
+func init() {
+	subcommands["unique-merge"] = &subcommand{
+		summary: "merge the HyperLogLog snapshots persisted by Unique outputs of several dtap instances",
+		run:     runUniqueMerge,
+	}
+}
+
+func runUniqueMerge(args []string) error {
+	fs := newSubcommandFlagSet("unique-merge", "FILE...")
+	output := fs.String("o", "", "write the merged snapshot to the file, which can be merged again")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	snapshots := make([]*dtap.HyperLogLogSnapshot, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		snapshot, err := dtap.ReadHyperLogLogSnapshot(path)
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	merged, err := dtap.MergeHyperLogLogSnapshots(snapshots...)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		buf, err := json.Marshal(merged)
+		if err != nil {
+			return fmt.Errorf("failed to marshal snapshot: %w", err)
+		}
+		if err := ioutil.WriteFile(*output, buf, 0644); err != nil {
+			return fmt.Errorf("failed to write snapshot %s err: %w", *output, err)
+		}
+		return nil
+	}
+	sort.SliceStable(merged.Sketches, func(i, j int) bool {
+		return merged.Sketches[i].Count > merged.Sketches[j].Count
+	})
+	fmt.Printf("# %s\t%s\tcount\n", merged.Name, strings.Join(merged.Labels, "\t"))
+	for _, s := range merged.Sketches {
+		fmt.Printf("%s\t%d\n", strings.Join(s.Values, "\t"), s.Count)
+	}
+	return nil
+}
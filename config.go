@@ -27,29 +27,46 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
+	dnstap "github.com/dnstap/golang-dnstap"
 	"github.com/fsnotify/fsnotify"
+	"github.com/miekg/dns"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	InputMsgBuffer   uint
-	InputUnix        []*InputUnixSocketConfig
-	InputFile        []*InputFileConfig
-	InputTail        []*InputTailConfig
-	InputTCP         []*InputTCPSocketConfig
-	OutputUnix       []*OutputUnixSocketConfig
-	OutputFile       []*OutputFileConfig
-	OutputTCP        []*OutputTCPSocketConfig
-	OutputFluent     []*OutputFluentConfig
-	OutputKafka      []*OutputKafkaConfig
-	OutputNats       []*OutputNatsConfig
-	OutputPrometheus []*OutputPrometheus
-	OutputStdout     []*OutputStdoutConfig
+	InputMsgBuffer        uint
+	Dedup                 DedupConfig
+	Transform             TransformConfig
+	Health                HealthConfig
+	Admin                 AdminConfig
+	InputUnix             []*InputUnixSocketConfig
+	InputFile             []*InputFileConfig
+	InputTail             []*InputTailConfig
+	InputTCP              []*InputTCPSocketConfig
+	InputGenerator        []*InputGeneratorConfig
+	OutputUnix            []*OutputUnixSocketConfig
+	OutputFile            []*OutputFileConfig
+	OutputTCP             []*OutputTCPSocketConfig
+	OutputFluent          []*OutputFluentConfig
+	OutputKafka           []*OutputKafkaConfig
+	OutputNats            []*OutputNatsConfig
+	OutputPrometheus      []*OutputPrometheus
+	OutputStdout          []*OutputStdoutConfig
+	OutputTopK            []*OutputTopKConfig
+	OutputUnique          []*OutputUniqueConfig
+	OutputSummary         []*OutputSummaryConfig
+	OutputRSSAC002        []*OutputRSSAC002Config
+	OutputRandomSubdomain []*OutputRandomSubdomainConfig
+	OutputClientRate      []*OutputClientRateConfig
+	OutputTap             []*OutputTapConfig
 }
 
 var (
@@ -106,6 +123,18 @@ func (c *Config) Validate() []error {
 	if c.InputMsgBuffer < 128 {
 		errs = append(errs, errors.New("InputMsgBuffer must not small 128"))
 	}
+	if err := c.Dedup.Validate(); err != nil {
+		err.configType = "Dedup"
+		errs = append(errs, err)
+	}
+	if err := c.Transform.Validate(); err != nil {
+		err.configType = "Transform"
+		errs = append(errs, err)
+	}
+	if err := c.Health.Validate(); err != nil {
+		err.configType = "Health"
+		errs = append(errs, err)
+	}
 	for n, i := range c.InputUnix {
 		if err := i.Validate(); err != nil {
 			err.configType = "InputUnix"
@@ -120,6 +149,13 @@ func (c *Config) Validate() []error {
 			errs = append(errs, err)
 		}
 	}
+	for n, i := range c.InputTail {
+		if err := i.Validate(); err != nil {
+			err.configType = "InputTail"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
 	for n, i := range c.InputTCP {
 		if err := i.Validate(); err != nil {
 			err.configType = "InputTCP"
@@ -127,6 +163,13 @@ func (c *Config) Validate() []error {
 			errs = append(errs, err)
 		}
 	}
+	for n, i := range c.InputGenerator {
+		if err := i.Validate(); err != nil {
+			err.configType = "InputGenerator"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
 	for n, o := range c.OutputUnix {
 		if err := o.Validate(); err != nil {
 			err.configType = "OutputUnix"
@@ -176,6 +219,73 @@ func (c *Config) Validate() []error {
 			errs = append(errs, err)
 		}
 	}
+	for n, o := range c.OutputStdout {
+		if err := o.Validate(); err != nil {
+			err.configType = "OutputStdout"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
+	paths := map[string]int{}
+	for n, o := range c.OutputTopK {
+		err := o.Validate()
+		// the HTTP endpoint of the other output would be replaced
+		if prev, ok := paths[o.GetPath()]; ok {
+			if err == nil {
+				err = NewValidationError()
+			}
+			err.Add(fmt.Errorf("Path %s is used by OutputTopK[%d]", o.GetPath(), prev))
+		} else {
+			paths[o.GetPath()] = n
+		}
+		if err != nil {
+			err.configType = "OutputTopK"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
+	for n, o := range c.OutputUnique {
+		if err := o.Validate(); err != nil {
+			err.configType = "OutputUnique"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
+	for n, o := range c.OutputSummary {
+		if err := o.Validate(); err != nil {
+			err.configType = "OutputSummary"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
+	for n, o := range c.OutputRSSAC002 {
+		if err := o.Validate(); err != nil {
+			err.configType = "OutputRSSAC002"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
+	for n, o := range c.OutputRandomSubdomain {
+		if err := o.Validate(); err != nil {
+			err.configType = "OutputRandomSubdomain"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
+	for n, o := range c.OutputClientRate {
+		if err := o.Validate(); err != nil {
+			err.configType = "OutputClientRate"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
+	for n, o := range c.OutputTap {
+		if err := o.Validate(); err != nil {
+			err.configType = "OutputTap"
+			err.no = n
+			errs = append(errs, err)
+		}
+	}
 	return errs
 }
 
@@ -232,6 +342,25 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 	return c, nil
 }
 
+// NewInputGeneratorConfigFromFile reads a generator profile, the keys of a [[InputGenerator]] table.
+func NewInputGeneratorConfigFromFile(filename string) (*InputGeneratorConfig, error) {
+	c := &InputGeneratorConfig{}
+	v := viper.New()
+	v.SetConfigType("toml")
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := v.ReadConfig(f); err != nil {
+		return nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+	if err := v.Unmarshal(c); err != nil {
+		return nil, fmt.Errorf("failed to parse profile: %w", err)
+	}
+	return c, nil
+}
+
 type InputUnixSocketConfig struct {
 	Path string
 	User string
@@ -312,9 +441,211 @@ func (i *InputTCPSocketConfig) GetNet() string {
 	return address + ":" + strconv.Itoa(int(port))
 }
 
+// InputGeneratorConfig is the profile of synthetic traffic.
+type InputGeneratorConfig struct {
+	Rate          uint
+	Count         uint64
+	Seed          int64
+	Identity      string
+	Type          string
+	Zone          string
+	Names         uint
+	Qnames        []string
+	QnamesPath    string
+	Distribution  string
+	ZipfS         float64
+	Qtypes        []string
+	Rcodes        []string
+	Clients       uint
+	ClientPrefix  string
+	ClientPrefix6 string
+	IPv6Ratio     float64
+	TCPRatio      float64
+	Server        string
+	Server6       string
+	LatencyMean   float64
+	Messages      string
+}
+
+func (i *InputGeneratorConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	if _, ok := dnstap.Message_Type_value[i.GetType()+"_QUERY"]; !ok {
+		valerr.Add(fmt.Errorf("unknown Type: %s", i.Type))
+	}
+	if !dns.IsFqdn(i.GetZone()) {
+		valerr.Add(errors.New("Zone must be a fully qualified domain name"))
+	}
+	switch i.GetDistribution() {
+	case "zipf":
+		if i.GetZipfS() <= 1 {
+			valerr.Add(errors.New("ZipfS must be greater than 1"))
+		}
+	case "uniform":
+	default:
+		valerr.Add(errors.New("Distribution must be zipf or uniform"))
+	}
+	if _, err := parseWeights(i.GetQtypes(), dns.StringToType); err != nil {
+		valerr.Add(fmt.Errorf("invalid Qtypes: %w", err))
+	}
+	if _, err := parseWeights(i.GetRcodes(), rcodeNames); err != nil {
+		valerr.Add(fmt.Errorf("invalid Rcodes: %w", err))
+	}
+	for _, prefix := range []string{i.GetClientPrefix(), i.GetClientPrefix6()} {
+		if _, _, err := net.ParseCIDR(prefix); err != nil {
+			valerr.Add(fmt.Errorf("invalid client prefix: %w", err))
+		}
+	}
+	if net.ParseIP(i.GetServer()).To4() == nil {
+		valerr.Add(errors.New("Server must be an IPv4 address"))
+	}
+	if ip := net.ParseIP(i.GetServer6()); ip == nil || ip.To4() != nil {
+		valerr.Add(errors.New("Server6 must be an IPv6 address"))
+	}
+	if i.IPv6Ratio < 0 || i.IPv6Ratio > 1 {
+		valerr.Add(errors.New("IPv6Ratio must be between 0 and 1"))
+	}
+	if i.TCPRatio < 0 || i.TCPRatio > 1 {
+		valerr.Add(errors.New("TCPRatio must be between 0 and 1"))
+	}
+	if i.LatencyMean < 0 {
+		valerr.Add(errors.New("LatencyMean must not be negative"))
+	}
+	switch i.GetMessages() {
+	case "both", "query", "response":
+	default:
+		valerr.Add(errors.New("Messages must be both, query or response"))
+	}
+	return valerr.Err()
+}
+
+// GetRate returns the number of queries per second.
+func (i *InputGeneratorConfig) GetRate() uint {
+	if i.Rate == 0 {
+		return 1000
+	}
+	return i.Rate
+}
+
+// GetCount returns the number of queries. 0 is unlimited.
+func (i *InputGeneratorConfig) GetCount() uint64 {
+	return i.Count
+}
+
+func (i *InputGeneratorConfig) GetIdentity() string {
+	if i.Identity == "" {
+		return "dtap-gen"
+	}
+	return i.Identity
+}
+
+// GetType returns the upper case prefix of message types such as CLIENT.
+func (i *InputGeneratorConfig) GetType() string {
+	if i.Type == "" {
+		return "CLIENT"
+	}
+	return strings.ToUpper(i.Type)
+}
+
+func (i *InputGeneratorConfig) GetZone() string {
+	if i.Zone == "" {
+		return "example.jp."
+	}
+	return dns.Fqdn(i.Zone)
+}
+
+// GetNames returns the number of names generated under Zone without Qnames.
+func (i *InputGeneratorConfig) GetNames() uint {
+	if i.Names == 0 {
+		return 10000
+	}
+	return i.Names
+}
+
+func (i *InputGeneratorConfig) GetDistribution() string {
+	if i.Distribution == "" {
+		return "zipf"
+	}
+	return strings.ToLower(i.Distribution)
+}
+
+func (i *InputGeneratorConfig) GetZipfS() float64 {
+	if i.ZipfS == 0 {
+		return 1.1
+	}
+	return i.ZipfS
+}
+
+// GetQtypes returns the weighted qtypes formatted as TYPE=weight.
+func (i *InputGeneratorConfig) GetQtypes() []string {
+	if len(i.Qtypes) == 0 {
+		return []string{"A=60", "AAAA=25", "HTTPS=5", "MX=3", "TXT=3", "NS=2", "PTR=2"}
+	}
+	return i.Qtypes
+}
+
+// GetRcodes returns the weighted rcodes formatted as RCODE=weight.
+func (i *InputGeneratorConfig) GetRcodes() []string {
+	if len(i.Rcodes) == 0 {
+		return []string{"NOERROR=90", "NXDOMAIN=8", "SERVFAIL=2"}
+	}
+	return i.Rcodes
+}
+
+func (i *InputGeneratorConfig) GetClients() uint {
+	if i.Clients == 0 {
+		return 1000
+	}
+	return i.Clients
+}
+
+func (i *InputGeneratorConfig) GetClientPrefix() string {
+	if i.ClientPrefix == "" {
+		return "10.0.0.0/8"
+	}
+	return i.ClientPrefix
+}
+
+func (i *InputGeneratorConfig) GetClientPrefix6() string {
+	if i.ClientPrefix6 == "" {
+		return "2001:db8::/32"
+	}
+	return i.ClientPrefix6
+}
+
+func (i *InputGeneratorConfig) GetServer() string {
+	if i.Server == "" {
+		return "192.0.2.53"
+	}
+	return i.Server
+}
+
+func (i *InputGeneratorConfig) GetServer6() string {
+	if i.Server6 == "" {
+		return "2001:db8::53"
+	}
+	return i.Server6
+}
+
+// GetLatencyMean returns the mean of response latencies in milliseconds.
+func (i *InputGeneratorConfig) GetLatencyMean() float64 {
+	if i.LatencyMean == 0 {
+		return 1
+	}
+	return i.LatencyMean
+}
+
+// GetMessages returns which messages of a query are generated, both, query or response.
+func (i *InputGeneratorConfig) GetMessages() string {
+	if i.Messages == "" {
+		return "both"
+	}
+	return strings.ToLower(i.Messages)
+}
+
 type OutputUnixSocketConfig struct {
-	Path   string
-	Buffer OutputBufferConfig
+	Path     string
+	Sampling OutputSamplingConfig
+	Buffer   OutputBufferConfig
 }
 
 func (o *OutputUnixSocketConfig) Validate() *ValidationError {
@@ -322,6 +653,9 @@ func (o *OutputUnixSocketConfig) Validate() *ValidationError {
 	if o.Path == "" {
 		err.Add(errors.New("Path must not be empty"))
 	}
+	if serr := o.Sampling.Validate(); serr != nil {
+		err.Add(serr)
+	}
 	return err.Err()
 }
 
@@ -329,10 +663,45 @@ func (o *OutputUnixSocketConfig) GetPath() string {
 	return o.Path
 }
 
+// OutputTapConfig is the socket which live viewers such as dtap top attach to.
+// Path is a unix socket path, Address is a tcp address(host:port).
+type OutputTapConfig struct {
+	Path    string
+	Address string
+	User    string
+	Buffer  OutputBufferConfig
+}
+
+func (o *OutputTapConfig) Validate() *ValidationError {
+	err := NewValidationError()
+	if (o.Path == "") == (o.Address == "") {
+		err.Add(errors.New("one of Path and Address must be set"))
+	}
+	if o.Address != "" {
+		if _, _, serr := net.SplitHostPort(o.Address); serr != nil {
+			err.Add(fmt.Errorf("invalid Address: %w", serr))
+		}
+	}
+	return err.Err()
+}
+
+func (o *OutputTapConfig) GetPath() string {
+	return o.Path
+}
+
+func (o *OutputTapConfig) GetAddress() string {
+	return o.Address
+}
+
+func (o *OutputTapConfig) GetUser() string {
+	return o.User
+}
+
 type OutputFileConfig struct {
-	Path   string
-	User   string
-	Buffer OutputBufferConfig
+	Path     string
+	User     string
+	Sampling OutputSamplingConfig
+	Buffer   OutputBufferConfig
 }
 
 func (o *OutputFileConfig) Validate() *ValidationError {
@@ -340,6 +709,9 @@ func (o *OutputFileConfig) Validate() *ValidationError {
 	if o.Path == "" {
 		err.Add(errors.New("Path must not be empty"))
 	}
+	if serr := o.Sampling.Validate(); serr != nil {
+		err.Add(serr)
+	}
 	return err.Err()
 }
 
@@ -351,9 +723,10 @@ func (o *OutputFileConfig) GetUser() string {
 }
 
 type OutputTCPSocketConfig struct {
-	Host   string
-	Port   uint16
-	Buffer OutputBufferConfig
+	Host     string
+	Port     uint16
+	Sampling OutputSamplingConfig
+	Buffer   OutputBufferConfig
 }
 
 func (o *OutputTCPSocketConfig) Validate() *ValidationError {
@@ -361,6 +734,9 @@ func (o *OutputTCPSocketConfig) Validate() *ValidationError {
 	if o.Host == "" {
 		err.Add(errors.New("Host must not be empty"))
 	}
+	if serr := o.Sampling.Validate(); serr != nil {
+		err.Add(serr)
+	}
 	return err.Err()
 }
 
@@ -377,11 +753,12 @@ func (o *OutputTCPSocketConfig) GetAddress() string {
 }
 
 type OutputFluentConfig struct {
-	Host   string
-	Tag    string
-	Port   uint16
-	Flat   FlatConfig
-	Buffer OutputBufferConfig
+	Host     string
+	Tag      string
+	Port     uint16
+	Flat     FlatConfig
+	Sampling OutputSamplingConfig
+	Buffer   OutputBufferConfig
 }
 
 func (o *OutputFluentConfig) Validate() *ValidationError {
@@ -410,6 +787,9 @@ func (o *OutputFluentConfig) Validate() *ValidationError {
 	if err := o.Flat.Validate(); err != nil {
 		valerr.Add(err)
 	}
+	if err := o.Sampling.Validate(); err != nil {
+		valerr.Add(err)
+	}
 	return valerr.Err()
 }
 
@@ -437,6 +817,7 @@ type OutputKafkaConfig struct {
 	OutputType       string
 	Buffer           OutputBufferConfig
 	Flat             FlatConfig
+	Sampling         OutputSamplingConfig
 }
 
 func (o *OutputKafkaConfig) Validate() *ValidationError {
@@ -456,6 +837,18 @@ func (o *OutputKafkaConfig) Validate() *ValidationError {
 		valerr.Add(errors.New("OutputType must be avro, json or protobuf"))
 	}
 	o.OutputType = otype
+	if otype == "avro" && o.Flat.Fields.IsSet() {
+		valerr.Add(errors.New("Flat.Fields can't be used with avro"))
+	}
+	if otype == "avro" && o.Flat.GetTimestampFormat() != "rfc3339" {
+		valerr.Add(errors.New("Flat.TimestampFormat must be rfc3339 with avro"))
+	}
+	if err := o.Flat.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	if err := o.Sampling.Validate(); err != nil {
+		valerr.Add(err)
+	}
 	return valerr.Err()
 }
 
@@ -488,6 +881,7 @@ type OutputNatsConfig struct {
 	Password string
 	Token    string
 	Flat     FlatConfig
+	Sampling OutputSamplingConfig
 	Buffer   OutputBufferConfig
 }
 
@@ -496,6 +890,9 @@ func (o *OutputNatsConfig) Validate() *ValidationError {
 	if err := o.Flat.Validate(); err != nil {
 		valerr.Add(err)
 	}
+	if err := o.Sampling.Validate(); err != nil {
+		valerr.Add(err)
+	}
 
 	return valerr.Err()
 }
@@ -519,6 +916,7 @@ func (o *OutputNatsConfig) GetToken() string {
 type OutputPrometheus struct {
 	Counters []OutputPrometheusMetrics
 	Flat     FlatConfig
+	Sampling OutputSamplingConfig
 	Buffer   OutputBufferConfig
 }
 
@@ -530,18 +928,75 @@ func (o *OutputPrometheus) GetCounters() []OutputPrometheusMetrics {
 }
 
 func (o *OutputPrometheus) Validate() *ValidationError {
-	return nil
+	valerr := NewValidationError()
+	counters := o.GetCounters()
+	for i := range counters {
+		if err := counters[i].Validate(); err != nil {
+			valerr.Add(fmt.Errorf("%s: %w", counters[i].GetName(), err))
+		}
+	}
+	if err := o.Flat.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	if err := o.Sampling.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	// sampled frames are scaled by the rate, observations of histograms and summaries can't be
+	if o.Sampling.GetMode() != "" {
+		for i := range counters {
+			if t := counters[i].GetType(); t == "histogram" || t == "summary" {
+				valerr.Add(fmt.Errorf("%s: Sampling can't be used with %s", counters[i].GetName(), t))
+			}
+		}
+	}
+	return valerr.Err()
 }
 
 type OutputPrometheusMetrics struct {
 	Name           string
 	Help           string
+	Type           string
+	Value          string
+	Buckets        []float64
+	Quantiles      []float64
 	Labels         []string
 	Limit          int
+	LimitPolicy    string
+	OverflowLabel  string
 	ExpireInterval int
 	ExpireSec      int
 }
 
+func (o *OutputPrometheusMetrics) Validate() *ValidationError {
+	valerr := NewValidationError()
+	if o.Name == "" {
+		valerr.Add(errors.New("Name must not be empty"))
+	}
+	o.Type = strings.ToLower(o.Type)
+	switch o.Type {
+	case "", "counter":
+	case "histogram", "summary":
+		if o.Value == "" {
+			valerr.Add(errors.New("Type histogram and summary need Value"))
+		}
+	default:
+		valerr.Add(errors.New("Type must be counter, histogram or summary"))
+	}
+	o.LimitPolicy = strings.ToLower(o.LimitPolicy)
+	switch o.LimitPolicy {
+	case "", "overflow", "lru", "lfu":
+	default:
+		valerr.Add(errors.New("LimitPolicy must be overflow, lru or lfu"))
+	}
+	for _, q := range o.Quantiles {
+		if q <= 0 || q >= 1 {
+			valerr.Add(errors.New("Quantiles must include range 0 to 1"))
+			break
+		}
+	}
+	return valerr.Err()
+}
+
 func (o *OutputPrometheusMetrics) GetName() string {
 	return o.Name
 }
@@ -550,6 +1005,39 @@ func (o *OutputPrometheusMetrics) GetHelp() string {
 	return o.Help
 }
 
+// GetType returns counter, histogram or summary.
+func (o *OutputPrometheusMetrics) GetType() string {
+	if o.Type == "" {
+		return "counter"
+	}
+	return strings.ToLower(o.Type)
+}
+
+// GetValue returns the name of the observed value.
+// Latency or a numeric field of DnstapFlatT such as MessageSize.
+func (o *OutputPrometheusMetrics) GetValue() string {
+	return o.Value
+}
+
+func (o *OutputPrometheusMetrics) GetBuckets() []float64 {
+	if len(o.Buckets) == 0 {
+		return prometheus.DefBuckets
+	}
+	return o.Buckets
+}
+
+func (o *OutputPrometheusMetrics) GetObjectives() map[float64]float64 {
+	quantiles := o.Quantiles
+	if len(quantiles) == 0 {
+		quantiles = []float64{0.5, 0.9, 0.99}
+	}
+	objectives := map[float64]float64{}
+	for _, q := range quantiles {
+		objectives[q] = (1 - q) / 10
+	}
+	return objectives
+}
+
 func (o *OutputPrometheusMetrics) GetLabels() []string {
 	return o.Labels
 }
@@ -558,6 +1046,21 @@ func (o *OutputPrometheusMetrics) GetLimit() int {
 	return o.Limit
 }
 
+// GetLimitPolicy returns overflow, lru or lfu.
+func (o *OutputPrometheusMetrics) GetLimitPolicy() string {
+	if o.LimitPolicy == "" {
+		return "overflow"
+	}
+	return strings.ToLower(o.LimitPolicy)
+}
+
+func (o *OutputPrometheusMetrics) GetOverflowLabel() string {
+	if o.OverflowLabel == "" {
+		return "__other__"
+	}
+	return o.OverflowLabel
+}
+
 func (o *OutputPrometheusMetrics) GetExpireInterval() int {
 	return o.ExpireInterval
 }
@@ -571,6 +1074,7 @@ type OutputStdoutConfig struct {
 	TemplateStr string             `toml:"template"`
 	template    *template.Template `toml:"-"`
 	Flat        FlatConfig
+	Sampling    OutputSamplingConfig
 	Buffer      OutputBufferConfig
 }
 
@@ -580,7 +1084,7 @@ func (o *OutputStdoutConfig) GetType() string {
 	}
 	return o.Type
 }
-func (o *OutputStdoutConfig) Validate() error {
+func (o *OutputStdoutConfig) Validate() *ValidationError {
 	valerr := NewValidationError()
 	o.Type = strings.ToLower(o.Type)
 	switch o.Type {
@@ -601,42 +1105,821 @@ func (o *OutputStdoutConfig) Validate() error {
 	if err := o.Flat.Validate(); err != nil {
 		valerr.Add(err)
 	}
+	if err := o.Sampling.Validate(); err != nil {
+		valerr.Add(err)
+	}
 	return valerr.Err()
 }
 
-type OutputBufferConfig struct {
-	BufferSize uint
+type OutputTopKConfig struct {
+	Name     string
+	Help     string
+	Keys     []string
+	K        uint
+	Sketch   string
+	Capacity uint
+	Width    uint
+	Depth    uint
+	Window   uint
+	Slots    uint
+	Path     string
+	Flat     FlatConfig
+	Buffer   OutputBufferConfig
 }
 
-func (o *OutputBufferConfig) GetBufferSize() uint {
-	if o.BufferSize == 0 {
-		return OutputBufferSize
+func (o *OutputTopKConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	if o.Name == "" {
+		valerr.Add(errors.New("Name must not be empty"))
 	}
-	return o.BufferSize
+	if len(o.Keys) == 0 {
+		valerr.Add(errors.New("Keys must not be empty"))
+	}
+	if o.Capacity != 0 && o.Capacity < o.GetK() {
+		valerr.Add(errors.New("Capacity must not be smaller than K"))
+	}
+	o.Sketch = strings.ToLower(o.Sketch)
+	switch o.Sketch {
+	case "", "space-saving", "count-min":
+	default:
+		valerr.Add(errors.New("Sketch must be space-saving or count-min"))
+	}
+	if o.Path != "" && o.Path[0] != '/' {
+		valerr.Add(errors.New("Path must start with /"))
+	}
+	switch path := o.GetPath(); {
+	case path == "/metrics", path == "/healthz", path == "/readyz", strings.HasPrefix(path, "/api/"):
+		valerr.Add(fmt.Errorf("Path %s is used by dtap", path))
+	}
+	if err := o.Flat.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	return valerr.Err()
 }
 
-type FlatConfig struct {
-	IPv4Mask       uint8
-	ipv4Mask       net.IPMask
-	IPv6Mask       uint8
-	ipv6Mask       net.IPMask
-	EnableECS      bool
-	EnableHashIP   bool
-	ipHashSalt     []byte `toml:"-"`
-	IPHashSaltPath string
+func (o *OutputTopKConfig) GetName() string {
+	return o.Name
 }
 
-func (o *FlatConfig) GetIPv4Mask() net.IPMask {
-	if o.ipv4Mask == nil {
-		if o.IPv4Mask == 0 {
-			o.IPv4Mask = 24
-		}
-		o.ipv4Mask = net.CIDRMask(int(o.IPv4Mask), 32)
+func (o *OutputTopKConfig) GetHelp() string {
+	if o.Help == "" {
+		return "Top " + strconv.Itoa(int(o.GetK())) + " of " + strings.Join(o.Keys, ",")
 	}
-	return o.ipv4Mask
+	return o.Help
 }
 
-func (o *FlatConfig) GetIPv6Mask() net.IPMask {
+func (o *OutputTopKConfig) GetKeys() []string {
+	return o.Keys
+}
+
+// GetK returns the number of entries exported as gauges.
+func (o *OutputTopKConfig) GetK() uint {
+	if o.K == 0 {
+		return 10
+	}
+	return o.K
+}
+
+// GetSketch returns space-saving or count-min.
+func (o *OutputTopKConfig) GetSketch() string {
+	if o.Sketch == "" {
+		return "space-saving"
+	}
+	return o.Sketch
+}
+
+// GetWidth returns the number of counters in a row of a Count-Min sketch.
+func (o *OutputTopKConfig) GetWidth() uint {
+	if o.Width == 0 {
+		return 8192
+	}
+	return o.Width
+}
+
+// GetDepth returns the number of rows of a Count-Min sketch.
+func (o *OutputTopKConfig) GetDepth() uint {
+	if o.Depth == 0 {
+		return 4
+	}
+	return o.Depth
+}
+
+// GetCapacity returns the number of counters of a Space-Saving sketch,
+// or the number of candidate keys of a Count-Min sketch.
+func (o *OutputTopKConfig) GetCapacity() uint {
+	if o.Capacity == 0 {
+		return o.GetK() * 100
+	}
+	return o.Capacity
+}
+
+// GetWindow returns the sliding window size in seconds.
+func (o *OutputTopKConfig) GetWindow() uint {
+	if o.Window == 0 {
+		return 60
+	}
+	return o.Window
+}
+
+// GetSlots returns the number of sub windows.
+func (o *OutputTopKConfig) GetSlots() uint {
+	if o.Slots == 0 {
+		return 6
+	}
+	return o.Slots
+}
+
+// GetPath returns the path of the HTTP endpoint serving the ranked list.
+func (o *OutputTopKConfig) GetPath() string {
+	if o.Path == "" {
+		return "/topk/" + o.Name
+	}
+	return o.Path
+}
+
+type OutputUniqueConfig struct {
+	Name        string
+	Help        string
+	Labels      []string
+	Value       string
+	Window      uint
+	Precision   uint8
+	PersistPath string
+	Flat        FlatConfig
+	Buffer      OutputBufferConfig
+}
+
+func (o *OutputUniqueConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	if o.Name == "" {
+		valerr.Add(errors.New("Name must not be empty"))
+	}
+	if o.Value == "" {
+		valerr.Add(errors.New("Value must not be empty"))
+	}
+	if o.Precision != 0 && (o.Precision < 4 || o.Precision > 18) {
+		valerr.Add(errors.New("Precision must include range 4 to 18"))
+	}
+	if err := o.Flat.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	return valerr.Err()
+}
+
+func (o *OutputUniqueConfig) GetName() string {
+	return o.Name
+}
+
+func (o *OutputUniqueConfig) GetHelp() string {
+	if o.Help == "" {
+		return "Estimated number of unique " + o.Value
+	}
+	return o.Help
+}
+
+func (o *OutputUniqueConfig) GetLabels() []string {
+	return o.Labels
+}
+
+// GetValue returns the field name counted distinctly.
+func (o *OutputUniqueConfig) GetValue() string {
+	return o.Value
+}
+
+// GetWindow returns the tumbling window size in seconds.
+func (o *OutputUniqueConfig) GetWindow() uint {
+	if o.Window == 0 {
+		return 60
+	}
+	return o.Window
+}
+
+// GetPrecision returns the HyperLogLog precision, it uses 2^Precision bytes per label set.
+func (o *OutputUniqueConfig) GetPrecision() uint8 {
+	if o.Precision == 0 {
+		return 12
+	}
+	return o.Precision
+}
+
+// GetPersistPath returns the strftime formatted path of snapshot files.
+func (o *OutputUniqueConfig) GetPersistPath() string {
+	return o.PersistPath
+}
+
+// OutputSummaryConfig is the DSC like datasets written to Path,
+// fluentd (FluentHost, FluentPort and FluentTag) is the only target they are forwarded to.
+type OutputSummaryConfig struct {
+	Interval   uint
+	Datasets   []string
+	Format     string
+	Path       string
+	FluentHost string
+	FluentPort uint16
+	FluentTag  string
+	Flat       FlatConfig
+	Buffer     OutputBufferConfig
+}
+
+func (o *OutputSummaryConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	supported := map[string]bool{}
+	for _, name := range SummaryDatasets {
+		supported[name] = true
+	}
+	for _, name := range o.Datasets {
+		if !supported[name] {
+			valerr.Add(fmt.Errorf("unknown dataset: %s", name))
+		}
+	}
+	o.Format = strings.ToLower(o.Format)
+	switch o.Format {
+	case "", "json", "csv":
+	default:
+		valerr.Add(errors.New("Format must be json or csv"))
+	}
+	if o.FluentHost != "" && o.FluentTag == "" {
+		valerr.Add(errors.New("FluentTag must not be empty"))
+	}
+	if err := o.Flat.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	return valerr.Err()
+}
+
+// GetInterval returns the aggregation interval in seconds.
+func (o *OutputSummaryConfig) GetInterval() uint {
+	if o.Interval == 0 {
+		return 60
+	}
+	return o.Interval
+}
+
+func (o *OutputSummaryConfig) GetDatasets() []string {
+	if len(o.Datasets) == 0 {
+		return SummaryDatasets
+	}
+	return o.Datasets
+}
+
+// GetFormat returns json or csv.
+func (o *OutputSummaryConfig) GetFormat() string {
+	if o.Format == "" {
+		return "json"
+	}
+	return strings.ToLower(o.Format)
+}
+
+// GetPath returns the strftime formatted path of summary files.
+// Empty means stdout.
+func (o *OutputSummaryConfig) GetPath() string {
+	return o.Path
+}
+
+func (o *OutputSummaryConfig) GetFluentHost() string {
+	return o.FluentHost
+}
+
+func (o *OutputSummaryConfig) GetFluentPort() int {
+	if o.FluentPort == 0 {
+		return 24224
+	}
+	return int(o.FluentPort)
+}
+
+func (o *OutputSummaryConfig) GetFluentTag() string {
+	return o.FluentTag
+}
+
+// OutputRSSAC002Config is the config of RSSAC002 daily reports.
+// Without Flat.EnableHashIP, unique sources are the addresses masked by Flat.IPv4Mask and Flat.IPv6Mask,
+// so the default masks count /24 and /48 prefixes, not sources.
+type OutputRSSAC002Config struct {
+	Path    string
+	Service string
+	Flat    FlatConfig
+	Buffer  OutputBufferConfig
+}
+
+func (o *OutputRSSAC002Config) Validate() *ValidationError {
+	valerr := NewValidationError()
+	if o.Path == "" {
+		valerr.Add(errors.New("Path must not be empty"))
+	} else if stat, err := os.Stat(o.Path); err != nil || !stat.IsDir() {
+		valerr.Add(fmt.Errorf("Path %s must be a directory", o.Path))
+	}
+	if err := o.Flat.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	return valerr.Err()
+}
+
+// GetPath returns the directory of report files.
+func (o *OutputRSSAC002Config) GetPath() string {
+	return o.Path
+}
+
+// GetService returns the service name of reports.
+// Empty means the identity of messages.
+func (o *OutputRSSAC002Config) GetService() string {
+	return o.Service
+}
+
+type OutputRandomSubdomainConfig struct {
+	Name           string
+	Help           string
+	Window         uint
+	Slots          uint
+	MinQueries     uint64
+	UniqueRatio    float64
+	ErrorRatio     float64
+	BaselineFactor float64
+	MaxDomains     uint
+	Path           string
+	FluentHost     string
+	FluentPort     uint16
+	FluentTag      string
+	Flat           FlatConfig
+	Buffer         OutputBufferConfig
+}
+
+func (o *OutputRandomSubdomainConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	if o.UniqueRatio < 0 || o.UniqueRatio > 1 {
+		valerr.Add(errors.New("UniqueRatio must be between 0 and 1"))
+	}
+	if o.ErrorRatio < 0 || o.ErrorRatio > 1 {
+		valerr.Add(errors.New("ErrorRatio must be between 0 and 1"))
+	}
+	if o.BaselineFactor != 0 && o.BaselineFactor <= 1 {
+		valerr.Add(errors.New("BaselineFactor must be greater than 1"))
+	}
+	if o.FluentHost != "" && o.FluentTag == "" {
+		valerr.Add(errors.New("FluentTag must not be empty"))
+	}
+	if err := o.Flat.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	return valerr.Err()
+}
+
+func (o *OutputRandomSubdomainConfig) GetName() string {
+	if o.Name == "" {
+		return "dtap_random_subdomain_flagged"
+	}
+	return o.Name
+}
+
+func (o *OutputRandomSubdomainConfig) GetHelp() string {
+	if o.Help == "" {
+		return "Registered domains flagged as random subdomain attack targets"
+	}
+	return o.Help
+}
+
+// GetWindow returns the sliding window size in seconds.
+func (o *OutputRandomSubdomainConfig) GetWindow() uint {
+	if o.Window == 0 {
+		return 60
+	}
+	return o.Window
+}
+
+// GetSlots returns the number of sub windows.
+func (o *OutputRandomSubdomainConfig) GetSlots() uint {
+	if o.Slots == 0 {
+		return 6
+	}
+	return o.Slots
+}
+
+// GetMinQueries returns the number of queries in the window needed to evaluate a domain.
+func (o *OutputRandomSubdomainConfig) GetMinQueries() uint64 {
+	if o.MinQueries == 0 {
+		return 100
+	}
+	return o.MinQueries
+}
+
+// GetUniqueRatio returns the threshold of unique labels per query.
+func (o *OutputRandomSubdomainConfig) GetUniqueRatio() float64 {
+	if o.UniqueRatio == 0 {
+		return 0.8
+	}
+	return o.UniqueRatio
+}
+
+// GetErrorRatio returns the threshold of NXDOMAIN and SERVFAIL per response.
+func (o *OutputRandomSubdomainConfig) GetErrorRatio() float64 {
+	if o.ErrorRatio == 0 {
+		return 0.5
+	}
+	return o.ErrorRatio
+}
+
+// GetBaselineFactor returns the threshold of unique labels per baseline.
+// 0 means disabled.
+func (o *OutputRandomSubdomainConfig) GetBaselineFactor() float64 {
+	return o.BaselineFactor
+}
+
+// GetMaxDomains returns the number of domains tracked per slot.
+func (o *OutputRandomSubdomainConfig) GetMaxDomains() uint {
+	if o.MaxDomains == 0 {
+		return 10000
+	}
+	return o.MaxDomains
+}
+
+// GetPath returns the strftime formatted path of event files.
+// Empty means stdout.
+func (o *OutputRandomSubdomainConfig) GetPath() string {
+	return o.Path
+}
+
+func (o *OutputRandomSubdomainConfig) GetFluentHost() string {
+	return o.FluentHost
+}
+
+func (o *OutputRandomSubdomainConfig) GetFluentPort() int {
+	if o.FluentPort == 0 {
+		return 24224
+	}
+	return int(o.FluentPort)
+}
+
+func (o *OutputRandomSubdomainConfig) GetFluentTag() string {
+	return o.FluentTag
+}
+
+type OutputClientRateConfig struct {
+	Name       string
+	Help       string
+	Key        string
+	Thresholds []float64
+	Window     uint
+	MaxClients uint
+	Path       string
+	FluentHost string
+	FluentPort uint16
+	FluentTag  string
+	Flat       FlatConfig
+	Buffer     OutputBufferConfig
+}
+
+func (o *OutputClientRateConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	for _, threshold := range o.Thresholds {
+		if threshold <= 0 {
+			valerr.Add(errors.New("Thresholds must be greater than 0"))
+		}
+	}
+	if o.FluentHost != "" && o.FluentTag == "" {
+		valerr.Add(errors.New("FluentTag must not be empty"))
+	}
+	if err := o.Flat.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	return valerr.Err()
+}
+
+func (o *OutputClientRateConfig) GetName() string {
+	if o.Name == "" {
+		return "dtap_client_rate_over_limit_clients"
+	}
+	return o.Name
+}
+
+func (o *OutputClientRateConfig) GetHelp() string {
+	if o.Help == "" {
+		return "Number of clients over the query rate threshold"
+	}
+	return o.Help
+}
+
+// GetKey returns the field name of DnstapFlatT identifying clients.
+func (o *OutputClientRateConfig) GetKey() string {
+	if o.Key == "" {
+		return "QueryAddress"
+	}
+	return o.Key
+}
+
+// GetThresholds returns the query rates per second.
+func (o *OutputClientRateConfig) GetThresholds() []float64 {
+	if len(o.Thresholds) == 0 {
+		return []float64{100}
+	}
+	return o.Thresholds
+}
+
+// GetWindow returns the sliding window size in seconds.
+func (o *OutputClientRateConfig) GetWindow() uint {
+	if o.Window == 0 {
+		return 10
+	}
+	return o.Window
+}
+
+// GetMaxClients returns the number of tracked clients.
+func (o *OutputClientRateConfig) GetMaxClients() uint {
+	if o.MaxClients == 0 {
+		return 100000
+	}
+	return o.MaxClients
+}
+
+// GetPath returns the strftime formatted path of event files.
+// Empty means stdout.
+func (o *OutputClientRateConfig) GetPath() string {
+	return o.Path
+}
+
+func (o *OutputClientRateConfig) GetFluentHost() string {
+	return o.FluentHost
+}
+
+func (o *OutputClientRateConfig) GetFluentPort() int {
+	if o.FluentPort == 0 {
+		return 24224
+	}
+	return int(o.FluentPort)
+}
+
+func (o *OutputClientRateConfig) GetFluentTag() string {
+	return o.FluentTag
+}
+
+// DedupConfig is the duplicate suppression of input frames.
+// Key is payload (the whole frame) or message (the dnstap message without identity, version and extra).
+type DedupConfig struct {
+	Enable     bool
+	Key        string
+	Window     uint
+	Partitions uint
+	MaxEntries uint
+}
+
+func (o *DedupConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	o.Key = strings.ToLower(o.Key)
+	switch o.Key {
+	case "", "payload", "message":
+	default:
+		valerr.Add(errors.New("Key must be payload or message"))
+	}
+	if o.Partitions != 0 && o.MaxEntries != 0 && o.MaxEntries < o.Partitions {
+		valerr.Add(errors.New("MaxEntries must not be smaller than Partitions"))
+	}
+	return valerr.Err()
+}
+
+func (o *DedupConfig) GetKey() string {
+	if o.Key == "" {
+		return "payload"
+	}
+	return strings.ToLower(o.Key)
+}
+
+// GetWindow returns the window size in seconds.
+func (o *DedupConfig) GetWindow() uint {
+	if o.Window == 0 {
+		return 5
+	}
+	return o.Window
+}
+
+// GetPartitions returns the number of sub windows.
+func (o *DedupConfig) GetPartitions() uint {
+	if o.Partitions == 0 {
+		return 5
+	}
+	return o.Partitions
+}
+
+// GetMaxEntries returns the number of hashes kept in the window.
+func (o *DedupConfig) GetMaxEntries() uint {
+	if o.MaxEntries == 0 {
+		return 1000000
+	}
+	return o.MaxEntries
+}
+
+// TransformConfig is the rewriting of dnstap frames before they are sent to the outputs.
+type TransformConfig struct {
+	RedactSuffixes []string
+	HashSuffixes   []string
+	HashKey        string
+	StripMessage   bool
+	Identity       string
+	Extra          string
+	DropECS        bool
+}
+
+func (o *TransformConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	for _, suffix := range append(append([]string{}, o.RedactSuffixes...), o.HashSuffixes...) {
+		if _, ok := dns.IsDomainName(suffix); !ok || dns.Fqdn(suffix) == "." {
+			valerr.Add(fmt.Errorf("invalid suffix: %s", suffix))
+		}
+	}
+	if len(o.HashSuffixes) > 0 && o.HashKey == "" {
+		valerr.Add(errors.New("HashSuffixes needs HashKey"))
+	}
+	return valerr.Err()
+}
+
+// Enabled returns true if any transform is configured.
+func (o *TransformConfig) Enabled() bool {
+	return len(o.RedactSuffixes) > 0 || len(o.HashSuffixes) > 0 || o.StripMessage ||
+		o.Identity != "" || o.Extra != "" || o.DropECS
+}
+
+type OutputBufferConfig struct {
+	BufferSize uint
+}
+
+func (o *OutputBufferConfig) GetBufferSize() uint {
+	if o.BufferSize == 0 {
+		return OutputBufferSize
+	}
+	return o.BufferSize
+}
+
+// OutputSamplingConfig is the sampling of frames per output.
+// Mode is random, client, qname or errors. Empty means no sampling.
+type OutputSamplingConfig struct {
+	Mode string
+	Rate float64
+}
+
+func (o *OutputSamplingConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	o.Mode = strings.ToLower(o.Mode)
+	switch o.Mode {
+	case "":
+		return nil
+	case "random", "client", "qname", "errors":
+	default:
+		valerr.Add(errors.New("Sampling.Mode must be random, client, qname or errors"))
+	}
+	if o.Rate <= 0 || o.Rate > 1 {
+		valerr.Add(errors.New("Sampling.Rate must be greater than 0 and less than or equal to 1"))
+	}
+	return valerr.Err()
+}
+
+func (o *OutputSamplingConfig) GetMode() string {
+	return strings.ToLower(o.Mode)
+}
+
+func (o *OutputSamplingConfig) GetRate() float64 {
+	return o.Rate
+}
+
+type FlatConfig struct {
+	IPv4Mask                 uint8
+	ipv4Mask                 net.IPMask
+	IPv6Mask                 uint8
+	ipv6Mask                 net.IPMask
+	EnableECS                bool
+	EnableHashIP             bool
+	ipHashSalt               []byte `toml:"-"`
+	IPHashSaltPath           string
+	IPHashSaltRotateInterval uint
+	IPHashSaltRotateOffset   uint
+	ipHashSecret             []byte `toml:"-"`
+	ipHashSaltEpoch          int64  `toml:"-"`
+	saltMux                  sync.Mutex
+	Fields                   FlatFieldsConfig
+	fields                   *FlatFields
+	fieldsErr                error
+	TimestampFormat          string
+	Timezone                 string
+	location                 *time.Location
+	Suspicious               FlatSuspiciousConfig
+	suspiciousScorer         *SuspiciousScorer
+}
+
+// FlatFieldsConfig selects the fields of flattened messages by JSON field name.
+// Rename and Constants are lists of "name=value".
+type FlatFieldsConfig struct {
+	Include   []string
+	Exclude   []string
+	Rename    []string
+	Constants []string
+}
+
+// IsSet returns whether any field is selected, dropped, renamed or added.
+func (o *FlatFieldsConfig) IsSet() bool {
+	return len(o.Include) > 0 || len(o.Exclude) > 0 || len(o.Rename) > 0 || len(o.Constants) > 0
+}
+
+// FlatSuspiciousConfig is the thresholds of the DNS tunneling and DGA heuristics.
+// A message is suspicious when Threshold heuristics exceed their thresholds.
+type FlatSuspiciousConfig struct {
+	Enable       bool
+	Threshold    uint
+	Entropy      float64
+	LabelLength  uint
+	DigitRatio   float64
+	ConsonantRun uint
+	Qtypes       []string
+	DomainBytes  uint64
+	Window       uint
+	MaxDomains   uint
+}
+
+func (o *FlatSuspiciousConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	if o.DigitRatio < 0 || o.DigitRatio > 1 {
+		valerr.Add(errors.New("Suspicious.DigitRatio must be between 0 and 1"))
+	}
+	if o.Entropy < 0 {
+		valerr.Add(errors.New("Suspicious.Entropy must not be negative"))
+	}
+	return valerr.Err()
+}
+
+// GetThreshold returns the number of heuristics needed to flag a message.
+func (o *FlatSuspiciousConfig) GetThreshold() uint {
+	if o.Threshold == 0 {
+		return 2
+	}
+	return o.Threshold
+}
+
+// GetEntropy returns the threshold of the Shannon entropy (bits per character) of the qname labels.
+func (o *FlatSuspiciousConfig) GetEntropy() float64 {
+	if o.Entropy == 0 {
+		return 3.5
+	}
+	return o.Entropy
+}
+
+func (o *FlatSuspiciousConfig) GetLabelLength() uint {
+	if o.LabelLength == 0 {
+		return 40
+	}
+	return o.LabelLength
+}
+
+func (o *FlatSuspiciousConfig) GetDigitRatio() float64 {
+	if o.DigitRatio == 0 {
+		return 0.3
+	}
+	return o.DigitRatio
+}
+
+func (o *FlatSuspiciousConfig) GetConsonantRun() uint {
+	if o.ConsonantRun == 0 {
+		return 5
+	}
+	return o.ConsonantRun
+}
+
+func (o *FlatSuspiciousConfig) GetQtypes() []string {
+	if len(o.Qtypes) == 0 {
+		return []string{"TXT", "NULL"}
+	}
+	return o.Qtypes
+}
+
+// GetDomainBytes returns the threshold of message bytes per registered domain in the window.
+func (o *FlatSuspiciousConfig) GetDomainBytes() uint64 {
+	if o.DomainBytes == 0 {
+		return 1000000
+	}
+	return o.DomainBytes
+}
+
+// GetWindow returns the window size of DomainBytes in seconds.
+func (o *FlatSuspiciousConfig) GetWindow() uint {
+	if o.Window == 0 {
+		return 60
+	}
+	return o.Window
+}
+
+// GetMaxDomains returns the number of domains counted in a window.
+func (o *FlatSuspiciousConfig) GetMaxDomains() uint {
+	if o.MaxDomains == 0 {
+		return 100000
+	}
+	return o.MaxDomains
+}
+
+func (o *FlatConfig) GetIPv4Mask() net.IPMask {
+	if o.ipv4Mask == nil {
+		if o.IPv4Mask == 0 {
+			o.IPv4Mask = 24
+		}
+		o.ipv4Mask = net.CIDRMask(int(o.IPv4Mask), 32)
+	}
+	return o.ipv4Mask
+}
+
+func (o *FlatConfig) GetIPv6Mask() net.IPMask {
 	if o.ipv6Mask == nil {
 		if o.IPv6Mask == 0 {
 			o.IPv6Mask = 48
@@ -654,27 +1937,124 @@ func (o *FlatConfig) GetEnableHashIP() bool {
 	return o.EnableHashIP
 }
 
+// GetFields returns the error of Fields, not to write all fields with a bad config.
+func (o *FlatConfig) GetFields() (*FlatFields, error) {
+	if o.fields == nil && o.fieldsErr == nil {
+		o.fields, o.fieldsErr = NewFlatFields(&o.Fields)
+	}
+	return o.fields, o.fieldsErr
+}
+
+// GetSuspiciousScorer returns nil if the heuristics are disabled.
+func (o *FlatConfig) GetSuspiciousScorer() *SuspiciousScorer {
+	if o.suspiciousScorer == nil && o.Suspicious.Enable {
+		o.suspiciousScorer = NewSuspiciousScorer(&o.Suspicious)
+	}
+	return o.suspiciousScorer
+}
+
+// GetTimestampFormat returns rfc3339, unix, unix_milli or unix_micro.
+func (o *FlatConfig) GetTimestampFormat() string {
+	if o.TimestampFormat == "" {
+		return "rfc3339"
+	}
+	return o.TimestampFormat
+}
+
+// GetLocation returns the time zone of rfc3339 timestamps.
+// Default is UTC, Timezone = "Local" selects the local time zone.
+func (o *FlatConfig) GetLocation() *time.Location {
+	if o.location == nil {
+		if o.Timezone != "" {
+			o.location, _ = time.LoadLocation(o.Timezone)
+		}
+		if o.location == nil {
+			o.location = time.UTC
+		}
+	}
+	return o.location
+}
+
 func (o *FlatConfig) GetIPHashSaltPath() string {
 	return o.IPHashSaltPath
 }
 
+// GetIPHashSaltRotateInterval returns the salt rotation interval in seconds.
+// 0 means the salt is never rotated.
+func (o *FlatConfig) GetIPHashSaltRotateInterval() uint {
+	return o.IPHashSaltRotateInterval
+}
+
+// GetIPHashSaltRotateOffset returns the offset in seconds from 00:00 UTC
+// at which the salt is rotated.
+func (o *FlatConfig) GetIPHashSaltRotateOffset() uint {
+	return o.IPHashSaltRotateOffset
+}
+
+// GetIPHashSalt returns the salt for hashing addresses.
+// When rotation is enabled, the salt is derived from the master secret
+// (the content of IPHashSaltPath) and the start of the current epoch.
 func (o *FlatConfig) GetIPHashSalt() []byte {
-	if o.ipHashSalt == nil {
+	o.saltMux.Lock()
+	defer o.saltMux.Unlock()
+	if o.ipHashSecret == nil {
 		if o.GetIPHashSaltPath() != "" {
-			o.LoadSalt()
+			if err := o.loadSalt(); err != nil {
+				log.Errorf("use a random salt: %v", err)
+			}
 		}
 	}
-	if o.ipHashSalt == nil {
-		o.ipHashSalt = make([]byte, 32)
-		rand.Read(o.ipHashSalt)
+	if o.ipHashSecret == nil {
+		o.ipHashSecret = make([]byte, 32)
+		rand.Read(o.ipHashSecret)
+	}
+	interval := o.GetIPHashSaltRotateInterval()
+	if interval == 0 {
+		o.ipHashSalt = o.ipHashSecret
+		return o.ipHashSalt
+	}
+	epoch := IPHashSaltEpoch(time.Now(), interval, o.GetIPHashSaltRotateOffset())
+	if o.ipHashSalt == nil || epoch != o.ipHashSaltEpoch {
+		o.ipHashSalt = DeriveIPHashSalt(o.ipHashSecret, epoch)
+		o.ipHashSaltEpoch = epoch
+		IPHashSaltEpochGauge.WithLabelValues(strconv.Itoa(int(interval))).Set(float64(epoch))
 	}
 	return o.ipHashSalt
 }
 
-func (o *FlatConfig) LoadSalt() {
-	if o.GetIPHashSaltPath() != "" {
-		o.ipHashSalt, _ = ioutil.ReadFile(o.GetIPHashSaltPath())
+// GetIPHashSaltEpoch returns the start time (unix seconds) of the epoch
+// which the last salt returned by GetIPHashSalt belongs to.
+// 0 means the salt is not rotated.
+func (o *FlatConfig) GetIPHashSaltEpoch() int64 {
+	if o.GetIPHashSaltRotateInterval() == 0 {
+		return 0
 	}
+	o.saltMux.Lock()
+	defer o.saltMux.Unlock()
+	return o.ipHashSaltEpoch
+}
+
+// LoadSalt reads the salt file, the current salt is kept on error.
+func (o *FlatConfig) LoadSalt() error {
+	o.saltMux.Lock()
+	defer o.saltMux.Unlock()
+	return o.loadSalt()
+}
+
+func (o *FlatConfig) loadSalt() error {
+	if o.GetIPHashSaltPath() == "" {
+		return nil
+	}
+	secret, err := ioutil.ReadFile(o.GetIPHashSaltPath())
+	if err != nil {
+		return fmt.Errorf("failed to read salt file %s err: %w", o.GetIPHashSaltPath(), err)
+	}
+	if len(secret) == 0 {
+		return fmt.Errorf("salt file %s is empty", o.GetIPHashSaltPath())
+	}
+	o.ipHashSecret = secret
+	o.ipHashSalt = nil
+	return nil
 }
 
 func (o *FlatConfig) WatchSalt(ctx context.Context, ready chan struct{}) {
@@ -698,7 +2078,9 @@ L:
 				break L
 			}
 			log.Info("event:", event)
-			o.LoadSalt()
+			if err := o.LoadSalt(); err != nil {
+				log.Error(err)
+			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return
@@ -720,5 +2102,115 @@ func (o *FlatConfig) Validate() *ValidationError {
 			valerr.Add(errors.New("IPv4Mask must include range 0 to 128"))
 		}
 	}
+	if o.IPHashSaltRotateInterval != 0 {
+		if o.IPHashSaltRotateOffset >= o.IPHashSaltRotateInterval {
+			valerr.Add(errors.New("IPHashSaltRotateOffset must be less than IPHashSaltRotateInterval"))
+		}
+		// a random secret makes different salts on each process
+		if o.IPHashSaltPath == "" {
+			valerr.Add(errors.New("IPHashSaltPath must be set with IPHashSaltRotateInterval"))
+		}
+	}
+	if _, err := NewFlatFields(&o.Fields); err != nil {
+		valerr.Add(fmt.Errorf("Fields: %w", err))
+	}
+	o.TimestampFormat = strings.ToLower(o.TimestampFormat)
+	switch o.TimestampFormat {
+	case "", "rfc3339", "unix", "unix_milli", "unix_micro":
+	default:
+		valerr.Add(errors.New("TimestampFormat must be rfc3339, unix, unix_milli or unix_micro"))
+	}
+	if o.Timezone != "" {
+		if _, err := time.LoadLocation(o.Timezone); err != nil {
+			valerr.Add(fmt.Errorf("invalid Timezone: %w", err))
+		}
+	}
+	if err := o.Suspicious.Validate(); err != nil {
+		valerr.Add(err)
+	}
+	return valerr.Err()
+}
+
+// AdminConfig enables the POST endpoints of the admin API, they are disabled by default.
+// If Token is set, the requests need the header "Authorization: Bearer <Token>".
+type AdminConfig struct {
+	Enable bool
+	Token  string
+}
+
+// HealthConfig is the criteria of /healthz (Liveness) and /readyz (Readiness).
+type HealthConfig struct {
+	Liveness  HealthCheckConfig
+	Readiness HealthCheckConfig
+}
+
+func (o *HealthConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	if err := o.Liveness.Validate(); err != nil {
+		for _, e := range err.errors {
+			valerr.Add(fmt.Errorf("Liveness: %w", e))
+		}
+	}
+	if err := o.Readiness.Validate(); err != nil {
+		for _, e := range err.errors {
+			valerr.Add(fmt.Errorf("Readiness: %w", e))
+		}
+	}
+	return valerr.Err()
+}
+
+// GetLiveness returns the liveness criteria, all inputs must be running by default.
+func (o *HealthConfig) GetLiveness() *HealthCheckConfig {
+	c := o.Liveness
+	if c.Inputs == "" {
+		c.Inputs = "all"
+	}
+	if c.Outputs == "" {
+		c.Outputs = "none"
+	}
+	return &c
+}
+
+// GetReadiness returns the readiness criteria, all inputs must be running
+// and any output must be connected by default.
+func (o *HealthConfig) GetReadiness() *HealthCheckConfig {
+	c := o.Readiness
+	if c.Inputs == "" {
+		c.Inputs = "all"
+	}
+	if c.Outputs == "" {
+		c.Outputs = "any"
+	}
+	return &c
+}
+
+// HealthCheckConfig is the criteria of a health check.
+// Inputs and Outputs are all, any or none of them must be running or connected.
+// BufferFill is the max ratio of buffered frames in the input and output buffers, 0 is unlimited.
+// IdleTimeout is the max seconds without frames written by outputs, 0 is unlimited.
+type HealthCheckConfig struct {
+	Inputs      string
+	Outputs     string
+	BufferFill  float64
+	IdleTimeout uint
+}
+
+func (o *HealthCheckConfig) Validate() *ValidationError {
+	valerr := NewValidationError()
+	o.Inputs = strings.ToLower(o.Inputs)
+	switch o.Inputs {
+	case "", "all", "any", "none":
+	default:
+		valerr.Add(errors.New("Inputs must be all, any or none"))
+	}
+	o.Outputs = strings.ToLower(o.Outputs)
+	switch o.Outputs {
+	case "", "all", "any", "none":
+	default:
+		valerr.Add(errors.New("Outputs must be all, any or none"))
+	}
+	if o.BufferFill < 0 || o.BufferFill > 1 {
+		valerr.Add(errors.New("BufferFill must include range 0 to 1"))
+	}
 	return valerr.Err()
 }
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// UnknownConfigKey is a key of the config file which no setting reads.
+type UnknownConfigKey struct {
+	Key  string
+	Line int
+	Col  int
+}
+
+func (k *UnknownConfigKey) String() string {
+	return fmt.Sprintf("line %d: unknown key %s", k.Line, k.Key)
+}
+
+// UnknownConfigKeys returns the unknown keys of the TOML config in line order.
+// Keys match the settings case-insensitively like NewConfigFromReader.
+func UnknownConfigKeys(r io.Reader) ([]*UnknownConfigKey, error) {
+	tree, err := toml.LoadReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	res := []*UnknownConfigKey{}
+	unknownConfigKeys(tree, reflect.TypeOf(Config{}), "", &res)
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Line != res[j].Line {
+			return res[i].Line < res[j].Line
+		}
+		return res[i].Col < res[j].Col
+	})
+	return res, nil
+}
+
+func unknownConfigKeys(tree *toml.Tree, t reflect.Type, prefix string, res *[]*UnknownConfigKey) {
+	for _, key := range tree.Keys() {
+		path := []string{key}
+		field, ok := configField(t, key)
+		if !ok {
+			pos := tree.GetPositionPath(path)
+			*res = append(*res, &UnknownConfigKey{Key: prefix + key, Line: pos.Line, Col: pos.Col})
+			continue
+		}
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct {
+			continue
+		}
+		switch v := tree.GetPath(path).(type) {
+		case *toml.Tree:
+			unknownConfigKeys(v, ft, prefix+key+".", res)
+		case []*toml.Tree:
+			for i, child := range v {
+				unknownConfigKeys(child, ft, fmt.Sprintf("%s%s[%d].", prefix, key, i), res)
+			}
+		}
+	}
+}
+
+// configField returns the exported field read by the key.
+func configField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("mapstructure"), ",")[0]; tag != "" {
+			name = tag
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
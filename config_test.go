@@ -112,3 +112,130 @@ User="unbound"
 	time.Sleep(10 * time.Second)
 	assert.Equal(t, c.OutputNats[0].Flat.GetIPHashSalt(), []byte{20, 30, 40, 50})
 }
+
+func TestFlatConfigSaltRotation(t *testing.T) {
+	cfg := `[[OutputNats]]
+	Host = "nats://host1:4242"
+	Subject = "query"
+	[OutputNats.flat]
+		EnableHashIP = true
+		IPHashSaltPath = "/tmp/salt_rotate.test"
+		IPHashSaltRotateInterval = 86400
+`
+	f, err := os.Create("/tmp/salt_rotate.test")
+	if err != nil {
+		t.Fatal("failed to create salt")
+	}
+	defer os.Remove("/tmp/salt_rotate.test")
+	f.Write([]byte{10, 20, 30, 40})
+	f.Close()
+	b := bytes.NewBufferString(cfg)
+	c, err := dtap.NewConfigFromReader(b)
+	assert.NoError(t, err)
+	flat := &c.OutputNats[0].Flat
+	assert.Nil(t, flat.Validate())
+
+	salt := flat.GetIPHashSalt()
+	epoch := flat.GetIPHashSaltEpoch()
+	assert.Equal(t, int64(0), epoch%86400)
+	assert.Equal(t, dtap.IPHashSaltEpoch(time.Now(), 86400, 0), epoch)
+	assert.Equal(t, dtap.DeriveIPHashSalt([]byte{10, 20, 30, 40}, epoch), salt)
+	assert.NotEqual(t, dtap.DeriveIPHashSalt([]byte{10, 20, 30, 40}, epoch+86400), salt)
+
+	assert.Equal(t, int64(3600), dtap.IPHashSaltEpoch(time.Unix(86399, 0), 86400, 3600))
+	assert.Equal(t, int64(86400+3600), dtap.IPHashSaltEpoch(time.Unix(86400+3600, 0), 86400, 3600))
+
+	flat.IPHashSaltRotateOffset = 86400
+	assert.NotNil(t, flat.Validate())
+	flat.IPHashSaltRotateOffset = 0
+
+	// the salt is kept when the file can't be read
+	os.Remove("/tmp/salt_rotate.test")
+	assert.Error(t, flat.LoadSalt())
+	assert.Equal(t, salt, flat.GetIPHashSalt())
+
+	// rotation needs the secret shared by processes
+	flat.IPHashSaltPath = ""
+	assert.NotNil(t, flat.Validate())
+}
+
+func TestOutputPrometheusConfig(t *testing.T) {
+	cfg := `[[OutputPrometheus]]
+[[OutputPrometheus.Counters]]
+	Name = "dtap_response_latency_seconds"
+	Help = "Latency of response messages."
+	Type = "Histogram"
+	Value = "Latency"
+	Buckets = [0.001, 0.01, 0.1]
+	Labels = ["Qtype", "Rcode"]
+[[OutputPrometheus.Counters]]
+	Name = "dtap_response_size_bytes"
+	Type = "summary"
+	Value = "MessageSize"
+	Quantiles = [0.5, 0.9]
+`
+	b := bytes.NewBufferString(cfg)
+	c, err := dtap.NewConfigFromReader(b)
+	assert.NoError(t, err)
+	assert.Nil(t, c.OutputPrometheus[0].Validate())
+	counters := c.OutputPrometheus[0].GetCounters()
+	assert.Equal(t, "histogram", counters[0].GetType())
+	assert.Equal(t, []float64{0.001, 0.01, 0.1}, counters[0].GetBuckets())
+	assert.Equal(t, []string{"Qtype", "Rcode"}, counters[0].GetLabels())
+	assert.Equal(t, "summary", counters[1].GetType())
+	assert.Len(t, counters[1].GetObjectives(), 2)
+
+	c.OutputPrometheus[0].Sampling = dtap.OutputSamplingConfig{Mode: "random", Rate: 0.5}
+	assert.NotNil(t, c.OutputPrometheus[0].Validate())
+	c.OutputPrometheus[0].Sampling = dtap.OutputSamplingConfig{}
+
+	counters[1].Value = ""
+	assert.NotNil(t, c.OutputPrometheus[0].Validate())
+}
+
+func TestOutputKafkaConfigAvroFields(t *testing.T) {
+	config := &dtap.OutputKafkaConfig{
+		Hosts:      []string{"localhost:9092"},
+		Topic:      "dnstap",
+		OutputType: "avro",
+	}
+	assert.Nil(t, config.Validate())
+	config.Flat.Fields.Exclude = []string{"extra"}
+	assert.NotNil(t, config.Validate())
+	config.OutputType = "json"
+	assert.Nil(t, config.Validate())
+}
+
+func TestUnknownConfigKeys(t *testing.T) {
+	cfg := `InputMsgBuffer = 10000
+[[InputUnix]]
+type = "sock"
+Path="/var/log/unbound/dnstap.sock"
+
+[[OutputNats]]
+	Host = "nats://host1:4242"
+	[OutputNats.flat]
+		IPv4Mask = 22
+		IPHashSalt = "bb"
+
+[[OutputKafks]]
+	Hosts = ["localhost:9092"]
+
+[[OutputPrometheus]]
+	[[OutputPrometheus.counters]]
+	Name = "dtap_query_qtype_total"
+	Lables = ["Qtype"]
+`
+	keys, err := dtap.UnknownConfigKeys(bytes.NewBufferString(cfg))
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 4) {
+		assert.Equal(t, "line 3: unknown key InputUnix[0].type", keys[0].String())
+		assert.Equal(t, "line 10: unknown key OutputNats[0].flat.IPHashSalt", keys[1].String())
+		assert.Equal(t, "OutputKafks", keys[2].Key)
+		assert.Equal(t, 12, keys[2].Line)
+		assert.Equal(t, "OutputPrometheus[0].counters[0].Lables", keys[3].Key)
+	}
+
+	_, err = dtap.UnknownConfigKeys(bytes.NewBufferString("[[InputUnix]\n"))
+	assert.Error(t, err)
+}
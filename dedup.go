@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	DedupDroppedFrames = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dtap_dedup_dropped_frames_total",
+		Help: "The total number of duplicate frames dropped",
+	})
+	DedupUntrackedFrames = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dtap_dedup_untracked_frames_total",
+		Help: "The total number of frames not tracked because of MaxEntries",
+	})
+)
+
+// Deduplicator drops the frames seen within the window.
+// The hashes of frames are kept in the sets of sub windows, and the oldest set is dropped at once.
+type Deduplicator struct {
+	key        string
+	slot       time.Duration
+	maxEntries int
+	partitions []map[uint64]struct{}
+	current    int
+	slotStart  time.Time
+}
+
+// NewDeduplicator returns nil if dedup is disabled.
+func NewDeduplicator(config *DedupConfig) *Deduplicator {
+	if !config.Enable {
+		return nil
+	}
+	d := &Deduplicator{
+		key:        config.GetKey(),
+		slot:       time.Duration(config.GetWindow()) * time.Second / time.Duration(config.GetPartitions()),
+		maxEntries: int(config.GetMaxEntries() / config.GetPartitions()),
+		partitions: make([]map[uint64]struct{}, config.GetPartitions()),
+	}
+	for i := range d.partitions {
+		d.partitions[i] = map[uint64]struct{}{}
+	}
+	return d
+}
+
+func (d *Deduplicator) rotate(now time.Time) {
+	if d.slotStart.IsZero() {
+		d.slotStart = now
+		return
+	}
+	for i := 0; i < len(d.partitions) && !now.Before(d.slotStart.Add(d.slot)); i++ {
+		d.current = (d.current + 1) % len(d.partitions)
+		d.partitions[d.current] = map[uint64]struct{}{}
+		d.slotStart = d.slotStart.Add(d.slot)
+	}
+	if !now.Before(d.slotStart.Add(d.slot)) {
+		d.slotStart = now
+	}
+}
+
+func (d *Deduplicator) hash(frame []byte) uint64 {
+	if d.key == "message" {
+		dt := dnstap.Dnstap{}
+		if err := proto.Unmarshal(frame, &dt); err == nil {
+			if b, err := proto.Marshal(dt.GetMessage()); err == nil {
+				return hash64(string(b))
+			}
+		}
+	}
+	return hash64(string(frame))
+}
+
+// Duplicate returns true if the frame was seen within the window.
+// It isn't safe for concurrent use.
+func (d *Deduplicator) Duplicate(frame []byte, now time.Time) bool {
+	if d == nil {
+		return false
+	}
+	d.rotate(now)
+	h := d.hash(frame)
+	for _, p := range d.partitions {
+		if _, ok := p[h]; ok {
+			DedupDroppedFrames.Inc()
+			return true
+		}
+	}
+	if len(d.partitions[d.current]) >= d.maxEntries {
+		DedupUntrackedFrames.Inc()
+		return false
+	}
+	d.partitions[d.current][h] = struct{}{}
+	return false
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"testing"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestDeduplicator(t *testing.T) {
+	assert.Nil(t, dtap.NewDeduplicator(&dtap.DedupConfig{}))
+
+	d := dtap.NewDeduplicator(&dtap.DedupConfig{Enable: true, Window: 5, Partitions: 5})
+	now := time.Unix(1600000000, 0)
+	a := []byte("frame a")
+	b := []byte("frame b")
+	assert.False(t, d.Duplicate(a, now))
+	assert.True(t, d.Duplicate(a, now.Add(time.Second)))
+	assert.False(t, d.Duplicate(b, now.Add(4*time.Second)))
+	assert.True(t, d.Duplicate(a, now.Add(4*time.Second)))
+	// a is expired with its partition
+	assert.False(t, d.Duplicate(a, now.Add(5*time.Second)))
+	assert.True(t, d.Duplicate(b, now.Add(5*time.Second)))
+	assert.False(t, d.Duplicate(b, now.Add(time.Minute)))
+
+	d = dtap.NewDeduplicator(&dtap.DedupConfig{Enable: true, Key: "message"})
+	dt := newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp.")
+	frame1, err := proto.Marshal(dt)
+	assert.NoError(t, err)
+	dt.Identity = []byte("collector2")
+	frame2, err := proto.Marshal(dt)
+	assert.NoError(t, err)
+	assert.False(t, d.Duplicate(frame1, now))
+	assert.True(t, d.Duplicate(frame2, now))
+}
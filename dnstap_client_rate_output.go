@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var ClientRateUntracked = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "dtap_client_rate_untracked_total",
+	Help: "The total number of queries not tracked because of MaxClients",
+}, []string{"name"})
+
+type DnstapClientRateOutput struct {
+	config          *OutputClientRateConfig
+	flatOption      DnstapFlatOption
+	gauge           prometheus.Gauge
+	tracker         *ClientRateTracker
+	mux             sync.Mutex
+	events          *eventWriter
+	flushCancelFunc context.CancelFunc
+}
+
+func NewDnstapClientRateOutput(config *OutputClientRateConfig, params *DnstapOutputParams) *DnstapOutput {
+	params.Handler = &DnstapClientRateOutput{
+		config:     config,
+		flatOption: &config.Flat,
+		gauge: registerCollector(prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: config.GetName(),
+			Help: config.GetHelp(),
+		})).(prometheus.Gauge),
+		tracker: NewClientRateTracker(time.Duration(config.GetWindow())*time.Second, config.GetThresholds(), int(config.GetMaxClients())),
+	}
+	return NewDnstapOutput(params)
+}
+
+func (o *DnstapClientRateOutput) open() error {
+	var err error
+	o.events, err = newEventWriter(o.config.GetPath(), o.config.GetFluentHost(), o.config.GetFluentPort(), o.config.GetFluentTag())
+	if err != nil {
+		return err
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	o.flushCancelFunc = cancelFunc
+	go o.flush(ctx)
+	return nil
+}
+
+func (o *DnstapClientRateOutput) write(frame []byte) error {
+	dt := dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, &dt); err != nil {
+		log.Debug(err)
+		return nil
+	}
+	data, err := FlatDnstap(&dt, o.flatOption)
+	if err != nil {
+		log.Debug(err)
+		return nil
+	}
+	if !strings.HasSuffix(data.Type, "_QUERY") {
+		return nil
+	}
+	key := data.ToLabelMap()[o.config.GetKey()]
+	if key == "" {
+		return nil
+	}
+	now := time.Now()
+	o.mux.Lock()
+	ev, ok := o.tracker.Add(key, now)
+	overLimit := o.tracker.OverLimit()
+	o.mux.Unlock()
+	if !ok {
+		ClientRateUntracked.WithLabelValues(o.config.GetName()).Inc()
+	}
+	if ev != nil {
+		log.Warnf("client %s exceeds %g queries per second", ev.Client, ev.Threshold)
+		o.gauge.Set(float64(overLimit))
+		if err := o.events.Write(now, []interface{}{ev}); err != nil {
+			log.Warn(err)
+		}
+	}
+	return nil
+}
+
+func (o *DnstapClientRateOutput) flush(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return
+		case now := <-ticker.C:
+			o.mux.Lock()
+			events := o.tracker.Expire(now)
+			overLimit := o.tracker.OverLimit()
+			o.mux.Unlock()
+			o.gauge.Set(float64(overLimit))
+			records := make([]interface{}, 0, len(events))
+			for _, ev := range events {
+				records = append(records, ev)
+			}
+			if err := o.events.Write(now, records); err != nil {
+				log.Warn(err)
+			}
+		}
+	}
+}
+
+func (o *DnstapClientRateOutput) close() {
+	o.flushCancelFunc()
+	o.events.Close()
+}
@@ -32,6 +32,7 @@ type DnstapFluentdOutput struct {
 	enc         *framestream.Encoder
 	client      *fluent.Fluent
 	flatOption  DnstapFlatOption
+	sampler     *Sampler
 	tag         string
 }
 
@@ -39,6 +40,7 @@ func NewDnstapFluentdOutput(config *OutputFluentConfig, params *DnstapOutputPara
 	params.Handler = &DnstapFluentdOutput{
 		config:     config,
 		flatOption: &config.Flat,
+		sampler:    NewSampler(&config.Sampling, params.Name),
 		fluetConfig: fluent.Config{
 			FluentHost: config.GetHost(),
 			FluentPort: config.GetPort(),
@@ -64,11 +66,20 @@ func (o *DnstapFluentdOutput) write(frame []byte) error {
 	if err := proto.Unmarshal(frame, &dt); err != nil {
 		return err
 	}
+	rate, ok := o.sampler.Sample(&dt)
+	if !ok {
+		return nil
+	}
 	data, err := FlatDnstap(&dt, o.flatOption)
 	if err != nil {
 		return err
 	}
-	if err := o.client.Post(o.tag, *data); err != nil {
+	data.SampleRate = rate
+	var record interface{} = *data
+	if data.fields != nil || data.epochTime() {
+		record = data.ToMapString()
+	}
+	if err := o.client.Post(o.tag, record); err != nil {
 		return fmt.Errorf("failed to post fluent message, tag: %s %w", o.tag, err)
 	}
 	return nil
@@ -51,29 +51,38 @@ func (rc *DnstapFstrmFileReadCloser) Close() error {
 	return rc.file.Close()
 }
 
-func NewDnstapFstrmFileInput(config *InputFileConfig) (*DnstapFstrmFileInput, error) {
-	var r io.ReadCloser
-	f, err := os.Open(config.GetPath())
+// OpenCompressedFile opens the file and decompresses it by the gz, bz2 or xz suffix.
+func OpenCompressedFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to watci file, path: %s err: %w", config.GetPath(), err)
+		return nil, fmt.Errorf("failed to watci file, path: %s err: %w", path, err)
 	}
 
-	if strings.HasSuffix(config.GetPath(), "gz") {
-		r, err = gzip.NewReader(f)
+	if strings.HasSuffix(path, "gz") {
+		r, err := gzip.NewReader(f)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader, path: %s err: %w", config.GetPath(), err)
+			f.Close()
+			return nil, fmt.Errorf("failed to create gzip reader, path: %s err: %w", path, err)
 		}
-	} else if strings.HasSuffix(config.GetPath(), "bz2") {
+		return NewDnstapFstrmFileReadCloser(r, f), nil
+	} else if strings.HasSuffix(path, "bz2") {
 		cmp := bzip2.NewReader(f)
-		r = NewDnstapFstrmFileReadCloser(cmp, f)
-	} else if strings.HasSuffix(config.GetPath(), "xz") {
+		return NewDnstapFstrmFileReadCloser(cmp, f), nil
+	} else if strings.HasSuffix(path, "xz") {
 		cmp, err := xz.NewReader(f)
-		r = NewDnstapFstrmFileReadCloser(cmp, f)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create xz reader, path: %s err: %w", config.GetPath(), err)
+			f.Close()
+			return nil, fmt.Errorf("failed to create xz reader, path: %s err: %w", path, err)
 		}
-	} else {
-		r = f
+		return NewDnstapFstrmFileReadCloser(cmp, f), nil
+	}
+	return f, nil
+}
+
+func NewDnstapFstrmFileInput(config *InputFileConfig) (*DnstapFstrmFileInput, error) {
+	r, err := OpenCompressedFile(config.GetPath())
+	if err != nil {
+		return nil, err
 	}
 	input, err := NewDnstapFstrmInput(r, false)
 	if err != nil {
@@ -92,3 +101,13 @@ func (i *DnstapFstrmFileInput) Run(ctx context.Context, rbuf *RBuf) error {
 	err := i.input.Read(childCtx, rbuf)
 	return err
 }
+
+// Next returns the next frame of the file, io.EOF at the end of the file.
+// Unlike Run, no frames are lost.
+func (i *DnstapFstrmFileInput) Next() ([]byte, error) {
+	return i.input.Next()
+}
+
+func (i *DnstapFstrmFileInput) Close() error {
+	return i.input.Close()
+}
@@ -17,15 +17,18 @@
 package dtap
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	dnstap "github.com/dnstap/golang-dnstap"
 	framestream "github.com/farsightsec/golang-framestream"
 	strftime "github.com/jehiah/go-strftime"
 	log "github.com/sirupsen/logrus"
+	"github.com/ulikunitz/xz"
 )
 
 type DnstapFstrmFileOutput struct {
@@ -34,55 +37,74 @@ type DnstapFstrmFileOutput struct {
 	enc             *framestream.Encoder
 	writer          io.WriteCloser
 	opened          chan bool
+	sampler         *Sampler
 }
 
 func NewDnstapFstrmFileOutput(config *OutputFileConfig, params *DnstapOutputParams) *DnstapOutput {
 	params.Handler = &DnstapFstrmFileOutput{
-		config: config,
+		config:  config,
+		sampler: NewSampler(&config.Sampling, params.Name),
 	}
 	return NewDnstapOutput(params)
 }
 
 func (o *DnstapFstrmFileOutput) open() error {
+	filename, writer, enc, err := o.openFile()
+	if err != nil {
+		return err
+	}
+	o.start(filename, writer, enc)
+	return nil
+}
+
+// openFile opens the current file and its encoder without touching the opened ones.
+func (o *DnstapFstrmFileOutput) openFile() (string, io.WriteCloser, *framestream.Encoder, error) {
 	filename := strftime.Format(o.config.GetPath(), time.Now())
 	log.Debugf("open output file %s\n", filename)
 
 	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
 	if err != nil {
-		return fmt.Errorf("failed to create file %s err: %w", filename, err)
+		return "", nil, nil, fmt.Errorf("failed to create file %s err: %w", filename, err)
 	}
-	o.writer = f
-
-	o.enc, err = framestream.NewEncoder(o.writer, &framestream.EncoderOptions{ContentType: dnstap.FSContentType, Bidirectional: false})
+	enc, err := framestream.NewEncoder(f, &framestream.EncoderOptions{ContentType: dnstap.FSContentType, Bidirectional: false})
 	if err != nil {
-		return fmt.Errorf("failed to create framestream encorder %s err: %w", filename, err)
+		f.Close()
+		return "", nil, nil, fmt.Errorf("failed to create framestream encorder %s err: %w", filename, err)
 	}
-	o.currentFilename = filename
+	return filename, f, enc, nil
+}
+
+func (o *DnstapFstrmFileOutput) start(filename string, writer io.WriteCloser, enc *framestream.Encoder) {
+	o.currentFilename, o.writer, o.enc = filename, writer, enc
 	o.opened = make(chan bool)
+	// rotate replaces the fields while the old goroutine is running
+	opened, currentFilename := o.opened, o.currentFilename
 	go func() {
 		ticker := time.NewTicker(FlushTimeout)
 		for {
 			select {
-			case <-o.opened:
+			case <-opened:
 				return
 			case <-ticker.C:
-				if err := o.enc.Flush(); err != nil {
+				if err := enc.Flush(); err != nil {
 					return
 				}
 				filename := strftime.Format(o.config.GetPath(), time.Now())
-				if filename != o.currentFilename {
-					o.enc.Close()
-					o.writer.Close()
+				if filename != currentFilename {
+					enc.Close()
+					writer.Close()
 					ticker.Stop()
 					return
 				}
 			}
 		}
 	}()
-	return nil
 }
 
 func (o *DnstapFstrmFileOutput) write(frame []byte) error {
+	if !o.sampler.SampleFrame(frame) {
+		return nil
+	}
 	if _, err := o.enc.Write(frame); err != nil {
 		o.close()
 		return err
@@ -91,8 +113,102 @@ func (o *DnstapFstrmFileOutput) write(frame []byte) error {
 }
 
 func (o *DnstapFstrmFileOutput) close() {
+	if o.opened == nil {
+		return
+	}
 	o.enc.Flush()
 	o.enc.Close()
 	o.writer.Close()
 	close(o.opened)
+	o.opened = nil
+}
+
+func (o *DnstapFstrmFileOutput) flush() error {
+	return o.enc.Flush()
+}
+
+// rotate reopens the file, the moved file is closed such as logrotate.
+// The current file is kept if the new one can't be opened.
+func (o *DnstapFstrmFileOutput) rotate() error {
+	filename, writer, enc, err := o.openFile()
+	if err != nil {
+		return err
+	}
+	o.close()
+	o.start(filename, writer, enc)
+	return nil
+}
+
+type compressedWriteCloser struct {
+	io.WriteCloser
+	file *os.File
+}
+
+func (w *compressedWriteCloser) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// CreateCompressedFile creates the file compressed by the gz or xz suffix.
+func CreateCompressedFile(path string) (io.WriteCloser, error) {
+	if strings.HasSuffix(path, "bz2") {
+		return nil, fmt.Errorf("bzip2 output isn't supported, path: %s", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file %s err: %w", path, err)
+	}
+	if strings.HasSuffix(path, "gz") {
+		return &compressedWriteCloser{gzip.NewWriter(f), f}, nil
+	} else if strings.HasSuffix(path, "xz") {
+		w, err := xz.NewWriter(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create xz writer, path: %s err: %w", path, err)
+		}
+		return &compressedWriteCloser{w, f}, nil
+	}
+	return f, nil
+}
+
+// FstrmFileWriter writes frames to a plain or compressed fstrm file.
+type FstrmFileWriter struct {
+	writer io.WriteCloser
+	enc    *framestream.Encoder
+	size   int64
+}
+
+func NewFstrmFileWriter(path string) (*FstrmFileWriter, error) {
+	w, err := CreateCompressedFile(path)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := framestream.NewEncoder(w, &framestream.EncoderOptions{ContentType: dnstap.FSContentType, Bidirectional: false})
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to create framestream encorder %s err: %w", path, err)
+	}
+	return &FstrmFileWriter{writer: w, enc: enc}, nil
+}
+
+func (w *FstrmFileWriter) Write(frame []byte) error {
+	n, err := w.enc.Write(frame)
+	w.size += int64(n) + 4
+	return err
+}
+
+// Size returns the uncompressed size of the written frames.
+func (w *FstrmFileWriter) Size() int64 {
+	return w.size
+}
+
+func (w *FstrmFileWriter) Close() error {
+	if err := w.enc.Close(); err != nil {
+		w.writer.Close()
+		return err
+	}
+	return w.writer.Close()
 }
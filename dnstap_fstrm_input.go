@@ -63,6 +63,25 @@ func (i *DnstapFstrmInput) read(rbuf *RBuf) {
 		rbuf.Write(newbuf)
 	}
 }
+
+// Next returns the next frame, io.EOF at the end of the stream.
+func (i *DnstapFstrmInput) Next() ([]byte, error) {
+	buf, err := i.decoder.Decode()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	newbuf := make([]byte, len(buf))
+	copy(newbuf, buf)
+	return newbuf, nil
+}
+
+func (i *DnstapFstrmInput) Close() error {
+	return i.rc.Close()
+}
+
 func (i *DnstapFstrmInput) Read(ctx context.Context, rbuf *RBuf) error {
 	var err error
 	go i.read(rbuf)
@@ -35,7 +35,7 @@ type DnstapFstrmSocketInput struct {
 func NewDnstapFstrmSocketInput(listener net.Listener) (*DnstapFstrmSocketInput, error) {
 	return &DnstapFstrmSocketInput{
 		listener:  listener,
-		readError: make(chan error),
+		readError: make(chan error, 1),
 	}, nil
 }
 
@@ -76,3 +76,8 @@ func (i *DnstapFstrmSocketInput) Run(ctx context.Context, rbuf *RBuf) error {
 	log.Info("finish input")
 	return err
 }
+
+// Close closes the listener of the input which is not run.
+func (i *DnstapFstrmSocketInput) Close() error {
+	return i.listener.Close()
+}
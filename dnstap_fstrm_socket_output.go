@@ -18,8 +18,10 @@ package dtap
 
 import (
 	"fmt"
+	"net"
 	"time"
 
+	dnstap "github.com/dnstap/golang-dnstap"
 	framestream "github.com/farsightsec/golang-framestream"
 )
 
@@ -27,11 +29,13 @@ type DnstapFstrmSocketOutput struct {
 	handler SocketOutput
 	enc     *framestream.Encoder
 	opened  chan bool
+	sampler *Sampler
 }
 
-func NewDnstapFstrmSocketOutput(handler SocketOutput, params *DnstapOutputParams) *DnstapOutput {
+func NewDnstapFstrmSocketOutput(handler SocketOutput, sampling *OutputSamplingConfig, params *DnstapOutputParams) *DnstapOutput {
 	params.Handler = &DnstapFstrmSocketOutput{
 		handler: handler,
+		sampler: NewSampler(sampling, params.Name),
 	}
 	return NewDnstapOutput(params)
 }
@@ -42,14 +46,15 @@ func (o *DnstapFstrmSocketOutput) open() error {
 		return fmt.Errorf("failed to connect socket: %w", err)
 	}
 	o.opened = make(chan bool)
+	opened, enc := o.opened, o.enc
 	go func() {
 		ticker := time.NewTicker(FlushTimeout)
 		for {
 			select {
-			case <-o.opened:
+			case <-opened:
 				return
 			case <-ticker.C:
-				if err := o.enc.Flush(); err != nil {
+				if err := enc.Flush(); err != nil {
 					return
 				}
 			}
@@ -59,6 +64,9 @@ func (o *DnstapFstrmSocketOutput) open() error {
 }
 
 func (o *DnstapFstrmSocketOutput) write(frame []byte) error {
+	if !o.sampler.SampleFrame(frame) {
+		return nil
+	}
 	if _, err := o.enc.Write(frame); err != nil {
 		o.close()
 		return err
@@ -67,7 +75,30 @@ func (o *DnstapFstrmSocketOutput) write(frame []byte) error {
 }
 
 func (o *DnstapFstrmSocketOutput) close() {
+	if o.opened == nil {
+		return
+	}
 	o.enc.Flush()
 	o.enc.Close()
 	close(o.opened)
+	o.opened = nil
+}
+
+func (o *DnstapFstrmSocketOutput) flush() error {
+	return o.enc.Flush()
+}
+
+// DialFstrm connects to the fstrm receiver and returns the bidirectional encoder.
+// network is tcp or unix.
+func DialFstrm(network, address string) (*framestream.Encoder, error) {
+	w, err := net.Dial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect %s socket, address: %s err: %w", network, address, err)
+	}
+	enc, err := framestream.NewEncoder(w, &framestream.EncoderOptions{ContentType: dnstap.FSContentType, Bidirectional: true})
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to create fstrm encorder, address: %s err: %w", address, err)
+	}
+	return enc, nil
 }
@@ -17,10 +17,6 @@
 package dtap
 
 import (
-	"fmt"
-	"net"
-
-	dnstap "github.com/dnstap/golang-dnstap"
 	framestream "github.com/farsightsec/golang-framestream"
 )
 
@@ -30,18 +26,9 @@ type DnstapFstrmTCPSocketOutput struct {
 
 func NewDnstapFstrmTCPSocketOutput(config *OutputTCPSocketConfig, params *DnstapOutputParams) *DnstapOutput {
 	tcp := &DnstapFstrmTCPSocketOutput{config: config}
-	return NewDnstapFstrmSocketOutput(tcp, params)
+	return NewDnstapFstrmSocketOutput(tcp, &config.Sampling, params)
 }
 
 func (o *DnstapFstrmTCPSocketOutput) newConnect() (*framestream.Encoder, error) {
-	w, err := net.Dial("tcp", o.config.GetAddress())
-	if err != nil {
-
-		return nil, fmt.Errorf("failed to connect tcp socket, address: %s err: %w", o.config.GetAddress(), err)
-	}
-	enc, err := framestream.NewEncoder(w, &framestream.EncoderOptions{ContentType: dnstap.FSContentType, Bidirectional: true})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create fstrm encorder, address: %s err: %w", o.config.GetAddress(), err)
-	}
-	return enc, nil
+	return DialFstrm("tcp", o.config.GetAddress())
 }
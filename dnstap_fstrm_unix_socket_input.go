@@ -25,27 +25,40 @@ import (
 )
 
 func NewDnstapFstrmUnixSocketInput(config *InputUnixSocketConfig) (*DnstapFstrmSocketInput, error) {
-	os.Remove(config.GetPath())
-	l, err := net.Listen("unix", config.GetPath())
+	l, err := listenUnix(config.GetPath(), config.GetUser())
 	if err != nil {
-		return nil, fmt.Errorf("failed to listen %s: %w", config.GetPath(), err)
+		return nil, err
 	}
-	if config.GetUser() != "" {
-		if u, err := user.Lookup(config.GetUser()); err != nil {
-			return nil, fmt.Errorf("failed to get chown user %s: %w", config.GetUser(), err)
+	return NewDnstapFstrmSocketInput(l)
+}
+
+// listenUnix listens the unix socket path owned by username.
+func listenUnix(path, username string) (net.Listener, error) {
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen %s: %w", path, err)
+	}
+	if username != "" {
+		if u, err := user.Lookup(username); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to get chown user %s: %w", username, err)
 		} else {
 			uid, err := strconv.Atoi(u.Uid)
 			if err != nil {
+				l.Close()
 				return nil, fmt.Errorf("failed to get uid: %w", err)
 			}
 			gid, err := strconv.Atoi(u.Gid)
 			if err != nil {
+				l.Close()
 				return nil, fmt.Errorf("failed to get gid: %w", err)
 			}
-			if err := os.Chown(config.GetPath(), uid, gid); err != nil {
-				return nil, fmt.Errorf("failed to change owner %s (%s:%s): %w", config.GetUser(), u.Uid, u.Gid, err)
+			if err := os.Chown(path, uid, gid); err != nil {
+				l.Close()
+				return nil, fmt.Errorf("failed to change owner %s (%s:%s): %w", username, u.Uid, u.Gid, err)
 			}
 		}
 	}
-	return NewDnstapFstrmSocketInput(l)
+	return l, nil
 }
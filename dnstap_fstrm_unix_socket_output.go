@@ -17,10 +17,6 @@
 package dtap
 
 import (
-	"fmt"
-	"net"
-
-	dnstap "github.com/dnstap/golang-dnstap"
 	framestream "github.com/farsightsec/golang-framestream"
 )
 
@@ -32,17 +28,9 @@ func NewDnstapFstrmUnixSockOutput(config *OutputUnixSocketConfig, params *Dnstap
 	unix := &DnstapFstrmUnixSockOutput{
 		config: config,
 	}
-	return NewDnstapFstrmSocketOutput(unix, params)
+	return NewDnstapFstrmSocketOutput(unix, &config.Sampling, params)
 }
 
 func (o *DnstapFstrmUnixSockOutput) newConnect() (*framestream.Encoder, error) {
-	w, err := net.Dial("unix", o.config.GetPath())
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect unix socket, path: %s: %w", o.config.GetPath(), err)
-	}
-	enc, err := framestream.NewEncoder(w, &framestream.EncoderOptions{ContentType: dnstap.FSContentType, Bidirectional: true})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create fstrm encorder, path: %s: %w", o.config.GetPath(), err)
-	}
-	return enc, nil
+	return DialFstrm("unix", o.config.GetPath())
 }
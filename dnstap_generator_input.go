@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"context"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+)
+
+// generatorTick is the interval of writing generated messages.
+const generatorTick = 10 * time.Millisecond
+
+type DnstapGeneratorInput struct {
+	config    *InputGeneratorConfig
+	generator *Generator
+}
+
+func NewDnstapGeneratorInput(config *InputGeneratorConfig) (*DnstapGeneratorInput, error) {
+	g, err := NewGenerator(config)
+	if err != nil {
+		return nil, err
+	}
+	return &DnstapGeneratorInput{
+		config:    config,
+		generator: g,
+	}, nil
+}
+
+func (i *DnstapGeneratorInput) Run(ctx context.Context, rbuf *RBuf) error {
+	return i.Generate(ctx, func(frame []byte) error {
+		rbuf.Write(frame)
+		return nil
+	})
+}
+
+// Generate passes frames of queries to write at Rate per second with the current time
+// until Count queries or ctx is done.
+func (i *DnstapGeneratorInput) Generate(ctx context.Context, write func([]byte) error) error {
+	ticker := time.NewTicker(generatorTick)
+	defer ticker.Stop()
+	rate := float64(i.config.GetRate())
+	count := i.config.GetCount()
+	start := time.Now()
+	var sent uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			target := uint64(now.Sub(start).Seconds() * rate)
+			if count > 0 && target > count {
+				target = count
+			}
+			for ; sent < target; sent++ {
+				t := start.Add(time.Duration(float64(sent) / rate * float64(time.Second)))
+				if err := i.write(i.generator.Next(t), write); err != nil {
+					return err
+				}
+			}
+			if count > 0 && sent >= count {
+				return i.write(i.generator.Flush(), write)
+			}
+		}
+	}
+}
+
+func (i *DnstapGeneratorInput) write(dts []*dnstap.Dnstap, write func([]byte) error) error {
+	for _, dt := range dts {
+		buf, err := proto.Marshal(dt)
+		if err != nil {
+			log.Debugf("failed to marshal generated message: %v", err)
+			continue
+		}
+		if err := write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
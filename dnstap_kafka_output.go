@@ -41,6 +41,48 @@ func init() {
 	schemaStr = string(b)
 }
 
+// NewFlatAvroCodec returns the codec of flattened messages by assets/flat.avsc.
+func NewFlatAvroCodec() (*goavro.Codec, error) {
+	return goavro.NewCodec(schemaStr)
+}
+
+// parquetTypes are the Parquet types of the Avro primitive types.
+var parquetTypes = map[string]string{
+	"string":  "type=BYTE_ARRAY, convertedtype=UTF8",
+	"int":     "type=INT32",
+	"long":    "type=INT64",
+	"double":  "type=DOUBLE",
+	"boolean": "type=BOOLEAN",
+}
+
+// FlatParquetSchema returns the Parquet JSON schema of xitongsys/parquet-go
+// with the fields of assets/flat.avsc, all fields are optional.
+func FlatParquetSchema() (string, error) {
+	avsc := struct {
+		Fields []struct {
+			Name string
+			Type string
+		}
+	}{}
+	if err := json.Unmarshal([]byte(schemaStr), &avsc); err != nil {
+		return "", fmt.Errorf("failed to parse avro schema: %w", err)
+	}
+	type parquetField struct {
+		Tag    string
+		Fields []parquetField `json:",omitempty"`
+	}
+	root := parquetField{Tag: "name=parquet_go_root, repetitiontype=REQUIRED"}
+	for _, f := range avsc.Fields {
+		typ, ok := parquetTypes[f.Type]
+		if !ok {
+			return "", fmt.Errorf("unsupported avro type of %s: %s", f.Name, f.Type)
+		}
+		root.Fields = append(root.Fields, parquetField{Tag: fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", f.Name, typ)})
+	}
+	b, err := json.Marshal(root)
+	return string(b), err
+}
+
 type KafkaClient interface {
 	Add(string, string, []byte, []byte) error
 }
@@ -54,6 +96,7 @@ type DnstapKafkaOutput struct {
 	valueSchemaID []byte
 	keyCodec      *goavro.Codec
 	keySchemaID   []byte
+	sampler       *Sampler
 }
 
 func NewDnstapKafkaOutput(config *OutputKafkaConfig, params *DnstapOutputParams) (*DnstapOutput, error) {
@@ -67,7 +110,7 @@ func NewDnstapKafkaOutput(config *OutputKafkaConfig, params *DnstapOutputParams)
 		return nil, err
 	}
 
-	valueCodec, err := goavro.NewCodec(schemaStr)
+	valueCodec, err := NewFlatAvroCodec()
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +120,7 @@ func NewDnstapKafkaOutput(config *OutputKafkaConfig, params *DnstapOutputParams)
 		kafkaConfig: kafkaConfig,
 		keyCodec:    keyCodec,
 		valueCodec:  valueCodec,
+		sampler:     NewSampler(&config.Sampling, params.Name),
 	}
 	return NewDnstapOutput(params), nil
 }
@@ -126,18 +170,25 @@ func (o *DnstapKafkaOutput) GetEncoder(v interface{}, codec *goavro.Codec, schem
 
 func (o *DnstapKafkaOutput) write(frame []byte) error {
 	var v, k sarama.Encoder
+	dt := dnstap.Dnstap{}
+	if o.config.GetOutputType() != "protobuf" || o.sampler != nil {
+		if err := proto.Unmarshal(frame, &dt); err != nil {
+			return err
+		}
+	}
+	rate, ok := o.sampler.Sample(&dt)
+	if !ok {
+		return nil
+	}
 	if o.config.GetOutputType() == "protobuf" {
 		k = sarama.ByteEncoder(o.config.GetKey())
 		v = sarama.ByteEncoder(frame)
 	} else {
-		dt := dnstap.Dnstap{}
-		if err := proto.Unmarshal(frame, &dt); err != nil {
-			return err
-		}
 		data, err := FlatDnstap(&dt, &o.config.Flat)
 		if err != nil {
 			return err
 		}
+		data.SampleRate = rate
 		if o.config.GetOutputType() == "avro" {
 			var err error
 			mapString := data.ToMapString()
@@ -38,6 +38,7 @@ type DnstapNatsOutput struct {
 	dataString      []byte
 	data            []*DnstapFlatT
 	flatOption      DnstapFlatOption
+	sampler         *Sampler
 	flushCancelFunc context.CancelFunc
 	flushErr        error
 	closeCh         chan struct{}
@@ -47,6 +48,7 @@ func NewDnstapNatsOutput(config *OutputNatsConfig, params *DnstapOutputParams) *
 	params.Handler = &DnstapNatsOutput{
 		config:     config,
 		flatOption: &config.Flat,
+		sampler:    NewSampler(&config.Sampling, params.Name),
 		data:       []*DnstapFlatT{},
 		mux:        new(sync.Mutex),
 	}
@@ -81,10 +83,15 @@ func (o *DnstapNatsOutput) write(frame []byte) error {
 	if err := proto.Unmarshal(frame, &dt); err != nil {
 		return err
 	}
+	rate, ok := o.sampler.Sample(&dt)
+	if !ok {
+		return nil
+	}
 	data, err := FlatDnstap(&dt, o.flatOption)
 	if err != nil {
 		return err
 	}
+	data.SampleRate = rate
 	o.mux.Lock()
 	o.data = append(o.data, data)
 	o.mux.Unlock()
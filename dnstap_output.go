@@ -18,27 +18,197 @@ package dtap
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
 type DnstapOutputParams struct {
+	// Name is the name in the status such as OutputFile[0].
+	Name        string
 	BufferSize  uint
 	InCounter   prometheus.Counter
 	LostCounter prometheus.Counter
 	Handler     OutputHandler
 }
 
+var (
+	ErrOutputNotConnected = errors.New("output is not connected")
+	ErrOutputNotSupported = errors.New("operation is not supported by the output")
+)
+
+// outputControlTimeout is the time to wait for the writer to accept a control.
+const outputControlTimeout = 5 * time.Second
+
+// OutputStatus is the runtime status of an output.
+type OutputStatus struct {
+	Name          string     `json:"name"`
+	Connected     bool       `json:"connected"`
+	Paused        bool       `json:"paused"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	Reconnects    uint64     `json:"reconnects"`
+	Frames        uint64     `json:"frames"`
+	LastFrameTime *time.Time `json:"last_frame_time,omitempty"`
+	BufferLen     int        `json:"buffer_len"`
+	BufferCap     int        `json:"buffer_cap"`
+}
+
+type outputControl struct {
+	op    func() error
+	reply chan error
+}
+
 type DnstapOutput struct {
-	handler OutputHandler
-	rbuf    *RBuf
+	name     string
+	handler  OutputHandler
+	rbuf     *RBuf
+	controls chan *outputControl
+	wake     chan struct{}
+
+	mux           sync.Mutex
+	opened        bool
+	connected     bool
+	paused        bool
+	lastError     error
+	lastErrorTime time.Time
+	reconnects    uint64
+	frames        uint64
+	lastFrameTime time.Time
 }
 
 func NewDnstapOutput(params *DnstapOutputParams) *DnstapOutput {
 	return &DnstapOutput{
-		handler: params.Handler,
-		rbuf:    NewRbuf(params.BufferSize, params.InCounter, params.LostCounter),
+		name:     params.Name,
+		handler:  params.Handler,
+		rbuf:     NewRbuf(params.BufferSize, params.InCounter, params.LostCounter),
+		controls: make(chan *outputControl),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (o *DnstapOutput) Name() string {
+	return o.name
+}
+
+func (o *DnstapOutput) Status() OutputStatus {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	s := OutputStatus{
+		Name:       o.name,
+		Connected:  o.connected,
+		Paused:     o.paused,
+		Reconnects: o.reconnects,
+		Frames:     o.frames,
+		BufferLen:  o.rbuf.Len(),
+		BufferCap:  o.rbuf.Cap(),
+	}
+	if o.lastError != nil {
+		t := o.lastErrorTime
+		s.LastError, s.LastErrorTime = o.lastError.Error(), &t
+	}
+	if !o.lastFrameTime.IsZero() {
+		t := o.lastFrameTime
+		s.LastFrameTime = &t
+	}
+	return s
+}
+
+// setError logs the error as a warning when it differs from the last error,
+// the same errors of the retries are debug logs.
+func (o *DnstapOutput) setError(err error) {
+	o.mux.Lock()
+	changed := o.connected || o.lastError == nil || o.lastError.Error() != err.Error()
+	o.connected = false
+	o.lastError, o.lastErrorTime = err, time.Now()
+	o.mux.Unlock()
+	if changed {
+		log.Warnf("output %s error: %v", o.name, err)
+	} else {
+		log.Debug(err)
+	}
+}
+
+func (o *DnstapOutput) setConnected() {
+	o.mux.Lock()
+	reconnected := o.lastError != nil
+	if o.opened {
+		o.reconnects++
+	}
+	o.opened, o.connected = true, true
+	o.mux.Unlock()
+	if reconnected {
+		log.Infof("output %s connected", o.name)
+	}
+}
+
+// Pause stops writing frames, frames are lost when the buffer is full.
+func (o *DnstapOutput) Pause() {
+	o.setPaused(true)
+}
+
+func (o *DnstapOutput) Resume() {
+	o.setPaused(false)
+}
+
+func (o *DnstapOutput) setPaused(paused bool) {
+	o.mux.Lock()
+	o.paused = paused
+	o.mux.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *DnstapOutput) isPaused() bool {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return o.paused
+}
+
+// Flush writes the buffered data of the handler such as a fstrm encoder.
+func (o *DnstapOutput) Flush() error {
+	f, ok := o.handler.(outputFlusher)
+	if !ok {
+		return ErrOutputNotSupported
+	}
+	return o.control(f.flush)
+}
+
+// Rotate reopens the output file.
+func (o *DnstapOutput) Rotate() error {
+	r, ok := o.handler.(outputRotater)
+	if !ok {
+		return ErrOutputNotSupported
+	}
+	return o.control(r.rotate)
+}
+
+// control runs op in the writer goroutine, which is the only user of the handler.
+func (o *DnstapOutput) control(op func() error) error {
+	o.mux.Lock()
+	connected := o.connected
+	o.mux.Unlock()
+	if !connected {
+		return ErrOutputNotConnected
+	}
+	c := &outputControl{op: op, reply: make(chan error, 1)}
+	select {
+	case o.controls <- c:
+	case <-time.After(outputControlTimeout):
+		return ErrOutputNotConnected
+	}
+	return <-c.reply
+}
+
+// Release frees the resources of the output which is not run.
+func (o *DnstapOutput) Release() {
+	if r, ok := o.handler.(outputReleaser); ok {
+		r.release()
 	}
 }
 
@@ -52,18 +222,22 @@ L:
 			break L
 		default:
 			if err := o.handler.open(); err != nil {
-				log.Debug(err)
+				o.setError(err)
 				continue
 			}
 			log.Debug("success open")
+			o.setConnected()
 			childCtx, _ := context.WithCancel(ctx)
 			err := o.run(childCtx)
 			log.Debug("close handle close")
 			o.handler.close()
 
 			if err != nil {
-				log.Debug(err)
+				o.setError(err)
 			} else {
+				o.mux.Lock()
+				o.connected = false
+				o.mux.Unlock()
 				break L
 			}
 		}
@@ -74,15 +248,21 @@ func (o *DnstapOutput) run(ctx context.Context) error {
 	log.Debug("start writer")
 L:
 	for {
+		// a nil channel stops reading frames while paused
+		var frames <-chan []byte
+		if !o.isPaused() {
+			frames = o.rbuf.Read()
+		}
 		select {
 		case <-ctx.Done():
 			break L
-		case frame := <-o.rbuf.Read():
-			if frame != nil {
-				if err := o.handler.write(frame); err != nil {
-					log.Debugf("writer error: %v", err)
-					return err
-				}
+		case <-o.wake:
+		case c := <-o.controls:
+			c.reply <- c.op()
+		case frame := <-frames:
+			if err := o.write(frame); err != nil {
+				log.Debugf("writer error: %v", err)
+				return err
 			}
 		}
 	}
@@ -90,6 +270,41 @@ L:
 	return nil
 }
 
+func (o *DnstapOutput) write(frame []byte) error {
+	if frame == nil {
+		return nil
+	}
+	if err := o.handler.write(frame); err != nil {
+		return err
+	}
+	o.mux.Lock()
+	o.frames++
+	o.lastFrameTime = time.Now()
+	o.mux.Unlock()
+	return nil
+}
+
+// Drain writes the frames in the buffer before the output is stopped.
+// Frames of paused outputs aren't written.
+func (o *DnstapOutput) Drain() error {
+	if o.isPaused() {
+		return nil
+	}
+	return o.control(func() error {
+		for n := o.rbuf.Len(); n > 0; n-- {
+			select {
+			case frame := <-o.rbuf.Read():
+				if err := o.write(frame); err != nil {
+					return err
+				}
+			default:
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
 func (o *DnstapOutput) SetMessage(b []byte) {
 	o.rbuf.Write(b)
 }
@@ -17,14 +17,15 @@
 package dtap
 
 import (
+	"container/heap"
+	"container/list"
 	"context"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
-	"fmt"
-	"reflect"
 	"strconv"
 
 	dnstap "github.com/dnstap/golang-dnstap"
@@ -33,19 +34,38 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+var PrometheusDroppedSeries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "dtap_prometheus_dropped_series_total",
+	Help: "The total number of label sets folded into the overflow series or evicted by Limit.",
+}, []string{"name"})
+
 type DnstapPrometheusOutput struct {
 	config  *OutputPrometheus
 	Metrics []*DnstapPrometheusOutputMetrics
+	sampler *Sampler
 }
 
 type DnstapPrometheusOutputMetrics struct {
 	Name        string
-	Vec         *prometheus.CounterVec
+	Type        string
+	Value       string
+	Vec         prometheusVec
 	LabelKeys   []string
 	LabelValues map[string]*DnstapPrometheusOutputMetricsValues
 	Interval    int
 	Expire      int
+	Limit       int
+	LimitPolicy string
+	Overflow    []string
 	CancelFunc  context.CancelFunc
+	dropped     map[string]time.Time
+	mux         sync.Mutex
+	lru         *list.List
+	lfu         lfuHeap
+}
+
+type prometheusVec interface {
+	DeleteLabelValues(...string) bool
 }
 
 func (d *DnstapPrometheusOutputMetrics) GetInterval() int {
@@ -65,28 +85,146 @@ func (d *DnstapPrometheusOutputMetrics) GetExpire() int {
 type DnstapPrometheusOutputMetricsValues struct {
 	Values     []string
 	LastUpdate time.Time
+	Count      uint64
+	key        string
+	element    *list.Element
+	index      int
 }
 
 func NewDnstapPrometheusOutputMetrics(counterConfig OutputPrometheusMetrics) *DnstapPrometheusOutputMetrics {
-	return &DnstapPrometheusOutputMetrics{
-		Name: counterConfig.GetName(),
-		Vec: promauto.NewCounterVec(prometheus.CounterOpts{
+	var vec prometheusVec
+	switch counterConfig.GetType() {
+	case "histogram":
+		vec = registerCollector(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    counterConfig.GetName(),
+			Help:    counterConfig.GetHelp(),
+			Buckets: counterConfig.GetBuckets(),
+		}, counterConfig.GetLabels())).(*prometheus.HistogramVec)
+	case "summary":
+		vec = registerCollector(prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Name:       counterConfig.GetName(),
+			Help:       counterConfig.GetHelp(),
+			Objectives: counterConfig.GetObjectives(),
+		}, counterConfig.GetLabels())).(*prometheus.SummaryVec)
+	default:
+		vec = registerCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: counterConfig.GetName(),
 			Help: counterConfig.GetHelp(),
-		}, counterConfig.GetLabels()),
+		}, counterConfig.GetLabels())).(*prometheus.CounterVec)
+	}
+	overflow := make([]string, len(counterConfig.GetLabels()))
+	for i := range overflow {
+		overflow[i] = counterConfig.GetOverflowLabel()
+	}
+	return &DnstapPrometheusOutputMetrics{
+		Name:        counterConfig.GetName(),
+		Type:        counterConfig.GetType(),
+		Value:       counterConfig.GetValue(),
+		Vec:         vec,
 		LabelKeys:   counterConfig.GetLabels(),
 		LabelValues: map[string]*DnstapPrometheusOutputMetricsValues{},
 		Expire:      counterConfig.GetExpireSec(),
 		Interval:    counterConfig.GetExpireInterval(),
+		Limit:       counterConfig.GetLimit(),
+		LimitPolicy: counterConfig.GetLimitPolicy(),
+		Overflow:    overflow,
+		lru:         list.New(),
+		lfu:         lfuHeap{},
+		dropped:     map[string]time.Time{},
 	}
 }
 
 func (d *DnstapPrometheusOutputMetrics) Inc(values []string) {
-	d.Vec.WithLabelValues(values...).Inc()
-	d.LabelValues[strings.Join(values, ",")] = &DnstapPrometheusOutputMetricsValues{
+	d.Observe(values, 1)
+}
+
+func (d *DnstapPrometheusOutputMetrics) Observe(values []string, v float64) {
+	d.mux.Lock()
+	values = d.admit(values)
+	switch vec := d.Vec.(type) {
+	case *prometheus.CounterVec:
+		vec.WithLabelValues(values...).Add(v)
+	case prometheus.ObserverVec:
+		vec.WithLabelValues(values...).Observe(v)
+	}
+	d.mux.Unlock()
+}
+
+// admit returns the label values to update.
+// When the number of label sets reaches Limit, the new label set is folded
+// into the overflow series, or the least recently (lru) or least frequently (lfu)
+// updated label set is evicted.
+func (d *DnstapPrometheusOutputMetrics) admit(values []string) []string {
+	key := strings.Join(values, ",")
+	if lv, ok := d.LabelValues[key]; ok {
+		lv.LastUpdate = time.Now()
+		lv.Count++
+		d.lru.MoveToFront(lv.element)
+		heap.Fix(&d.lfu, lv.index)
+		return values
+	}
+	if d.Limit > 0 && len(d.LabelValues) >= d.Limit {
+		switch d.LimitPolicy {
+		case "lru":
+			d.delete(d.lru.Back().Value.(*DnstapPrometheusOutputMetricsValues))
+		case "lfu":
+			d.delete(d.lfu[0])
+		default:
+			d.fold(key)
+			return d.Overflow
+		}
+		PrometheusDroppedSeries.WithLabelValues(d.Name).Inc()
+	}
+	lv := &DnstapPrometheusOutputMetricsValues{
 		Values:     values,
 		LastUpdate: time.Now(),
+		Count:      1,
+		key:        key,
+	}
+	lv.element = d.lru.PushFront(lv)
+	heap.Push(&d.lfu, lv)
+	d.LabelValues[key] = lv
+	return values
+}
+
+// fold counts the label set folded into the overflow series once.
+// Up to Limit folded label sets are remembered until they expire,
+// a label set is counted again after it is forgotten.
+func (d *DnstapPrometheusOutputMetrics) fold(key string) {
+	if _, ok := d.dropped[key]; !ok {
+		if len(d.dropped) >= d.Limit {
+			d.dropped = map[string]time.Time{}
+		}
+		PrometheusDroppedSeries.WithLabelValues(d.Name).Inc()
+	}
+	d.dropped[key] = time.Now()
+}
+
+func (d *DnstapPrometheusOutputMetrics) delete(lv *DnstapPrometheusOutputMetricsValues) {
+	d.Vec.DeleteLabelValues(lv.Values...)
+	d.lru.Remove(lv.element)
+	heap.Remove(&d.lfu, lv.index)
+	delete(d.LabelValues, lv.key)
+}
+
+// value returns the observed value of the message.
+func (d *DnstapPrometheusOutputMetrics) value(data *DnstapFlatT, m map[string]string) (float64, bool) {
+	switch d.Value {
+	case "":
+		return 1, true
+	case "Latency":
+		latency, ok := data.Latency()
+		return latency.Seconds(), ok
+	}
+	v, ok := m[d.Value]
+	if !ok {
+		return 0, false
 	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
 }
 
 func (d *DnstapPrometheusOutputMetrics) Flush(ctx context.Context) {
@@ -96,12 +234,18 @@ func (d *DnstapPrometheusOutputMetrics) Flush(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			for k, value := range d.LabelValues {
+			d.mux.Lock()
+			for _, value := range d.LabelValues {
 				if time.Now().Sub(value.LastUpdate) > time.Second*time.Duration(d.Expire) {
-					d.Vec.DeleteLabelValues(value.Values...)
-					delete(d.LabelValues, k)
+					d.delete(value)
 				}
 			}
+			for key, lastUpdate := range d.dropped {
+				if time.Now().Sub(lastUpdate) > time.Second*time.Duration(d.Expire) {
+					delete(d.dropped, key)
+				}
+			}
+			d.mux.Unlock()
 		}
 	}
 }
@@ -110,6 +254,7 @@ func NewDnstapPrometheusOutput(config *OutputPrometheus, params *DnstapOutputPar
 	p := &DnstapPrometheusOutput{
 		config:  config,
 		Metrics: []*DnstapPrometheusOutputMetrics{},
+		sampler: NewSampler(&config.Sampling, params.Name),
 	}
 	for _, counterConfig := range config.GetCounters() {
 		p.Metrics = append(p.Metrics, NewDnstapPrometheusOutputMetrics(counterConfig))
@@ -134,35 +279,20 @@ func (o *DnstapPrometheusOutput) write(frame []byte) error {
 	if err := proto.Unmarshal(frame, &dt); err != nil {
 		return err
 	}
+	rate, ok := o.sampler.Sample(&dt)
+	if !ok {
+		return nil
+	}
+	// a sampled frame is counted as 1/rate frames
+	weight := 1.0
+	if rate > 0 {
+		weight = 1 / rate
+	}
 	data, err := FlatDnstap(&dt, &o.config.Flat)
 	if err != nil {
 		return err
 	}
-	e := reflect.ValueOf(data).Elem()
-	m := make(map[string]string)
-	for i := 0; i < e.NumField(); i++ {
-		field := e.Type().Field(i).Name
-		value := e.Field(i).Interface()
-		if value == nil {
-			continue
-		}
-		switch v := value.(type) {
-		case string:
-			m[field] = v
-		case uint32:
-			m[field] = strconv.Itoa(int(v))
-		case uint16:
-			m[field] = strconv.Itoa(int(v))
-		case bool:
-			if v {
-				m[field] = "1"
-			} else {
-				m[field] = "0"
-			}
-		case fmt.Stringer:
-			m[field] = v.String()
-		}
-	}
+	m := data.ToLabelMap()
 
 	for _, counter := range o.Metrics {
 		labelValues := make([]string, 0, len(counter.LabelKeys))
@@ -173,7 +303,9 @@ func (o *DnstapPrometheusOutput) write(frame []byte) error {
 				log.Warnf("can't get metrics: %v, %v", l, counter.Name)
 			}
 		}
-		counter.Inc(labelValues)
+		if v, ok := counter.value(data, m); ok {
+			counter.Observe(labelValues, v*weight)
+		}
 	}
 	return nil
 }
@@ -185,3 +317,31 @@ func (o *DnstapPrometheusOutput) close() {
 		}
 	}
 }
+
+type lfuHeap []*DnstapPrometheusOutputMetricsValues
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].Count == h[j].Count {
+		return h[i].LastUpdate.Before(h[j].LastUpdate)
+	}
+	return h[i].Count < h[j].Count
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	lv := x.(*DnstapPrometheusOutputMetricsValues)
+	lv.index = len(*h)
+	*h = append(*h, lv)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	lv := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return lv
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"context"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestDnstapPrometheusOutputMetricsLimit(t *testing.T) {
+	overflow := dtap.NewDnstapPrometheusOutputMetrics(dtap.OutputPrometheusMetrics{
+		Name:   "test_limit_overflow_total",
+		Labels: []string{"Qname"},
+		Limit:  2,
+	})
+	overflow.Inc([]string{"a."})
+	overflow.Inc([]string{"b."})
+	overflow.Inc([]string{"c."})
+	overflow.Inc([]string{"c."})
+	overflow.Inc([]string{"a."})
+	vec := overflow.Vec.(*prometheus.CounterVec)
+	assert.Len(t, overflow.LabelValues, 2)
+	assert.Equal(t, 2.0, testutil.ToFloat64(vec.WithLabelValues("a.")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(vec.WithLabelValues("__other__")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(dtap.PrometheusDroppedSeries.WithLabelValues("test_limit_overflow_total")))
+
+	lru := dtap.NewDnstapPrometheusOutputMetrics(dtap.OutputPrometheusMetrics{
+		Name:        "test_limit_lru_total",
+		Labels:      []string{"Qname"},
+		Limit:       2,
+		LimitPolicy: "lru",
+	})
+	lru.Inc([]string{"a."})
+	lru.Inc([]string{"a."})
+	lru.Inc([]string{"b."})
+	lru.Inc([]string{"a."})
+	lru.Inc([]string{"c."})
+	assert.Len(t, lru.LabelValues, 2)
+	assert.Contains(t, lru.LabelValues, "a.")
+	assert.Contains(t, lru.LabelValues, "c.")
+
+	lfu := dtap.NewDnstapPrometheusOutputMetrics(dtap.OutputPrometheusMetrics{
+		Name:        "test_limit_lfu_total",
+		Labels:      []string{"Qname"},
+		Limit:       2,
+		LimitPolicy: "lfu",
+	})
+	lfu.Inc([]string{"a."})
+	lfu.Inc([]string{"b."})
+	lfu.Inc([]string{"b."})
+	lfu.Inc([]string{"c."})
+	assert.Len(t, lfu.LabelValues, 2)
+	assert.Contains(t, lfu.LabelValues, "b.")
+	assert.Contains(t, lfu.LabelValues, "c.")
+	assert.Equal(t, 1.0, testutil.ToFloat64(dtap.PrometheusDroppedSeries.WithLabelValues("test_limit_lfu_total")))
+}
+
+func TestDnstapPrometheusOutputLatency(t *testing.T) {
+	config := &dtap.OutputPrometheus{
+		Counters: []dtap.OutputPrometheusMetrics{
+			{
+				Name:    "test_latency_histogram_seconds",
+				Type:    "histogram",
+				Value:   "Latency",
+				Buckets: []float64{0.01, 0.05, 0.1},
+				Labels:  []string{"Qtype"},
+			},
+			{
+				Name:   "test_latency_summary_seconds",
+				Type:   "summary",
+				Value:  "Latency",
+				Labels: []string{"Qtype"},
+			},
+		},
+	}
+	assert.Nil(t, config.Validate())
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test"})
+	o := dtap.NewDnstapPrometheusOutput(config, &dtap.DnstapOutputParams{
+		Name:        "OutputPrometheus[0]",
+		BufferSize:  128,
+		InCounter:   counter,
+		LostCounter: counter,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+
+	// the response is 20ms after the query
+	dt := newTestDnstap(t, dnstap.Message_CLIENT_RESPONSE, "www.example.jp.")
+	m := new(dns.Msg)
+	m.SetReply(new(dns.Msg).SetQuestion("www.example.jp.", dns.TypeA))
+	bs, err := m.Pack()
+	assert.NoError(t, err)
+	sec := uint64(1600000000)
+	nsec := uint32(20000000)
+	dt.Message.ResponseMessage = bs
+	dt.Message.ResponseTimeSec = &sec
+	dt.Message.ResponseTimeNsec = &nsec
+	frame, err := proto.Marshal(dt)
+	assert.NoError(t, err)
+	o.SetMessage(frame)
+	assert.True(t, waitFor(func() bool { return o.Status().Frames == 1 }))
+	cancel()
+	<-done
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	var histogram, summary bool
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "test_latency_histogram_seconds":
+			histogram = true
+			if assert.Len(t, mf.GetMetric(), 1) {
+				h := mf.GetMetric()[0].GetHistogram()
+				assert.Equal(t, uint64(1), h.GetSampleCount())
+				assert.InDelta(t, 0.02, h.GetSampleSum(), 1e-9)
+				counts := []uint64{}
+				for _, b := range h.GetBucket() {
+					counts = append(counts, b.GetCumulativeCount())
+				}
+				assert.Equal(t, []uint64{0, 1, 1}, counts)
+			}
+		case "test_latency_summary_seconds":
+			summary = true
+			if assert.Len(t, mf.GetMetric(), 1) {
+				s := mf.GetMetric()[0].GetSummary()
+				assert.Equal(t, uint64(1), s.GetSampleCount())
+				assert.InDelta(t, 0.02, s.GetSampleSum(), 1e-9)
+			}
+		}
+	}
+	assert.True(t, histogram)
+	assert.True(t, summary)
+}
+
+func TestDnstapPrometheusOutputSampling(t *testing.T) {
+	config := &dtap.OutputPrometheus{
+		Counters: []dtap.OutputPrometheusMetrics{
+			{
+				Name:   "test_sampling_qtype_total",
+				Labels: []string{"Qtype"},
+			},
+		},
+		Sampling: dtap.OutputSamplingConfig{Mode: "random", Rate: 0.5},
+	}
+	assert.Nil(t, config.Validate())
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test"})
+	o := dtap.NewDnstapPrometheusOutput(config, &dtap.DnstapOutputParams{
+		Name:        "OutputPrometheus[1]",
+		BufferSize:  256,
+		InCounter:   counter,
+		LostCounter: counter,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+	frame, err := proto.Marshal(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp."))
+	assert.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		o.SetMessage(frame)
+	}
+	assert.True(t, waitFor(func() bool { return o.Status().Frames == 200 }))
+	cancel()
+	<-done
+
+	// the kept frames are counted as 1/rate frames
+	sampledOut := testutil.ToFloat64(dtap.SampledOutFrames.WithLabelValues("OutputPrometheus[1]", "random"))
+	assert.Greater(t, sampledOut, 0.0)
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	var found bool
+	for _, mf := range mfs {
+		if mf.GetName() == "test_sampling_qtype_total" && assert.Len(t, mf.GetMetric(), 1) {
+			found = true
+			assert.Equal(t, (200-sampledOut)*2, mf.GetMetric()[0].GetCounter().GetValue())
+		}
+	}
+	assert.True(t, found)
+}
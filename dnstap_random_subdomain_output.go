@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+type DnstapRandomSubdomainOutput struct {
+	config          *OutputRandomSubdomainConfig
+	flatOption      DnstapFlatOption
+	gauge           *prometheus.GaugeVec
+	detector        *RandomSubdomainDetector
+	mux             sync.Mutex
+	events          *eventWriter
+	flushCancelFunc context.CancelFunc
+}
+
+func NewDnstapRandomSubdomainOutput(config *OutputRandomSubdomainConfig, params *DnstapOutputParams) *DnstapOutput {
+	params.Handler = &DnstapRandomSubdomainOutput{
+		config:     config,
+		flatOption: &config.Flat,
+		gauge: registerCollector(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: config.GetName(),
+			Help: config.GetHelp(),
+		}, []string{"domain"})).(*prometheus.GaugeVec),
+		detector: NewRandomSubdomainDetector(config),
+	}
+	return NewDnstapOutput(params)
+}
+
+func (o *DnstapRandomSubdomainOutput) open() error {
+	var err error
+	o.events, err = newEventWriter(o.config.GetPath(), o.config.GetFluentHost(), o.config.GetFluentPort(), o.config.GetFluentTag())
+	if err != nil {
+		return err
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	o.flushCancelFunc = cancelFunc
+	go o.flush(ctx)
+	return nil
+}
+
+func (o *DnstapRandomSubdomainOutput) write(frame []byte) error {
+	dt := dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, &dt); err != nil {
+		log.Debug(err)
+		return nil
+	}
+	data, err := FlatDnstap(&dt, o.flatOption)
+	if err != nil {
+		log.Debug(err)
+		return nil
+	}
+	o.mux.Lock()
+	o.detector.Add(data)
+	o.mux.Unlock()
+	return nil
+}
+
+func (o *DnstapRandomSubdomainOutput) flush(ctx context.Context) {
+	slot := time.Duration(o.config.GetWindow()) * time.Second / time.Duration(o.config.GetSlots())
+	ticker := time.NewTicker(slot)
+	for {
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return
+		case now := <-ticker.C:
+			o.mux.Lock()
+			events := o.detector.Evaluate(now)
+			o.detector.Rotate()
+			o.mux.Unlock()
+			o.emit(now, events)
+		}
+	}
+}
+
+func (o *DnstapRandomSubdomainOutput) emit(now time.Time, events []*RandomSubdomainEvent) {
+	records := make([]interface{}, 0, len(events))
+	for _, ev := range events {
+		if ev.State == "alert" {
+			log.Warnf("random subdomain attack detected: %s %v", ev.Domain, ev.Reasons)
+			o.gauge.WithLabelValues(ev.Domain).Set(1)
+		} else {
+			o.gauge.DeleteLabelValues(ev.Domain)
+		}
+		records = append(records, ev)
+	}
+	if err := o.events.Write(now, records); err != nil {
+		log.Warn(err)
+	}
+}
+
+func (o *DnstapRandomSubdomainOutput) close() {
+	o.flushCancelFunc()
+	o.events.Close()
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+var rssac002AggregateMask = net.CIDRMask(64, 128)
+
+type DnstapRSSAC002Output struct {
+	config          *OutputRSSAC002Config
+	flatOption      DnstapFlatOption
+	mux             sync.Mutex
+	day             time.Time
+	services        map[string]*RSSAC002
+	flushCancelFunc context.CancelFunc
+}
+
+func NewDnstapRSSAC002Output(config *OutputRSSAC002Config, params *DnstapOutputParams) *DnstapOutput {
+	params.Handler = &DnstapRSSAC002Output{
+		config:     config,
+		flatOption: &config.Flat,
+		services:   map[string]*RSSAC002{},
+	}
+	return NewDnstapOutput(params)
+}
+
+func (o *DnstapRSSAC002Output) open() error {
+	o.mux.Lock()
+	if o.day.IsZero() {
+		o.day = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	o.mux.Unlock()
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	o.flushCancelFunc = cancelFunc
+	go o.flush(ctx)
+	return nil
+}
+
+func (o *DnstapRSSAC002Output) write(frame []byte) error {
+	dt := dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, &dt); err != nil {
+		log.Debug(err)
+		return nil
+	}
+	msg := dt.GetMessage()
+	if msg == nil {
+		return nil
+	}
+	switch msg.GetType() {
+	case dnstap.Message_AUTH_QUERY, dnstap.Message_AUTH_RESPONSE:
+	default:
+		return nil
+	}
+	data, err := FlatDnstap(&dt, o.flatOption)
+	if err != nil {
+		log.Debug(err)
+		return nil
+	}
+	service := o.config.GetService()
+	if service == "" {
+		service = data.Identity
+	}
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	r, ok := o.services[service]
+	if !ok {
+		r = NewRSSAC002(service, o.day)
+		o.services[service] = r
+	}
+	if msg.GetType() == dnstap.Message_AUTH_QUERY {
+		source, aggregate := o.sources(msg, data)
+		r.AddQuery(data.SocketFamily, data.SocketProtocol, data.MessageSize, source, aggregate)
+	} else {
+		r.AddResponse(data.SocketFamily, data.SocketProtocol, data.MessageSize, dns.StringToRcode[data.Rcode])
+	}
+	return nil
+}
+
+// sources returns the unique source keys of the address and its /64 prefix.
+// They are hashed or masked by the flat settings.
+func (o *DnstapRSSAC002Output) sources(msg *dnstap.Message, data *DnstapFlatT) (string, string) {
+	if data.QueryAddressHash != "" {
+		var aggregate string
+		if len(msg.GetQueryAddress()) == net.IPv6len {
+			aggregate = HashIP(o.flatOption.GetIPHashSalt(), net.IP(msg.GetQueryAddress()).Mask(rssac002AggregateMask))
+		}
+		return data.QueryAddressHash, aggregate
+	}
+	var aggregate string
+	if len(data.QueryAddress) == net.IPv6len && data.QueryAddress.To4() == nil {
+		aggregate = data.QueryAddress.Mask(rssac002AggregateMask).String()
+	}
+	return data.QueryAddress.String(), aggregate
+}
+
+func (o *DnstapRSSAC002Output) flush(ctx context.Context) {
+	for {
+		o.mux.Lock()
+		next := o.day.Add(24 * time.Hour)
+		o.mux.Unlock()
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			o.rotate()
+		}
+	}
+}
+
+// rotate closes the current day and writes the reports.
+func (o *DnstapRSSAC002Output) rotate() {
+	o.mux.Lock()
+	services := o.services
+	o.services = map[string]*RSSAC002{}
+	o.day = time.Now().UTC().Truncate(24 * time.Hour)
+	o.mux.Unlock()
+
+	for _, r := range services {
+		if err := r.WriteFiles(o.config.GetPath()); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+func (o *DnstapRSSAC002Output) close() {
+	o.flushCancelFunc()
+	o.rotate()
+}
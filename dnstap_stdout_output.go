@@ -32,6 +32,7 @@ type DnstapStdoutOutput struct {
 	config          *OutputStdoutConfig
 	enc             *framestream.Encoder
 	flatOption      DnstapFlatOption
+	sampler         *Sampler
 	flushCancelFunc context.CancelFunc
 }
 
@@ -39,6 +40,7 @@ func NewDnstapStdoutOutput(config *OutputStdoutConfig, params *DnstapOutputParam
 	params.Handler = &DnstapStdoutOutput{
 		config:     config,
 		flatOption: &config.Flat,
+		sampler:    NewSampler(&config.Sampling, params.Name),
 	}
 	return NewDnstapOutput(params)
 }
@@ -52,10 +54,15 @@ func (o *DnstapStdoutOutput) write(frame []byte) error {
 	if err := proto.Unmarshal(frame, &dt); err != nil {
 		return err
 	}
+	rate, ok := o.sampler.Sample(&dt)
+	if !ok {
+		return nil
+	}
 	data, err := FlatDnstap(&dt, o.flatOption)
 	if err != nil {
 		return err
 	}
+	data.SampleRate = rate
 	switch o.config.GetType() {
 	case "json":
 		buf, err := json.Marshal(data)
@@ -65,7 +72,7 @@ func (o *DnstapStdoutOutput) write(frame []byte) error {
 		fmt.Println(string(buf))
 	case "gotpl":
 		buf := &bytes.Buffer{}
-		if err := o.config.template.Execute(buf, data); err != nil {
+		if err := o.config.template.Execute(buf, data.selected()); err != nil {
 			return err
 		}
 		fmt.Println(buf.String())
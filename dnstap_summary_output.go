@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+)
+
+type DnstapSummaryOutput struct {
+	config          *OutputSummaryConfig
+	flatOption      DnstapFlatOption
+	mux             sync.Mutex
+	summary         *Summary
+	windowStart     time.Time
+	events          *eventWriter
+	flushCancelFunc context.CancelFunc
+}
+
+// DnstapSummaryEvent is a row of the summary written by the Summary output.
+type DnstapSummaryEvent struct {
+	Start    string `json:"start" msg:"start"`
+	Interval uint   `json:"interval" msg:"interval"`
+	Dataset  string `json:"dataset" msg:"dataset"`
+	Key1     string `json:"key1" msg:"key1"`
+	Key2     string `json:"key2" msg:"key2"`
+	Count    uint64 `json:"count" msg:"count"`
+}
+
+func (e *DnstapSummaryEvent) csvHeader() []string {
+	return []string{"start", "interval", "dataset", "key1", "key2", "count"}
+}
+
+func (e *DnstapSummaryEvent) csvRecord() []string {
+	return []string{e.Start, strconv.Itoa(int(e.Interval)), e.Dataset, e.Key1, e.Key2, strconv.FormatUint(e.Count, 10)}
+}
+
+func NewDnstapSummaryOutput(config *OutputSummaryConfig, params *DnstapOutputParams) *DnstapOutput {
+	params.Handler = &DnstapSummaryOutput{
+		config:     config,
+		flatOption: &config.Flat,
+		summary:    NewSummary(config.GetDatasets()),
+	}
+	return NewDnstapOutput(params)
+}
+
+func (o *DnstapSummaryOutput) interval() time.Duration {
+	return time.Duration(o.config.GetInterval()) * time.Second
+}
+
+func (o *DnstapSummaryOutput) open() error {
+	var err error
+	o.events, err = newEventWriter(o.config.GetPath(), o.config.GetFluentHost(), o.config.GetFluentPort(), o.config.GetFluentTag())
+	if err != nil {
+		return err
+	}
+	o.events.csv = o.config.GetFormat() == "csv"
+	o.mux.Lock()
+	if o.windowStart.IsZero() {
+		o.windowStart = time.Now().Truncate(o.interval())
+	}
+	o.mux.Unlock()
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	o.flushCancelFunc = cancelFunc
+	go o.flush(ctx)
+	return nil
+}
+
+func (o *DnstapSummaryOutput) write(frame []byte) error {
+	dt := dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, &dt); err != nil {
+		return err
+	}
+	data, err := FlatDnstap(&dt, o.flatOption)
+	if err != nil {
+		return err
+	}
+	o.mux.Lock()
+	o.summary.Add(data)
+	o.mux.Unlock()
+	return nil
+}
+
+func (o *DnstapSummaryOutput) flush(ctx context.Context) {
+	for {
+		o.mux.Lock()
+		next := o.windowStart.Add(o.interval())
+		o.mux.Unlock()
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			if err := o.rotate(); err != nil {
+				log.Warn(err)
+			}
+		}
+	}
+}
+
+// rotate closes the current interval and writes the summary.
+func (o *DnstapSummaryOutput) rotate() error {
+	o.mux.Lock()
+	start := o.windowStart
+	rows := o.summary.Rows()
+	o.summary = NewSummary(o.config.GetDatasets())
+	o.windowStart = time.Now().Truncate(o.interval())
+	o.mux.Unlock()
+
+	events := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, &DnstapSummaryEvent{
+			Start:    start.Format(time.RFC3339),
+			Interval: o.config.GetInterval(),
+			Dataset:  row.Dataset,
+			Key1:     row.Key(0),
+			Key2:     row.Key(1),
+			Count:    row.Count,
+		})
+	}
+	return o.events.Write(start, events)
+}
+
+func (o *DnstapSummaryOutput) close() {
+	o.flushCancelFunc()
+	if err := o.rotate(); err != nil {
+		log.Warn(err)
+	}
+	o.events.Close()
+}
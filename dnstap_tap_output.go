@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	framestream "github.com/farsightsec/golang-framestream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// tapClientBufferSize is the number of frames buffered per client.
+// Frames are dropped for slow clients instead of blocking the other outputs.
+const tapClientBufferSize = 10000
+
+// tapWriteTimeout detaches clients which stop reading.
+const tapWriteTimeout = 5 * time.Second
+
+var TapDroppedFrames = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "dtap_tap_dropped_frames_total",
+	Help: "The total number of frames dropped for slow tap clients",
+})
+
+// DnstapTapOutput serves the frames to attached fstrm readers such as dtap top.
+// dtap is the writer of the bidirectional fstrm stream on the accepted connections.
+type DnstapTapOutput struct {
+	config   *OutputTapConfig
+	listener net.Listener
+	accepted bool
+	mux      sync.Mutex
+	clients  map[*tapClient]struct{}
+}
+
+type tapClient struct {
+	conn   net.Conn
+	frames chan []byte
+}
+
+func NewDnstapTapOutput(config *OutputTapConfig, params *DnstapOutputParams) (*DnstapOutput, error) {
+	var l net.Listener
+	var err error
+	if config.GetPath() != "" {
+		l, err = listenUnix(config.GetPath(), config.GetUser())
+	} else if l, err = net.Listen("tcp", config.GetAddress()); err != nil {
+		err = fmt.Errorf("failed to listen %s: %w", config.GetAddress(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	params.Handler = &DnstapTapOutput{
+		config:   config,
+		listener: l,
+		clients:  map[*tapClient]struct{}{},
+	}
+	return NewDnstapOutput(params), nil
+}
+
+func (o *DnstapTapOutput) open() error {
+	if !o.accepted {
+		o.accepted = true
+		go o.accept()
+	}
+	return nil
+}
+
+func (o *DnstapTapOutput) accept() {
+	for {
+		conn, err := o.listener.Accept()
+		if err != nil {
+			log.Debugf("finish tap accept: %v", err)
+			return
+		}
+		go o.serve(conn)
+	}
+}
+
+func (o *DnstapTapOutput) serve(conn net.Conn) {
+	enc, err := framestream.NewEncoder(conn, &framestream.EncoderOptions{ContentType: dnstap.FSContentType, Bidirectional: true, Timeout: tapWriteTimeout})
+	if err != nil {
+		log.Debugf("failed to start tap stream: %v", err)
+		conn.Close()
+		return
+	}
+	c := &tapClient{conn: conn, frames: make(chan []byte, tapClientBufferSize)}
+	o.mux.Lock()
+	o.clients[c] = struct{}{}
+	o.mux.Unlock()
+	log.Debugf("tap client attached: %s", conn.RemoteAddr())
+
+	ticker := time.NewTicker(FlushTimeout)
+	defer ticker.Stop()
+L:
+	for {
+		select {
+		case frame, ok := <-c.frames:
+			if !ok {
+				enc.Flush()
+				enc.Close()
+				break L
+			}
+			if _, err := enc.Write(frame); err != nil {
+				log.Debugf("tap client error: %v", err)
+				break L
+			}
+		case <-ticker.C:
+			if err := enc.Flush(); err != nil {
+				log.Debugf("tap client error: %v", err)
+				break L
+			}
+		}
+	}
+	o.remove(c)
+	conn.Close()
+	log.Debugf("tap client detached: %s", conn.RemoteAddr())
+}
+
+func (o *DnstapTapOutput) remove(c *tapClient) {
+	o.mux.Lock()
+	if _, ok := o.clients[c]; ok {
+		delete(o.clients, c)
+		close(c.frames)
+	}
+	o.mux.Unlock()
+}
+
+func (o *DnstapTapOutput) write(frame []byte) error {
+	o.mux.Lock()
+	for c := range o.clients {
+		select {
+		case c.frames <- frame:
+		default:
+			TapDroppedFrames.Inc()
+		}
+	}
+	o.mux.Unlock()
+	return nil
+}
+
+func (o *DnstapTapOutput) release() {
+	o.listener.Close()
+}
+
+func (o *DnstapTapOutput) close() {
+	o.listener.Close()
+	o.mux.Lock()
+	for c := range o.clients {
+		delete(o.clients, c)
+		close(c.frames)
+	}
+	o.mux.Unlock()
+}
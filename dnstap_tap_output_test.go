@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestDnstapTapOutput(t *testing.T) {
+	assert.Error(t, (&dtap.OutputTapConfig{}).Validate())
+	assert.Error(t, (&dtap.OutputTapConfig{Path: "/tmp/tap.sock", Address: "127.0.0.1:10054"}).Validate())
+	assert.Error(t, (&dtap.OutputTapConfig{Address: "127.0.0.1"}).Validate())
+	assert.Nil(t, (&dtap.OutputTapConfig{Address: "127.0.0.1:10054"}).Validate())
+
+	dir, err := ioutil.TempDir("", "dtap-test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tap.sock")
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test"})
+	o, err := dtap.NewDnstapTapOutput(&dtap.OutputTapConfig{Path: path}, &dtap.DnstapOutputParams{
+		BufferSize:  128,
+		InCounter:   counter,
+		LostCounter: counter,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go o.Run(ctx)
+
+	conn, err := net.Dial("unix", path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	input, err := dtap.NewDnstapFstrmInput(conn, true)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer input.Close()
+	frame := newTestFrame(t, "www.example.jp.", 1)
+	// frames are sent to the client after it is registered
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				o.SetMessage(frame)
+			}
+		}
+	}()
+	read, err := input.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, frame, read)
+}
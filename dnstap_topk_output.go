@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+)
+
+const topKKeySeparator = "\x00"
+
+type DnstapTopKOutput struct {
+	config          *OutputTopKConfig
+	flatOption      DnstapFlatOption
+	gauge           *gaugeSnapshot
+	topk            *SlidingTopK
+	mux             sync.Mutex
+	flushCancelFunc context.CancelFunc
+}
+
+type DnstapTopKResult struct {
+	Name    string                  `json:"name"`
+	Keys    []string                `json:"keys"`
+	Window  uint                    `json:"window"`
+	Entries []DnstapTopKResultEntry `json:"entries"`
+}
+
+type DnstapTopKResultEntry struct {
+	Rank   int               `json:"rank"`
+	Values map[string]string `json:"values"`
+	Count  uint64            `json:"count"`
+	Error  uint64            `json:"error"`
+}
+
+// NewDnstapTopKOutput makes the output and registers the ranked list
+// to the HTTP endpoint of http.DefaultServeMux.
+func NewDnstapTopKOutput(config *OutputTopKConfig, params *DnstapOutputParams) *DnstapOutput {
+	o := &DnstapTopKOutput{
+		config:     config,
+		flatOption: &config.Flat,
+		gauge:      registerCollector(newGaugeSnapshot(config.GetName(), config.GetHelp(), config.GetKeys())).(*gaugeSnapshot),
+		topk:       NewSlidingTopK(int(config.GetSlots()), func() TopKSketch { return newTopKSketch(config) }),
+	}
+	handleHTTP(config.GetPath(), o)
+	params.Handler = o
+	return NewDnstapOutput(params)
+}
+
+func newTopKSketch(config *OutputTopKConfig) TopKSketch {
+	if config.GetSketch() == "count-min" {
+		return NewCountMinTopK(int(config.GetCapacity()), int(config.GetWidth()), int(config.GetDepth()))
+	}
+	return NewSpaceSaving(int(config.GetCapacity()))
+}
+
+func (o *DnstapTopKOutput) open() error {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	o.flushCancelFunc = cancelFunc
+	go o.flush(ctx)
+	return nil
+}
+
+func (o *DnstapTopKOutput) write(frame []byte) error {
+	dt := dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, &dt); err != nil {
+		return err
+	}
+	data, err := FlatDnstap(&dt, o.flatOption)
+	if err != nil {
+		return err
+	}
+	m := data.ToLabelMap()
+	values := make([]string, 0, len(o.config.GetKeys()))
+	for _, k := range o.config.GetKeys() {
+		values = append(values, m[k])
+	}
+	o.mux.Lock()
+	o.topk.Add(strings.Join(values, topKKeySeparator), 1)
+	o.mux.Unlock()
+	return nil
+}
+
+func (o *DnstapTopKOutput) flush(ctx context.Context) {
+	slot := time.Duration(o.config.GetWindow()) * time.Second / time.Duration(o.config.GetSlots())
+	ticker := time.NewTicker(slot)
+	for {
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			o.mux.Lock()
+			entries := o.topk.Entries()
+			o.topk.Rotate()
+			o.mux.Unlock()
+			o.updateGauge(entries)
+		}
+	}
+}
+
+func (o *DnstapTopKOutput) updateGauge(entries []TopKEntry) {
+	values := make([]gaugeSnapshotValue, 0, o.config.GetK())
+	for i, e := range entries {
+		if uint(i) >= o.config.GetK() {
+			break
+		}
+		values = append(values, gaugeSnapshotValue{
+			labels: strings.Split(e.Key, topKKeySeparator),
+			value:  float64(e.Count),
+		})
+	}
+	o.gauge.set(values)
+}
+
+// Result returns the ranked list of the current window.
+func (o *DnstapTopKOutput) Result() *DnstapTopKResult {
+	o.mux.Lock()
+	entries := o.topk.Entries()
+	o.mux.Unlock()
+	res := &DnstapTopKResult{
+		Name:    o.config.GetName(),
+		Keys:    o.config.GetKeys(),
+		Window:  o.config.GetWindow(),
+		Entries: make([]DnstapTopKResultEntry, 0, len(entries)),
+	}
+	for i, e := range entries {
+		values := map[string]string{}
+		for n, v := range strings.Split(e.Key, topKKeySeparator) {
+			values[o.config.GetKeys()[n]] = v
+		}
+		res.Entries = append(res.Entries, DnstapTopKResultEntry{
+			Rank:   i + 1,
+			Values: values,
+			Count:  e.Count,
+			Error:  e.Error,
+		})
+	}
+	return res
+}
+
+func (o *DnstapTopKOutput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(o.Result()); err != nil {
+		log.Debug(err)
+	}
+}
+
+func (o *DnstapTopKOutput) close() {
+	o.flushCancelFunc()
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	strftime "github.com/jehiah/go-strftime"
+	log "github.com/sirupsen/logrus"
+)
+
+type DnstapUniqueOutput struct {
+	config          *OutputUniqueConfig
+	flatOption      DnstapFlatOption
+	gauge           *gaugeSnapshot
+	mux             sync.Mutex
+	windowStart     time.Time
+	sketches        map[string]*uniqueSketch
+	persistMux      sync.Mutex
+	flushCancelFunc context.CancelFunc
+}
+
+type uniqueSketch struct {
+	values []string
+	hll    *HyperLogLog
+}
+
+func NewDnstapUniqueOutput(config *OutputUniqueConfig, params *DnstapOutputParams) *DnstapOutput {
+	params.Handler = &DnstapUniqueOutput{
+		config:     config,
+		flatOption: &config.Flat,
+		gauge:      registerCollector(newGaugeSnapshot(config.GetName(), config.GetHelp(), config.GetLabels())).(*gaugeSnapshot),
+		sketches:   map[string]*uniqueSketch{},
+	}
+	return NewDnstapOutput(params)
+}
+
+func (o *DnstapUniqueOutput) window() time.Duration {
+	return time.Duration(o.config.GetWindow()) * time.Second
+}
+
+func (o *DnstapUniqueOutput) open() error {
+	o.mux.Lock()
+	if o.windowStart.IsZero() {
+		o.windowStart = time.Now().Truncate(o.window())
+	}
+	o.mux.Unlock()
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	o.flushCancelFunc = cancelFunc
+	go o.flush(ctx)
+	return nil
+}
+
+func (o *DnstapUniqueOutput) write(frame []byte) error {
+	dt := dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, &dt); err != nil {
+		return err
+	}
+	data, err := FlatDnstap(&dt, o.flatOption)
+	if err != nil {
+		return err
+	}
+	m := data.ToLabelMap()
+	value, ok := m[o.config.GetValue()]
+	if !ok || value == "" {
+		return nil
+	}
+	values := make([]string, 0, len(o.config.GetLabels()))
+	for _, l := range o.config.GetLabels() {
+		values = append(values, m[l])
+	}
+	key := strings.Join(values, "\x00")
+	o.mux.Lock()
+	s, ok := o.sketches[key]
+	if !ok {
+		s = &uniqueSketch{
+			values: values,
+			hll:    NewHyperLogLog(o.config.GetPrecision()),
+		}
+		o.sketches[key] = s
+	}
+	s.hll.Add(value)
+	o.mux.Unlock()
+	return nil
+}
+
+func (o *DnstapUniqueOutput) flush(ctx context.Context) {
+	for {
+		o.mux.Lock()
+		next := o.windowStart.Add(o.window())
+		o.mux.Unlock()
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			o.rotate()
+		}
+	}
+}
+
+// rotate closes the current window, exports the gauges and persists the sketches.
+func (o *DnstapUniqueOutput) rotate() {
+	o.mux.Lock()
+	snapshot := o.snapshot()
+	o.sketches = map[string]*uniqueSketch{}
+	o.windowStart = time.Now().Truncate(o.window())
+	o.mux.Unlock()
+
+	values := make([]gaugeSnapshotValue, 0, len(snapshot.Sketches))
+	for _, s := range snapshot.Sketches {
+		values = append(values, gaugeSnapshotValue{labels: s.Values, value: float64(s.Count)})
+	}
+	o.gauge.set(values)
+	if o.config.GetPersistPath() != "" {
+		if err := o.persist(snapshot); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+func (o *DnstapUniqueOutput) snapshot() *HyperLogLogSnapshot {
+	snapshot := &HyperLogLogSnapshot{
+		Name:        o.config.GetName(),
+		Labels:      o.config.GetLabels(),
+		WindowStart: o.windowStart.Unix(),
+		Window:      o.config.GetWindow(),
+		Sketches:    make([]HyperLogLogSnapshotSketch, 0, len(o.sketches)),
+	}
+	for _, s := range o.sketches {
+		b, _ := s.hll.MarshalBinary()
+		snapshot.Sketches = append(snapshot.Sketches, HyperLogLogSnapshotSketch{
+			Values: s.values,
+			Count:  s.hll.Count(),
+			Sketch: b,
+		})
+	}
+	return snapshot
+}
+
+// persist writes the snapshot to the file of the window.
+// If the file exists, e.g. written by close before restart, the snapshot is merged with it.
+func (o *DnstapUniqueOutput) persist(snapshot *HyperLogLogSnapshot) error {
+	o.persistMux.Lock()
+	defer o.persistMux.Unlock()
+	filename := strftime.Format(o.config.GetPersistPath(), time.Unix(snapshot.WindowStart, 0))
+	if _, err := os.Stat(filename); err == nil {
+		old, err := ReadHyperLogLogSnapshot(filename)
+		if err != nil {
+			return err
+		}
+		if snapshot, err = MergeHyperLogLogSnapshots(old, snapshot); err != nil {
+			return fmt.Errorf("failed to merge snapshot %s err: %w", filename, err)
+		}
+	}
+	buf, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if err := ioutil.WriteFile(filename, buf, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot %s err: %w", filename, err)
+	}
+	return nil
+}
+
+// close persists the current window not to lose it on stop and reload.
+func (o *DnstapUniqueOutput) close() {
+	o.flushCancelFunc()
+	if o.config.GetPersistPath() == "" {
+		return
+	}
+	o.mux.Lock()
+	snapshot := o.snapshot()
+	o.sketches = map[string]*uniqueSketch{}
+	o.mux.Unlock()
+	if len(snapshot.Sketches) == 0 {
+		return
+	}
+	if err := o.persist(snapshot); err != nil {
+		log.Warn(err)
+	}
+}
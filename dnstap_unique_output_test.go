@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestDnstapUniqueOutputPersistOnClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtap-test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	config := &dtap.OutputUniqueConfig{
+		Name:        "dtap_test_unique_qnames",
+		Labels:      []string{"Qtype"},
+		Value:       "Qname",
+		Window:      86400,
+		PersistPath: filepath.Join(dir, "unique.json"),
+	}
+	assert.Nil(t, config.Validate())
+
+	// a restart in the same window merges the snapshot with the one written on close
+	for _, qname := range []string{"a.example.jp.", "b.example.jp."} {
+		counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test"})
+		o := dtap.NewDnstapUniqueOutput(config, &dtap.DnstapOutputParams{
+			Name:        "OutputUnique[0]",
+			BufferSize:  128,
+			InCounter:   counter,
+			LostCounter: counter,
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			o.Run(ctx)
+			close(done)
+		}()
+		frame, err := proto.Marshal(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, qname))
+		assert.NoError(t, err)
+		o.SetMessage(frame)
+		assert.True(t, waitFor(func() bool { return o.Status().Frames == 1 }))
+		cancel()
+		<-done
+	}
+
+	snapshot, err := dtap.ReadHyperLogLogSnapshot(config.PersistPath)
+	if assert.NoError(t, err) && assert.Len(t, snapshot.Sketches, 1) {
+		assert.Equal(t, []string{"A"}, snapshot.Sketches[0].Values)
+		assert.Equal(t, uint64(2), snapshot.Sketches[0].Count)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fluent/fluent-logger-golang/fluent"
+	strftime "github.com/jehiah/go-strftime"
+)
+
+// eventWriter writes alert events to the strftime formatted path (default stdout) as JSON lines,
+// and forwards them to fluentd if the fluent host is set. Fluentd is the only forward target.
+// If csv is set, the events are written as CSV, they must be csvEvent.
+type eventWriter struct {
+	path   string
+	tag    string
+	csv    bool
+	client *fluent.Fluent
+}
+
+// csvEvent is an event written as a CSV record.
+type csvEvent interface {
+	csvHeader() []string
+	csvRecord() []string
+}
+
+func newEventWriter(path, fluentHost string, fluentPort int, fluentTag string) (*eventWriter, error) {
+	w := &eventWriter{
+		path: path,
+		tag:  fluentTag,
+	}
+	if fluentHost != "" {
+		var err error
+		w.client, err = fluent.New(fluent.Config{
+			FluentHost: fluentHost,
+			FluentPort: fluentPort,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create fluent logger: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// Write writes the events, they must be structs having msg tags.
+func (w *eventWriter) Write(t time.Time, events []interface{}) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if w.path != "" || w.client == nil {
+		if err := w.writeFile(t, events); err != nil {
+			return err
+		}
+	}
+	if w.client != nil {
+		for _, ev := range events {
+			if err := w.client.Post(w.tag, ev); err != nil {
+				return fmt.Errorf("failed to post fluent message, tag: %s %w", w.tag, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *eventWriter) writeFile(t time.Time, events []interface{}) error {
+	var out io.Writer = os.Stdout
+	var newFile bool
+	if w.path != "" {
+		filename := strftime.Format(w.path, t)
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			return fmt.Errorf("failed to create file %s err: %w", filename, err)
+		}
+		defer f.Close()
+		if stat, err := f.Stat(); err == nil && stat.Size() == 0 {
+			newFile = true
+		}
+		out = f
+	}
+	if w.csv {
+		return writeCSVEvents(out, events, newFile)
+	}
+	for _, ev := range events {
+		buf, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(out, string(buf)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSVEvents writes the events as CSV, the header is written to new files.
+func writeCSVEvents(out io.Writer, events []interface{}, header bool) error {
+	cw := csv.NewWriter(out)
+	for i, ev := range events {
+		e, ok := ev.(csvEvent)
+		if !ok {
+			return fmt.Errorf("event %T can't be written as CSV", ev)
+		}
+		if i == 0 && header {
+			cw.Write(e.csvHeader())
+		}
+		cw.Write(e.csvRecord())
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (w *eventWriter) Close() {
+	if w.client != nil {
+		w.client.Close()
+	}
+}
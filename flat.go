@@ -20,47 +20,67 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"net"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
 	dnstap "github.com/dnstap/golang-dnstap"
 	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
 )
 
 type DnstapFlatT struct {
-	Timestamp             string `json:"timestamp" msg:"timestamp"`
-	QueryTime             string `json:"query_time,omitempty" msg:"query_time"`
-	QueryAddress          net.IP `json:"query_address,omitempty" msg:"query_address"`
-	QueryAddressHash      string `json:"query_address_hash,omitempty" msg:"query_address_hash"`
-	QueryPort             uint32 `json:"query_port,omitempty" msg:"query_port"`
-	ResponseTime          string `json:"response_time,omitempty" msg:"response_time"`
-	ResponseAddress       net.IP `json:"response_address,omitempty" msg:"response_address"`
-	ResponseAddressHash   string `json:"response_address_hash,omitempty" msg:"response_address_hash"`
-	ResponsePort          uint32 `json:"response_port,omitempty" msg:"response_port"`
-	ResponseZone          string `json:"response_zone,omitempty" msg:"response_zone"`
-	EcsNet                *Net   `json:"ecs_net,omitempty" msg:"ecs_net"`
-	Identity              string `json:"identity,omitempty" msg:"identity"`
-	Type                  string `json:"type" msg:"type"`
-	SocketFamily          string `json:"socket_family" msg:"socket_family"`
-	SocketProtocol        string `json:"socket_protocol" msg:"socket_protocol"`
-	Version               string `json:"version" msg:"version"`
-	Extra                 string `json:"extra" msg:"extra"`
-	TopLevelDomainName    string `json:"tld" msg:"tld"`
-	SecondLevelDomainName string `json:"sld" msg:"sld"`
-	ThirdLevelDomainName  string `json:"thirdld" msg:"thirdld"`
-	FourthLevelDomainName string `json:"fourthld" msg:"fourthld"`
-	Qname                 string `json:"qname" msg:"qname"`
-	Qclass                string `json:"qclass" msg:"qclass"`
-	Qtype                 string `json:"qtype" msg:"qtype"`
-	MessageSize           int    `json:"message_size" msg:"message_size"`
-	Txid                  uint16 `json:"txid" msg:"txid"`
-	Rcode                 string `json:"rcode" msg:"rcode"`
-	AA                    bool   `json:"aa" msg:"aa"`
-	TC                    bool   `json:"tc" msg:"tc"`
-	RD                    bool   `json:"rd" msg:"rd"`
-	RA                    bool   `json:"ra" msg:"ra"`
-	AD                    bool   `json:"ad" msg:"ad"`
-	CD                    bool   `json:"cd" msg:"cd"`
+	Timestamp             string  `json:"timestamp" msg:"timestamp"`
+	QueryTime             string  `json:"query_time,omitempty" msg:"query_time"`
+	QueryAddress          net.IP  `json:"query_address,omitempty" msg:"query_address"`
+	QueryAddressHash      string  `json:"query_address_hash,omitempty" msg:"query_address_hash"`
+	QueryPort             uint32  `json:"query_port,omitempty" msg:"query_port"`
+	ResponseTime          string  `json:"response_time,omitempty" msg:"response_time"`
+	ResponseAddress       net.IP  `json:"response_address,omitempty" msg:"response_address"`
+	ResponseAddressHash   string  `json:"response_address_hash,omitempty" msg:"response_address_hash"`
+	SaltEpoch             int64   `json:"salt_epoch,omitempty" msg:"salt_epoch"`
+	ResponsePort          uint32  `json:"response_port,omitempty" msg:"response_port"`
+	ResponseZone          string  `json:"response_zone,omitempty" msg:"response_zone"`
+	EcsNet                *Net    `json:"ecs_net,omitempty" msg:"ecs_net"`
+	Identity              string  `json:"identity,omitempty" msg:"identity"`
+	Type                  string  `json:"type" msg:"type"`
+	SocketFamily          string  `json:"socket_family" msg:"socket_family"`
+	SocketProtocol        string  `json:"socket_protocol" msg:"socket_protocol"`
+	Version               string  `json:"version" msg:"version"`
+	Extra                 string  `json:"extra" msg:"extra"`
+	TopLevelDomainName    string  `json:"tld" msg:"tld"`
+	SecondLevelDomainName string  `json:"sld" msg:"sld"`
+	ThirdLevelDomainName  string  `json:"thirdld" msg:"thirdld"`
+	FourthLevelDomainName string  `json:"fourthld" msg:"fourthld"`
+	Qname                 string  `json:"qname" msg:"qname"`
+	Qclass                string  `json:"qclass" msg:"qclass"`
+	Qtype                 string  `json:"qtype" msg:"qtype"`
+	MessageSize           int     `json:"message_size" msg:"message_size"`
+	Txid                  uint16  `json:"txid" msg:"txid"`
+	Rcode                 string  `json:"rcode" msg:"rcode"`
+	Opcode                string  `json:"opcode" msg:"opcode"`
+	EdnsUDPSize           uint16  `json:"edns_udp_size,omitempty" msg:"edns_udp_size"`
+	AA                    bool    `json:"aa" msg:"aa"`
+	TC                    bool    `json:"tc" msg:"tc"`
+	RD                    bool    `json:"rd" msg:"rd"`
+	RA                    bool    `json:"ra" msg:"ra"`
+	AD                    bool    `json:"ad" msg:"ad"`
+	CD                    bool    `json:"cd" msg:"cd"`
+	QnameEntropy          float64 `json:"qname_entropy,omitempty" msg:"qname_entropy"`
+	LongestLabel          int     `json:"longest_label,omitempty" msg:"longest_label"`
+	DigitRatio            float64 `json:"digit_ratio,omitempty" msg:"digit_ratio"`
+	ConsonantRun          int     `json:"consonant_run,omitempty" msg:"consonant_run"`
+	DomainBytes           uint64  `json:"domain_bytes,omitempty" msg:"domain_bytes"`
+	Suspicious            bool    `json:"suspicious,omitempty" msg:"suspicious"`
+	SuspiciousReason      string  `json:"suspicious_reason,omitempty" msg:"suspicious_reason"`
+	SampleRate            float64 `json:"sample_rate,omitempty" msg:"sample_rate"`
+	fields                *FlatFields
+	timestamp             time.Time
+	queryTime             time.Time
+	responseTime          time.Time
+	timestampFormat       string
+	scored                bool
 }
 
 var (
@@ -74,6 +94,11 @@ type DnstapFlatOption interface {
 	GetEnableEcs() bool
 	GetEnableHashIP() bool
 	GetIPHashSalt() []byte
+	GetIPHashSaltEpoch() int64
+	GetFields() (*FlatFields, error)
+	GetTimestampFormat() string
+	GetLocation() *time.Location
+	GetSuspiciousScorer() *SuspiciousScorer
 }
 
 func FlatDnstap(dt *dnstap.Dnstap, opt DnstapFlatOption) (*DnstapFlatT, error) {
@@ -87,18 +112,27 @@ func FlatDnstap(dt *dnstap.Dnstap, opt DnstapFlatOption) (*DnstapFlatT, error) {
 		dnsMessage = msg.GetResponseMessage()
 	}
 
-	data.QueryTime = time.Unix(int64(msg.GetQueryTimeSec()), int64(msg.GetQueryTimeNsec())).Format(time.RFC3339Nano)
-	data.ResponseTime = time.Unix(int64(msg.GetResponseTimeSec()), int64(msg.GetResponseTimeNsec())).Format(time.RFC3339Nano)
+	var queryTime, responseTime time.Time
+	if msg.GetQueryTimeSec() != 0 {
+		queryTime = time.Unix(int64(msg.GetQueryTimeSec()), int64(msg.GetQueryTimeNsec()))
+		data.QueryTime = queryTime.In(opt.GetLocation()).Format(time.RFC3339Nano)
+	}
+	if msg.GetResponseTimeSec() != 0 {
+		responseTime = time.Unix(int64(msg.GetResponseTimeSec()), int64(msg.GetResponseTimeNsec()))
+		data.ResponseTime = responseTime.In(opt.GetLocation()).Format(time.RFC3339Nano)
+	}
 	if len(msg.GetQueryAddress()) == 4 {
 		data.QueryAddress = net.IP(msg.GetQueryAddress()).Mask(opt.GetIPv4Mask())
 	} else {
 		data.QueryAddress = net.IP(msg.GetQueryAddress()).Mask(opt.GetIPv6Mask())
 	}
-	if opt.GetEnableHashIP() && opt.GetIPHashSalt() != nil {
-		bs := make([]byte, len(opt.GetIPHashSalt())+16)
-		bs = append(bs, opt.GetIPHashSalt()...)
-		bs = append(bs, net.IP(msg.GetQueryAddress()).To16()...)
-		data.QueryAddressHash = fmt.Sprintf("%x", sha256.Sum256(bs))
+	var salt []byte
+	if opt.GetEnableHashIP() {
+		salt = opt.GetIPHashSalt()
+		data.SaltEpoch = opt.GetIPHashSaltEpoch()
+	}
+	if salt != nil {
+		data.QueryAddressHash = HashIP(salt, msg.GetQueryAddress())
 	}
 	data.QueryPort = msg.GetQueryPort()
 	if len(msg.GetResponseAddress()) == 4 {
@@ -106,11 +140,8 @@ func FlatDnstap(dt *dnstap.Dnstap, opt DnstapFlatOption) (*DnstapFlatT, error) {
 	} else {
 		data.ResponseAddress = net.IP(msg.GetResponseAddress()).Mask(opt.GetIPv6Mask()).To16()
 	}
-	if opt.GetEnableHashIP() && opt.GetIPHashSalt() != nil {
-		bs := make([]byte, len(opt.GetIPHashSalt())+16)
-		bs = append(bs, opt.GetIPHashSalt()...)
-		bs = append(bs, net.IP(msg.GetResponseAddress()).To16()...)
-		data.ResponseAddressHash = fmt.Sprintf("%x", sha256.Sum256(bs))
+	if salt != nil {
+		data.ResponseAddressHash = HashIP(salt, msg.GetResponseAddress())
 	}
 
 	data.ResponsePort = msg.GetResponsePort()
@@ -167,6 +198,10 @@ func FlatDnstap(dt *dnstap.Dnstap, opt DnstapFlatOption) (*DnstapFlatT, error) {
 		}
 	}
 	data.Rcode = dns.RcodeToString[dnsMsg.Rcode]
+	data.Opcode = dns.OpcodeToString[dnsMsg.Opcode]
+	if edns0 := dnsMsg.IsEdns0(); edns0 != nil {
+		data.EdnsUDPSize = edns0.UDPSize()
+	}
 	data.AA = dnsMsg.Authoritative
 	data.TC = dnsMsg.Truncated
 	data.RD = dnsMsg.RecursionDesired
@@ -174,18 +209,89 @@ func FlatDnstap(dt *dnstap.Dnstap, opt DnstapFlatOption) (*DnstapFlatT, error) {
 	data.AD = dnsMsg.AuthenticatedData
 	data.CD = dnsMsg.CheckingDisabled
 
+	timestamp := MessageTime(msg)
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	data.Timestamp = timestamp.In(opt.GetLocation()).Format(time.RFC3339Nano)
+	opt.GetSuspiciousScorer().Score(&data, timestamp)
+	data.timestamp = timestamp
+	data.queryTime = queryTime
+	data.responseTime = responseTime
+	data.timestampFormat = opt.GetTimestampFormat()
+	fields, err := opt.GetFields()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Fields: %w", err)
+	}
+	data.fields = fields
+
+	return &data, nil
+}
+
+// MessageTime returns the query time of query messages and the response time of the others.
+// If it isn't set, the other time is used. It returns zero time if neither is set.
+func MessageTime(msg *dnstap.Message) time.Time {
+	var queryTime, responseTime time.Time
+	if msg.GetQueryTimeSec() != 0 {
+		queryTime = time.Unix(int64(msg.GetQueryTimeSec()), int64(msg.GetQueryTimeNsec()))
+	}
+	if msg.GetResponseTimeSec() != 0 {
+		responseTime = time.Unix(int64(msg.GetResponseTimeSec()), int64(msg.GetResponseTimeNsec()))
+	}
+	var timestamp time.Time
 	switch msg.GetType() {
 	case dnstap.Message_AUTH_QUERY, dnstap.Message_RESOLVER_QUERY,
 		dnstap.Message_CLIENT_QUERY, dnstap.Message_FORWARDER_QUERY,
-		dnstap.Message_STUB_QUERY, dnstap.Message_TOOL_QUERY:
-		data.Timestamp = data.QueryTime
-	case dnstap.Message_AUTH_RESPONSE, dnstap.Message_RESOLVER_RESPONSE,
-		dnstap.Message_CLIENT_RESPONSE, dnstap.Message_FORWARDER_RESPONSE,
-		dnstap.Message_STUB_RESPONSE, dnstap.Message_TOOL_RESPONSE:
-		data.Timestamp = data.ResponseTime
+		dnstap.Message_STUB_QUERY, dnstap.Message_TOOL_QUERY,
+		dnstap.Message_UPDATE_QUERY:
+		timestamp = queryTime
+	default:
+		timestamp = responseTime
+	}
+	if timestamp.IsZero() {
+		if !responseTime.IsZero() {
+			timestamp = responseTime
+		} else {
+			timestamp = queryTime
+		}
 	}
+	return timestamp
+}
 
-	return &data, nil
+// Latency returns the time between query and response.
+// It is available only on response messages including query time.
+func (d *DnstapFlatT) Latency() (time.Duration, bool) {
+	if d.queryTime.IsZero() || d.responseTime.IsZero() || d.responseTime.Before(d.queryTime) {
+		return 0, false
+	}
+	return d.responseTime.Sub(d.queryTime), true
+}
+
+// HashIP returns the salted hash of the address.
+func HashIP(salt []byte, ip net.IP) string {
+	bs := make([]byte, len(salt)+16)
+	bs = append(bs, salt...)
+	bs = append(bs, ip.To16()...)
+	return fmt.Sprintf("%x", sha256.Sum256(bs))
+}
+
+// epochTime returns whether the times are written as integers of TimestampFormat.
+func (d *DnstapFlatT) epochTime() bool {
+	return d.timestampFormat != "" && d.timestampFormat != "rfc3339"
+}
+
+// formatTime returns the time in TimestampFormat.
+// s is the rfc3339 string of t.
+func (d *DnstapFlatT) formatTime(t time.Time, s string) interface{} {
+	switch d.timestampFormat {
+	case "unix":
+		return t.Unix()
+	case "unix_milli":
+		return t.UnixNano() / int64(time.Millisecond)
+	case "unix_micro":
+		return t.UnixNano() / int64(time.Microsecond)
+	}
+	return s
 }
 
 func getName(labels []string, i int) string {
@@ -201,23 +307,28 @@ func getName(labels []string, i int) string {
 
 func (d *DnstapFlatT) ToMapString() map[string]interface{} {
 	res := map[string]interface{}{}
-	res["timestamp"] = d.Timestamp
-	res["query_time"] = d.QueryTime
+	res["timestamp"] = d.formatTime(d.timestamp, d.Timestamp)
+	if d.QueryTime != "" {
+		res["query_time"] = d.formatTime(d.queryTime, d.QueryTime)
+	}
 	if d.QueryAddress != nil {
 		res["query_address"] = d.QueryAddress.String()
 	}
 	res["query_address_hash"] = d.QueryAddressHash
 	res["query_port"] = int64(d.QueryPort)
-	res["response_time"] = d.ResponseTime
+	if d.ResponseTime != "" {
+		res["response_time"] = d.formatTime(d.responseTime, d.ResponseTime)
+	}
 	if d.ResponseAddress != nil {
 		res["response_address"] = d.ResponseAddress.String()
 	}
 	res["response_address_hash"] = d.ResponseAddressHash
+	res["salt_epoch"] = d.SaltEpoch
 
 	res["response_port"] = int64(d.ResponsePort)
 	res["response_zone"] = d.ResponseZone
-	if d.ResponseAddress != nil {
-		res["ResponseAddressHash"] = d.EcsNet
+	if d.EcsNet != nil {
+		res["ecs_net"] = d.EcsNet.String()
 	}
 
 	res["identity"] = d.Identity
@@ -239,6 +350,8 @@ func (d *DnstapFlatT) ToMapString() map[string]interface{} {
 	res["message_size"] = int64(d.MessageSize)
 	res["txid"] = int32(d.Txid)
 	res["rcode"] = d.Rcode
+	res["opcode"] = d.Opcode
+	res["edns_udp_size"] = int32(d.EdnsUDPSize)
 
 	res["aa"] = d.AA
 	res["tc"] = d.TC
@@ -247,5 +360,74 @@ func (d *DnstapFlatT) ToMapString() map[string]interface{} {
 	res["ad"] = d.AD
 	res["cd"] = d.CD
 
-	return res
+	if d.scored {
+		res["qname_entropy"] = d.QnameEntropy
+		res["longest_label"] = int32(d.LongestLabel)
+		res["digit_ratio"] = d.DigitRatio
+		res["consonant_run"] = int32(d.ConsonantRun)
+		res["domain_bytes"] = int64(d.DomainBytes)
+		res["suspicious"] = d.Suspicious
+		res["suspicious_reason"] = d.SuspiciousReason
+	}
+	if d.SampleRate != 0 {
+		res["sample_rate"] = d.SampleRate
+	}
+
+	return d.fields.Project(res)
+}
+
+// RegisteredDomain returns the qname's domain registered under the public suffix.
+func (d *DnstapFlatT) RegisteredDomain() string {
+	name := strings.TrimSuffix(strings.ToLower(d.Qname), ".")
+	if name == "" {
+		return "."
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return name + "."
+	}
+	return domain + "."
+}
+
+// ToLabelMap returns the string values keyed by the field names of DnstapFlatT.
+// It also includes RegisteredDomain.
+func (d *DnstapFlatT) ToLabelMap() map[string]string {
+	e := reflect.ValueOf(d).Elem()
+	m := make(map[string]string)
+	for i := 0; i < e.NumField(); i++ {
+		if e.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		field := e.Type().Field(i).Name
+		value := e.Field(i).Interface()
+		if value == nil {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			m[field] = v
+		case int:
+			m[field] = strconv.Itoa(v)
+		case int64:
+			m[field] = strconv.FormatInt(v, 10)
+		case uint64:
+			m[field] = strconv.FormatUint(v, 10)
+		case float64:
+			m[field] = strconv.FormatFloat(v, 'f', -1, 64)
+		case uint32:
+			m[field] = strconv.Itoa(int(v))
+		case uint16:
+			m[field] = strconv.Itoa(int(v))
+		case bool:
+			if v {
+				m[field] = "1"
+			} else {
+				m[field] = "0"
+			}
+		case fmt.Stringer:
+			m[field] = v.String()
+		}
+	}
+	m["RegisteredDomain"] = d.RegisteredDomain()
+	return m
 }
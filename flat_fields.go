@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FlatFieldNames is the list of field names of DnstapFlatT as written by JSON.
+var FlatFieldNames = flatFieldNames()
+
+func flatFieldNames() []string {
+	names := []string{}
+	t := reflect.TypeOf(DnstapFlatT{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := flatFieldName(t.Field(i)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func flatFieldName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// FlatFields selects, drops and renames fields of flattened messages
+// and adds constant fields to them.
+type FlatFields struct {
+	include   map[string]bool
+	exclude   map[string]bool
+	rename    map[string]string
+	constants map[string]string
+}
+
+// NewFlatFields makes FlatFields from config.
+// It returns nil when config changes nothing.
+func NewFlatFields(config *FlatFieldsConfig) (*FlatFields, error) {
+	if len(config.Include) == 0 && len(config.Exclude) == 0 &&
+		len(config.Rename) == 0 && len(config.Constants) == 0 {
+		return nil, nil
+	}
+	known := map[string]bool{}
+	for _, name := range FlatFieldNames {
+		known[name] = true
+	}
+	f := &FlatFields{
+		include:   map[string]bool{},
+		exclude:   map[string]bool{},
+		rename:    map[string]string{},
+		constants: map[string]string{},
+	}
+	for _, name := range config.Include {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown field in Include: %s", name)
+		}
+		f.include[name] = true
+	}
+	for _, name := range config.Exclude {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown field in Exclude: %s", name)
+		}
+		f.exclude[name] = true
+	}
+	for _, kv := range config.Rename {
+		from, to, err := splitFieldPair(kv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Rename: %w", err)
+		}
+		if !known[from] {
+			return nil, fmt.Errorf("unknown field in Rename: %s", from)
+		}
+		f.rename[from] = to
+	}
+	for _, kv := range config.Constants {
+		name, value, err := splitFieldPair(kv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Constants: %w", err)
+		}
+		f.constants[name] = value
+	}
+	return f, nil
+}
+
+func splitFieldPair(kv string) (string, string, error) {
+	i := strings.Index(kv, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("%s must be formatted as name=value", kv)
+	}
+	return kv[:i], kv[i+1:], nil
+}
+
+// Selected returns whether the field is written.
+func (f *FlatFields) Selected(name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !f.include[name] {
+		return false
+	}
+	return !f.exclude[name]
+}
+
+// Project applies the settings to the map keyed by field names.
+func (f *FlatFields) Project(m map[string]interface{}) map[string]interface{} {
+	if f == nil {
+		return m
+	}
+	res := make(map[string]interface{}, len(m)+len(f.constants))
+	for k, v := range m {
+		if !f.Selected(k) {
+			continue
+		}
+		if to, ok := f.rename[k]; ok {
+			k = to
+		}
+		res[k] = v
+	}
+	for k, v := range f.constants {
+		res[k] = v
+	}
+	return res
+}
+
+// Names returns the field names written for names in order.
+// Renamed fields keep the position and constant fields follow them.
+func (f *FlatFields) Names(names []string) []string {
+	if f == nil {
+		return append([]string{}, names...)
+	}
+	res := []string{}
+	for _, name := range names {
+		if !f.Selected(name) {
+			continue
+		}
+		if to, ok := f.rename[name]; ok {
+			name = to
+		}
+		res = append(res, name)
+	}
+	constants := make([]string, 0, len(f.constants))
+	for name := range f.constants {
+		constants = append(constants, name)
+	}
+	sort.Strings(constants)
+	return append(res, constants...)
+}
+
+// selected returns a copy of d whose fields not selected have zero value.
+// d itself keeps every field for the labels of the metrics outputs.
+func (d *DnstapFlatT) selected() *DnstapFlatT {
+	if d.fields == nil {
+		return d
+	}
+	c := *d
+	v := reflect.ValueOf(&c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := flatFieldName(t.Field(i))
+		if name != "" && !d.fields.Selected(name) {
+			v.Field(i).Set(reflect.Zero(t.Field(i).Type))
+		}
+	}
+	return &c
+}
+
+type dnstapFlatJSON DnstapFlatT
+
+func (d *DnstapFlatT) MarshalJSON() ([]byte, error) {
+	if d.fields == nil && !d.epochTime() {
+		return json.Marshal((*dnstapFlatJSON)(d))
+	}
+	buf, err := json.Marshal((*dnstapFlatJSON)(d))
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	if d.epochTime() {
+		m["timestamp"] = d.formatTime(d.timestamp, d.Timestamp)
+		if d.QueryTime != "" {
+			m["query_time"] = d.formatTime(d.queryTime, d.QueryTime)
+		}
+		if d.ResponseTime != "" {
+			m["response_time"] = d.formatTime(d.responseTime, d.ResponseTime)
+		}
+	}
+	return json.Marshal(d.fields.Project(m))
+}
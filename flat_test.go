@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func newTestDnstap(t *testing.T, msgType dnstap.Message_Type, qname string) *dnstap.Dnstap {
+	m := new(dns.Msg)
+	m.SetQuestion(qname, dns.TypeA)
+	bs, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dtType := dnstap.Dnstap_MESSAGE
+	family := dnstap.SocketFamily_INET
+	protocol := dnstap.SocketProtocol_UDP
+	sec := uint64(1600000000)
+	nsec := uint32(0)
+	port := uint32(53)
+	return &dnstap.Dnstap{
+		Type:     &dtType,
+		Identity: []byte("resolver1"),
+		Version:  []byte("test"),
+		Message: &dnstap.Message{
+			Type:            &msgType,
+			SocketFamily:    &family,
+			SocketProtocol:  &protocol,
+			QueryAddress:    net.ParseIP("192.168.0.1").To4(),
+			ResponseAddress: net.ParseIP("192.168.0.53").To4(),
+			QueryPort:       &port,
+			ResponsePort:    &port,
+			QueryTimeSec:    &sec,
+			QueryTimeNsec:   &nsec,
+			QueryMessage:    bs,
+		},
+	}
+}
+
+func TestFlatFields(t *testing.T) {
+	config := &dtap.FlatConfig{
+		Fields: dtap.FlatFieldsConfig{
+			Exclude:   []string{"extra", "version"},
+			Rename:    []string{"qname=query_name"},
+			Constants: []string{"Site=tokyo"},
+		},
+	}
+	assert.Nil(t, config.Validate())
+
+	data, err := dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp."), config)
+	assert.NoError(t, err)
+	// labels of the metrics outputs read the dropped fields
+	assert.Equal(t, "test", data.Version)
+	assert.Equal(t, "test", data.ToLabelMap()["Version"])
+	assert.Equal(t, "www.example.jp.", data.Qname)
+
+	m := data.ToMapString()
+	assert.NotContains(t, m, "version")
+	assert.NotContains(t, m, "extra")
+	assert.NotContains(t, m, "qname")
+	assert.Equal(t, "www.example.jp.", m["query_name"])
+	assert.Equal(t, "tokyo", m["Site"])
+
+	buf, err := json.Marshal(data)
+	assert.NoError(t, err)
+	j := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf, &j))
+	assert.NotContains(t, j, "version")
+	assert.NotContains(t, j, "qname")
+	assert.Equal(t, "www.example.jp.", j["query_name"])
+	assert.Equal(t, "tokyo", j["Site"])
+	assert.Equal(t, "resolver1", j["identity"])
+
+	config = &dtap.FlatConfig{
+		Fields: dtap.FlatFieldsConfig{
+			Include: []string{"qname", "qtype"},
+		},
+	}
+	data, err = dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp."), config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"qname": "www.example.jp.", "qtype": "A"}, data.ToMapString())
+
+	config = &dtap.FlatConfig{
+		Fields: dtap.FlatFieldsConfig{
+			Exclude: []string{"unknown"},
+		},
+	}
+	assert.NotNil(t, config.Validate())
+	_, err = dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp."), config)
+	assert.Error(t, err)
+}
+
+func TestFlatTimestamp(t *testing.T) {
+	// the default time zone is UTC
+	config := &dtap.FlatConfig{}
+	assert.Nil(t, config.Validate())
+	data, err := dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp."), config)
+	assert.NoError(t, err)
+	assert.Equal(t, "2020-09-13T12:26:40Z", data.Timestamp)
+	assert.Equal(t, "2020-09-13T12:26:40Z", data.QueryTime)
+	assert.Equal(t, "", data.ResponseTime)
+
+	config = &dtap.FlatConfig{TimestampFormat: "unix_milli"}
+	assert.Nil(t, config.Validate())
+	data, err = dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_UPDATE_RESPONSE, "www.example.jp."), config)
+	assert.NoError(t, err)
+	assert.Equal(t, "2020-09-13T12:26:40Z", data.Timestamp)
+	assert.Equal(t, int64(1600000000000), data.ToMapString()["timestamp"])
+	assert.Equal(t, int64(1600000000000), data.ToMapString()["query_time"])
+	buf, err := json.Marshal(data)
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), `"timestamp":1600000000000`)
+	assert.Contains(t, string(buf), `"query_time":1600000000000`)
+
+	config = &dtap.FlatConfig{Timezone: "Asia/Tokyo"}
+	assert.Nil(t, config.Validate())
+	data, err = dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp."), config)
+	assert.NoError(t, err)
+	assert.Equal(t, "2020-09-13T21:26:40+09:00", data.Timestamp)
+
+	config = &dtap.FlatConfig{TimestampFormat: "iso"}
+	assert.NotNil(t, config.Validate())
+	config = &dtap.FlatConfig{Timezone: "Unknown/Zone"}
+	assert.NotNil(t, config.Validate())
+}
+
+func TestFlatSuspicious(t *testing.T) {
+	config := &dtap.FlatConfig{Suspicious: dtap.FlatSuspiciousConfig{Enable: true}}
+	assert.Nil(t, config.Validate())
+	data, err := dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp."), config)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, data.LongestLabel)
+	assert.Equal(t, 0.0, data.DigitRatio)
+	assert.Equal(t, 3, data.ConsonantRun)
+	assert.False(t, data.Suspicious)
+	assert.Equal(t, false, data.ToMapString()["suspicious"])
+	size := data.MessageSize
+
+	data, err = dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "bcdfghz1234567890wq.example.jp."), config)
+	assert.NoError(t, err)
+	assert.True(t, data.QnameEntropy > 3.5)
+	assert.True(t, data.Suspicious)
+	assert.Equal(t, "entropy,digit_ratio,consonant_run", data.SuspiciousReason)
+	assert.Equal(t, uint64(size+data.MessageSize), data.DomainBytes)
+
+	config = &dtap.FlatConfig{}
+	data, err = dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "x9k2qz7vbnm4w8rt5yplk3j6hd.example.jp."), config)
+	assert.NoError(t, err)
+	assert.False(t, data.Suspicious)
+	assert.NotContains(t, data.ToMapString(), "suspicious")
+}
@@ -0,0 +1,314 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+)
+
+// rcodeNames maps rcode names to the values in the width of weighted.
+var rcodeNames = func() map[string]uint16 {
+	m := map[string]uint16{}
+	for name, rcode := range dns.StringToRcode {
+		m[name] = uint16(rcode)
+	}
+	return m
+}()
+
+// weighted picks values by the weights.
+type weighted struct {
+	values []uint16
+	cumsum []float64
+}
+
+// parseWeights parses NAME=weight settings by names.
+func parseWeights(settings []string, names map[string]uint16) (*weighted, error) {
+	w := &weighted{}
+	var sum float64
+	for _, kv := range settings {
+		name, value, err := splitFieldPair(kv)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := names[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown name: %s", name)
+		}
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("weight must be a positive number: %s", kv)
+		}
+		sum += weight
+		w.values = append(w.values, v)
+		w.cumsum = append(w.cumsum, sum)
+	}
+	if len(w.values) == 0 {
+		return nil, fmt.Errorf("no weights")
+	}
+	return w, nil
+}
+
+func (w *weighted) pick(r *rand.Rand) uint16 {
+	x := r.Float64() * w.cumsum[len(w.cumsum)-1]
+	return w.values[sort.SearchFloat64s(w.cumsum, x)]
+}
+
+// Generator makes synthetic dnstap messages by the profile.
+// The same seed makes the same messages for the same query times.
+// Responses are held until the queries sent before them are returned, so the messages are in time order.
+type Generator struct {
+	config    *InputGeneratorConfig
+	rand      *rand.Rand
+	zipf      *rand.Zipf
+	qnames    []string
+	qtypes    *weighted
+	rcodes    *weighted
+	prefix    *net.IPNet
+	prefix6   *net.IPNet
+	server    net.IP
+	server6   net.IP
+	queryType dnstap.Message_Type
+	respType  dnstap.Message_Type
+	pending   pendingResponses
+	seq       uint64
+}
+
+type pendingResponse struct {
+	t   time.Time
+	seq uint64
+	dt  *dnstap.Dnstap
+}
+
+// pendingResponses is the min-heap of responses by the response time.
+type pendingResponses []*pendingResponse
+
+func (h pendingResponses) Len() int { return len(h) }
+func (h pendingResponses) Less(i, j int) bool {
+	if h[i].t.Equal(h[j].t) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].t.Before(h[j].t)
+}
+func (h pendingResponses) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *pendingResponses) Push(x interface{}) { *h = append(*h, x.(*pendingResponse)) }
+func (h *pendingResponses) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+func NewGenerator(config *InputGeneratorConfig) (*Generator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	g := &Generator{
+		config:    config,
+		rand:      rand.New(rand.NewSource(config.Seed)),
+		server:    net.ParseIP(config.GetServer()).To4(),
+		server6:   net.ParseIP(config.GetServer6()),
+		queryType: dnstap.Message_Type(dnstap.Message_Type_value[config.GetType()+"_QUERY"]),
+		respType:  dnstap.Message_Type(dnstap.Message_Type_value[config.GetType()+"_RESPONSE"]),
+	}
+	g.qtypes, _ = parseWeights(config.GetQtypes(), dns.StringToType)
+	g.rcodes, _ = parseWeights(config.GetRcodes(), rcodeNames)
+	_, g.prefix, _ = net.ParseCIDR(config.GetClientPrefix())
+	_, g.prefix6, _ = net.ParseCIDR(config.GetClientPrefix6())
+
+	g.qnames = append([]string{}, config.Qnames...)
+	if config.QnamesPath != "" {
+		names, err := readQnames(config.QnamesPath)
+		if err != nil {
+			return nil, err
+		}
+		g.qnames = append(g.qnames, names...)
+	}
+	if len(g.qnames) == 0 {
+		for n := uint(0); n < config.GetNames(); n++ {
+			g.qnames = append(g.qnames, fmt.Sprintf("host%d.%s", n, config.GetZone()))
+		}
+	}
+	for n, name := range g.qnames {
+		g.qnames[n] = dns.Fqdn(name)
+	}
+	if config.GetDistribution() == "zipf" {
+		g.zipf = rand.NewZipf(g.rand, config.GetZipfS(), 1, uint64(len(g.qnames)-1))
+	}
+	return g, nil
+}
+
+func readQnames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open qnames file %s err: %w", path, err)
+	}
+	defer f.Close()
+	names := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, strings.Fields(line)[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read qnames file %s err: %w", path, err)
+	}
+	return names, nil
+}
+
+// clientAddress returns the n-th address of the prefix.
+func clientAddress(prefix *net.IPNet, n uint) net.IP {
+	ones, bits := prefix.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	offset := new(big.Int).Mod(big.NewInt(int64(n)+1), size)
+	ip := new(big.Int).SetBytes(prefix.IP)
+	ip.Add(ip, offset)
+	b := ip.Bytes()
+	res := make(net.IP, len(prefix.IP))
+	copy(res[len(res)-len(b):], b)
+	return res
+}
+
+// Next returns the responses sent until t and the query sent at t.
+// t must not go back, the response of the query is returned by the later calls or Flush.
+func (g *Generator) Next(t time.Time) []*dnstap.Dnstap {
+	var qname string
+	if g.zipf != nil {
+		qname = g.qnames[g.zipf.Uint64()]
+	} else {
+		qname = g.qnames[g.rand.Intn(len(g.qnames))]
+	}
+	qtype := g.qtypes.pick(g.rand)
+	rcode := int(g.rcodes.pick(g.rand))
+	client := uint(g.rand.Intn(int(g.config.GetClients())))
+	family := dnstap.SocketFamily_INET
+	queryAddress, responseAddress := clientAddress(g.prefix, client), g.server
+	if g.rand.Float64() < g.config.IPv6Ratio {
+		family = dnstap.SocketFamily_INET6
+		queryAddress, responseAddress = clientAddress(g.prefix6, client), g.server6
+	}
+	protocol := dnstap.SocketProtocol_UDP
+	if g.rand.Float64() < g.config.TCPRatio {
+		protocol = dnstap.SocketProtocol_TCP
+	}
+	queryPort, responsePort := uint32(1024+g.rand.Intn(64512)), uint32(53)
+	latency := time.Duration(g.rand.ExpFloat64() * g.config.GetLatencyMean() * float64(time.Millisecond))
+
+	q := new(dns.Msg)
+	q.SetQuestion(qname, qtype)
+	q.Id = uint16(g.rand.Intn(65536))
+	q.SetEdns0(1232, false)
+	r := new(dns.Msg)
+	r.SetRcode(q, rcode)
+	r.RecursionAvailable = g.queryType == dnstap.Message_CLIENT_QUERY
+	if rcode == dns.RcodeSuccess {
+		if rr := g.answer(qname, qtype); rr != nil {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	r.SetEdns0(1232, false)
+	query, _ := q.Pack()
+	response, _ := r.Pack()
+
+	res := []*dnstap.Dnstap{}
+	for len(g.pending) > 0 && !g.pending[0].t.After(t) {
+		res = append(res, heap.Pop(&g.pending).(*pendingResponse).dt)
+	}
+	newMessage := func(typ dnstap.Message_Type) *dnstap.Message {
+		return &dnstap.Message{
+			Type:            typ.Enum(),
+			SocketFamily:    family.Enum(),
+			SocketProtocol:  protocol.Enum(),
+			QueryAddress:    queryAddress,
+			ResponseAddress: responseAddress,
+			QueryPort:       &queryPort,
+			ResponsePort:    &responsePort,
+		}
+	}
+	qsec, qnsec := uint64(t.Unix()), uint32(t.Nanosecond())
+	if g.config.GetMessages() != "response" {
+		m := newMessage(g.queryType)
+		m.QueryTimeSec, m.QueryTimeNsec = &qsec, &qnsec
+		m.QueryMessage = query
+		res = append(res, g.dnstap(m))
+	}
+	if g.config.GetMessages() != "query" {
+		rt := t.Add(latency)
+		rsec, rnsec := uint64(rt.Unix()), uint32(rt.Nanosecond())
+		m := newMessage(g.respType)
+		m.QueryTimeSec, m.QueryTimeNsec = &qsec, &qnsec
+		m.ResponseTimeSec, m.ResponseTimeNsec = &rsec, &rnsec
+		m.ResponseMessage = response
+		g.seq++
+		heap.Push(&g.pending, &pendingResponse{t: rt, seq: g.seq, dt: g.dnstap(m)})
+	}
+	return res
+}
+
+// Flush returns the responses held by Next in time order.
+func (g *Generator) Flush() []*dnstap.Dnstap {
+	res := []*dnstap.Dnstap{}
+	for len(g.pending) > 0 {
+		res = append(res, heap.Pop(&g.pending).(*pendingResponse).dt)
+	}
+	return res
+}
+
+func (g *Generator) dnstap(m *dnstap.Message) *dnstap.Dnstap {
+	return &dnstap.Dnstap{
+		Type:     dnstap.Dnstap_MESSAGE.Enum(),
+		Identity: []byte(g.config.GetIdentity()),
+		Version:  []byte("dtap-gen"),
+		Message:  m,
+	}
+}
+
+// answer returns the answer of A and AAAA queries derived from qname.
+func (g *Generator) answer(qname string, qtype uint16) dns.RR {
+	hdr := dns.RR_Header{Name: qname, Rrtype: qtype, Class: dns.ClassINET, Ttl: 300}
+	h := hash64(qname)
+	switch qtype {
+	case dns.TypeA:
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(h))
+		return &dns.A{Hdr: hdr, A: ip}
+	case dns.TypeAAAA:
+		ip := make(net.IP, 16)
+		copy(ip, net.ParseIP("2001:db8::"))
+		binary.BigEndian.PutUint64(ip[8:], h)
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}
+	}
+	return nil
+}
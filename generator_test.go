@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"testing"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestGenerator(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	generate := func(config *dtap.InputGeneratorConfig) []*dnstap.Dnstap {
+		g, err := dtap.NewGenerator(config)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		res := []*dnstap.Dnstap{}
+		for n := 0; n < 100; n++ {
+			res = append(res, g.Next(start.Add(time.Duration(n)*time.Millisecond))...)
+		}
+		return append(res, g.Flush()...)
+	}
+	messageTime := func(dt *dnstap.Dnstap) time.Time {
+		m := dt.GetMessage()
+		if m.ResponseTimeSec != nil {
+			return time.Unix(int64(m.GetResponseTimeSec()), int64(m.GetResponseTimeNsec()))
+		}
+		return time.Unix(int64(m.GetQueryTimeSec()), int64(m.GetQueryTimeNsec()))
+	}
+
+	config := &dtap.InputGeneratorConfig{
+		Seed:   1,
+		Qnames: []string{"www.example.jp", "mail.example.jp"},
+		Qtypes: []string{"A=1"},
+		Rcodes: []string{"NXDOMAIN=1"},
+	}
+	a := generate(config)
+	b := generate(config)
+	if assert.Len(t, a, 200) && assert.Len(t, b, 200) {
+		for n := range a {
+			assert.True(t, proto.Equal(a[n], b[n]))
+		}
+	}
+	assert.Equal(t, []string{"www.example.jp", "mail.example.jp"}, config.Qnames)
+
+	// the messages are in time order, responses come after the later queries
+	for n := 1; n < len(a); n++ {
+		assert.False(t, messageTime(a[n]).Before(messageTime(a[n-1])))
+	}
+
+	var r *dnstap.Message
+	for _, dt := range a[1:] {
+		if dt.GetMessage().GetType() == dnstap.Message_CLIENT_RESPONSE && dt.GetMessage().GetQueryTimeSec() == 1600000000 && dt.GetMessage().GetQueryTimeNsec() == 0 {
+			r = dt.GetMessage()
+		}
+	}
+	if assert.NotNil(t, r) {
+		q := a[0].GetMessage()
+		assert.Equal(t, dnstap.Message_CLIENT_QUERY, q.GetType())
+		assert.Equal(t, dnstap.Message_CLIENT_RESPONSE, r.GetType())
+		assert.Equal(t, uint64(1600000000), q.GetQueryTimeSec())
+		assert.Equal(t, q.GetQueryAddress(), r.GetQueryAddress())
+		assert.Equal(t, []byte{192, 0, 2, 53}, r.GetResponseAddress())
+		assert.Equal(t, byte(10), q.GetQueryAddress()[0])
+		rt := time.Unix(int64(r.GetResponseTimeSec()), int64(r.GetResponseTimeNsec()))
+		assert.False(t, rt.Before(start))
+
+		qm, rm := new(dns.Msg), new(dns.Msg)
+		assert.NoError(t, qm.Unpack(q.GetQueryMessage()))
+		assert.NoError(t, rm.Unpack(r.GetResponseMessage()))
+		assert.Contains(t, []string{"www.example.jp.", "mail.example.jp."}, qm.Question[0].Name)
+		assert.Equal(t, dns.TypeA, qm.Question[0].Qtype)
+		assert.Equal(t, qm.Id, rm.Id)
+		assert.Equal(t, dns.RcodeNameError, rm.Rcode)
+	}
+
+	config = &dtap.InputGeneratorConfig{Type: "resolver", Messages: "query", IPv6Ratio: 1}
+	msgs := generate(config)
+	assert.Len(t, msgs, 100)
+	for _, dt := range msgs {
+		assert.Equal(t, dnstap.Message_RESOLVER_QUERY, dt.GetMessage().GetType())
+		assert.Equal(t, dnstap.SocketFamily_INET6, dt.GetMessage().GetSocketFamily())
+	}
+
+	_, err := dtap.NewGenerator(&dtap.InputGeneratorConfig{Qtypes: []string{"BOGUS=1"}})
+	assert.Error(t, err)
+}
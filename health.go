@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"fmt"
+	"time"
+)
+
+// InputStatus is the runtime status of an input.
+type InputStatus struct {
+	Name          string     `json:"name"`
+	Running       bool       `json:"running"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// HealthState is the state of the running inputs and outputs.
+type HealthState struct {
+	Started        time.Time      `json:"started"`
+	Inputs         []InputStatus  `json:"inputs"`
+	Outputs        []OutputStatus `json:"outputs"`
+	InputBufferLen int            `json:"input_buffer_len"`
+	InputBufferCap int            `json:"input_buffer_cap"`
+}
+
+// HealthStatus is the result of a health check.
+type HealthStatus struct {
+	Status        string       `json:"status"`
+	Failures      []string     `json:"failures,omitempty"`
+	LastFrameTime *time.Time   `json:"last_frame_time,omitempty"`
+	Details       *HealthState `json:"details,omitempty"`
+}
+
+func (s *HealthStatus) Healthy() bool {
+	return len(s.Failures) == 0
+}
+
+// Check returns the failures of the criteria at now.
+// The state is added as the details only on failure.
+func (o *HealthCheckConfig) Check(state *HealthState, now time.Time) *HealthStatus {
+	s := &HealthStatus{Status: "ok"}
+	running := 0
+	for _, i := range state.Inputs {
+		if i.Running {
+			running++
+		} else if o.Inputs == "all" {
+			s.Failures = append(s.Failures, fmt.Sprintf("%s is not running", i.Name))
+		}
+	}
+	if o.Inputs == "any" && running == 0 {
+		s.Failures = append(s.Failures, "no input is running")
+	}
+
+	connected := 0
+	var lastFrame time.Time
+	for _, out := range state.Outputs {
+		if out.Connected {
+			connected++
+		} else if o.Outputs == "all" {
+			s.Failures = append(s.Failures, fmt.Sprintf("%s is not connected", out.Name))
+		}
+		if out.LastFrameTime != nil && out.LastFrameTime.After(lastFrame) {
+			lastFrame = *out.LastFrameTime
+		}
+		if o.BufferFill > 0 && out.BufferCap > 0 && float64(out.BufferLen) >= o.BufferFill*float64(out.BufferCap) {
+			s.Failures = append(s.Failures, fmt.Sprintf("%s buffer is %d/%d full", out.Name, out.BufferLen, out.BufferCap))
+		}
+	}
+	if o.Outputs == "any" && connected == 0 {
+		s.Failures = append(s.Failures, "no output is connected")
+	}
+	if o.BufferFill > 0 && state.InputBufferCap > 0 && float64(state.InputBufferLen) >= o.BufferFill*float64(state.InputBufferCap) {
+		s.Failures = append(s.Failures, fmt.Sprintf("input buffer is %d/%d full", state.InputBufferLen, state.InputBufferCap))
+	}
+	idleSince := state.Started
+	if !lastFrame.IsZero() {
+		s.LastFrameTime = &lastFrame
+		if lastFrame.After(idleSince) {
+			idleSince = lastFrame
+		}
+	}
+	if o.IdleTimeout > 0 && now.Sub(idleSince) > time.Duration(o.IdleTimeout)*time.Second {
+		s.Failures = append(s.Failures, fmt.Sprintf("no frames for %s", now.Sub(idleSince).Truncate(time.Second)))
+	}
+
+	if !s.Healthy() {
+		s.Status = "fail"
+		s.Details = state
+	}
+	return s
+}
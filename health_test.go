@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestHealthConfig(t *testing.T) {
+	assert.Nil(t, (&dtap.HealthConfig{}).Validate())
+	assert.Error(t, (&dtap.HealthConfig{Liveness: dtap.HealthCheckConfig{Inputs: "some"}}).Validate())
+	assert.Error(t, (&dtap.HealthConfig{Readiness: dtap.HealthCheckConfig{Outputs: "some"}}).Validate())
+	assert.Error(t, (&dtap.HealthConfig{Readiness: dtap.HealthCheckConfig{BufferFill: 1.5}}).Validate())
+
+	c := &dtap.HealthConfig{}
+	assert.Equal(t, &dtap.HealthCheckConfig{Inputs: "all", Outputs: "none"}, c.GetLiveness())
+	assert.Equal(t, &dtap.HealthCheckConfig{Inputs: "all", Outputs: "any"}, c.GetReadiness())
+}
+
+func TestHealthCheck(t *testing.T) {
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	lastFrame := now.Add(-30 * time.Second)
+	state := &dtap.HealthState{
+		Started: now.Add(-time.Hour),
+		Inputs: []dtap.InputStatus{
+			{Name: "InputTCP[0]", Running: true},
+			{Name: "InputUnix[0]", Running: false},
+		},
+		Outputs: []dtap.OutputStatus{
+			{Name: "OutputFile[0]", Connected: true, BufferLen: 10, BufferCap: 100, LastFrameTime: &lastFrame},
+			{Name: "OutputNats[0]", Connected: false, LastError: "no servers", BufferLen: 95, BufferCap: 100},
+		},
+		InputBufferLen: 10,
+		InputBufferCap: 1024,
+	}
+
+	s := (&dtap.HealthCheckConfig{Inputs: "any", Outputs: "any"}).Check(state, now)
+	assert.True(t, s.Healthy())
+	assert.Equal(t, "ok", s.Status)
+	assert.Equal(t, &lastFrame, s.LastFrameTime)
+	assert.Nil(t, s.Details)
+
+	s = (&dtap.HealthCheckConfig{Inputs: "all", Outputs: "all", BufferFill: 0.9, IdleTimeout: 10}).Check(state, now)
+	assert.False(t, s.Healthy())
+	assert.Equal(t, "fail", s.Status)
+	assert.Equal(t, []string{
+		"InputUnix[0] is not running",
+		"OutputNats[0] is not connected",
+		"OutputNats[0] buffer is 95/100 full",
+		"no frames for 30s",
+	}, s.Failures)
+	assert.Equal(t, state, s.Details)
+
+	// without frames the idle time is from the start
+	state.Outputs = []dtap.OutputStatus{{Name: "OutputFile[0]", Connected: true}}
+	s = (&dtap.HealthCheckConfig{IdleTimeout: 10}).Check(state, now)
+	assert.Equal(t, []string{"no frames for 1h0m0s"}, s.Failures)
+	state.Started = now.Add(-5 * time.Second)
+	assert.True(t, (&dtap.HealthCheckConfig{IdleTimeout: 10}).Check(state, now).Healthy())
+
+	state.Outputs[0].Connected = false
+	s = (&dtap.HealthCheckConfig{Outputs: "any"}).Check(state, now)
+	assert.Equal(t, []string{"no output is connected"}, s.Failures)
+	assert.True(t, (&dtap.HealthCheckConfig{Outputs: "none"}).Check(state, now).Healthy())
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// HyperLogLog estimates the number of distinct values.
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	return &HyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// splitmix64 finalizer
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (h *HyperLogLog) Add(s string) {
+	x := hash64(s)
+	idx := x >> (64 - h.precision)
+	rank := uint8(bits.LeadingZeros64(x<<h.precision|1<<(h.precision-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Count returns the estimated number of distinct values.
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+	var sum float64
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// Merge adds the values of other to h.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h.precision != other.precision {
+		return fmt.Errorf("can't merge HyperLogLog precision %d and %d", h.precision, other.precision)
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	return append([]byte{h.precision}, h.registers...), nil
+}
+
+func (h *HyperLogLog) UnmarshalBinary(b []byte) error {
+	if len(b) < 1 || b[0] < 4 || b[0] > 18 || len(b) != 1+1<<b[0] {
+		return fmt.Errorf("invalid HyperLogLog data")
+	}
+	h.precision = b[0]
+	h.registers = append([]uint8{}, b[1:]...)
+	return nil
+}
+
+// HyperLogLogSnapshot is the persisted sketches of a window.
+// Snapshots of several dtap instances are combined by MergeHyperLogLogSnapshots
+// or the unique-merge subcommand.
+type HyperLogLogSnapshot struct {
+	Name        string                      `json:"name"`
+	Labels      []string                    `json:"labels"`
+	WindowStart int64                       `json:"window_start"`
+	Window      uint                        `json:"window"`
+	Sketches    []HyperLogLogSnapshotSketch `json:"sketches"`
+}
+
+type HyperLogLogSnapshotSketch struct {
+	Values []string `json:"values"`
+	Count  uint64   `json:"count"`
+	Sketch []byte   `json:"sketch"`
+}
+
+// ReadHyperLogLogSnapshot reads the snapshot persisted by the Unique output.
+func ReadHyperLogLogSnapshot(filename string) (*HyperLogLogSnapshot, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s err: %w", filename, err)
+	}
+	snapshot := &HyperLogLogSnapshot{}
+	if err := json.Unmarshal(buf, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s err: %w", filename, err)
+	}
+	return snapshot, nil
+}
+
+// MergeHyperLogLogSnapshots merges the sketches which have the same label values.
+// The snapshots must have the same Name, Labels, WindowStart and Window.
+func MergeHyperLogLogSnapshots(snapshots ...*HyperLogLogSnapshot) (*HyperLogLogSnapshot, error) {
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("no snapshots")
+	}
+	first := snapshots[0]
+	for _, snapshot := range snapshots[1:] {
+		switch {
+		case snapshot.Name != first.Name:
+			return nil, fmt.Errorf("can't merge snapshots of %s and %s", first.Name, snapshot.Name)
+		case strings.Join(snapshot.Labels, ",") != strings.Join(first.Labels, ","):
+			return nil, fmt.Errorf("can't merge snapshots of labels [%s] and [%s]",
+				strings.Join(first.Labels, ","), strings.Join(snapshot.Labels, ","))
+		case snapshot.WindowStart != first.WindowStart || snapshot.Window != first.Window:
+			return nil, fmt.Errorf("can't merge snapshots of window %d+%d and %d+%d",
+				first.WindowStart, first.Window, snapshot.WindowStart, snapshot.Window)
+		}
+	}
+	res := &HyperLogLogSnapshot{
+		Name:        snapshots[0].Name,
+		Labels:      snapshots[0].Labels,
+		WindowStart: snapshots[0].WindowStart,
+		Window:      snapshots[0].Window,
+	}
+	keys := []string{}
+	values := map[string][]string{}
+	sketches := map[string]*HyperLogLog{}
+	for _, snapshot := range snapshots {
+		for _, s := range snapshot.Sketches {
+			h := &HyperLogLog{}
+			if err := h.UnmarshalBinary(s.Sketch); err != nil {
+				return nil, err
+			}
+			key := fmt.Sprintf("%q", s.Values)
+			if merged, ok := sketches[key]; ok {
+				if err := merged.Merge(h); err != nil {
+					return nil, err
+				}
+			} else {
+				keys = append(keys, key)
+				values[key] = s.Values
+				sketches[key] = h
+			}
+		}
+	}
+	for _, key := range keys {
+		b, _ := sketches[key].MarshalBinary()
+		res.Sketches = append(res.Sketches, HyperLogLogSnapshotSketch{
+			Values: values[key],
+			Count:  sketches[key].Count(),
+			Sketch: b,
+		})
+	}
+	return res, nil
+}
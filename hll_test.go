@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	h := dtap.NewHyperLogLog(12)
+	for i := 0; i < 100000; i++ {
+		h.Add(strconv.Itoa(i % 50000))
+	}
+	assert.InEpsilon(t, 50000, float64(h.Count()), 0.05)
+
+	small := dtap.NewHyperLogLog(12)
+	for i := 0; i < 100; i++ {
+		small.Add(strconv.Itoa(i))
+	}
+	assert.InEpsilon(t, 100, float64(small.Count()), 0.05)
+
+	b, err := h.MarshalBinary()
+	assert.NoError(t, err)
+	restored := &dtap.HyperLogLog{}
+	assert.NoError(t, restored.UnmarshalBinary(b))
+	assert.Equal(t, h.Count(), restored.Count())
+	assert.Error(t, restored.UnmarshalBinary(b[:10]))
+}
+
+func TestMergeHyperLogLogSnapshots(t *testing.T) {
+	newSnapshot := func(from, to int) *dtap.HyperLogLogSnapshot {
+		h := dtap.NewHyperLogLog(12)
+		for i := from; i < to; i++ {
+			h.Add(strconv.Itoa(i))
+		}
+		b, _ := h.MarshalBinary()
+		return &dtap.HyperLogLogSnapshot{
+			Name:     "dtap_unique_clients",
+			Labels:   []string{"Identity"},
+			Sketches: []dtap.HyperLogLogSnapshotSketch{{Values: []string{"ns1"}, Sketch: b}},
+		}
+	}
+	merged, err := dtap.MergeHyperLogLogSnapshots(newSnapshot(0, 20000), newSnapshot(10000, 30000))
+	assert.NoError(t, err)
+	assert.Len(t, merged.Sketches, 1)
+	assert.InEpsilon(t, 30000, float64(merged.Sketches[0].Count), 0.05)
+
+	other := newSnapshot(0, 10)
+	other.Name = "dtap_unique_qnames"
+	_, err = dtap.MergeHyperLogLogSnapshots(newSnapshot(0, 10), other)
+	assert.Error(t, err)
+	other = newSnapshot(0, 10)
+	other.Labels = []string{"Qtype"}
+	_, err = dtap.MergeHyperLogLogSnapshots(newSnapshot(0, 10), other)
+	assert.Error(t, err)
+	other = newSnapshot(0, 10)
+	other.WindowStart = 300
+	_, err = dtap.MergeHyperLogLogSnapshots(newSnapshot(0, 10), other)
+	assert.Error(t, err)
+}
@@ -39,6 +39,22 @@ type OutputHandler interface {
 	write([]byte) error
 	close()
 }
+
+// outputFlusher is the OutputHandler which can flush the buffered data.
+type outputFlusher interface {
+	flush() error
+}
+
+// outputRotater is the OutputHandler which can reopen the output file.
+type outputRotater interface {
+	rotate() error
+}
+
+// outputReleaser is the OutputHandler which holds resources before open such as listeners.
+type outputReleaser interface {
+	release()
+}
+
 type SocketOutput interface {
 	newConnect() (*framestream.Encoder, error)
 }
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"container/heap"
+	"io"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+)
+
+// FrameSource returns the next frame, io.EOF at the end.
+type FrameSource func() ([]byte, error)
+
+type mergeHead struct {
+	frame  []byte
+	t      time.Time
+	source int
+	seq    uint64
+}
+
+type mergeHeap []*mergeHead
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].t.Equal(h[j].t) {
+		if h[i].source == h[j].source {
+			return h[i].seq < h[j].seq
+		}
+		return h[i].source < h[j].source
+	}
+	return h[i].t.Before(h[j].t)
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeHead)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// MergeFrames calls fn with the frames of the sources in the order of the message time.
+// The sources needn't be ordered, such as responses written after later queries.
+// Frames are held until a frame later than window after them is read, and a frame
+// older than the written ones by more than window is written as soon as it is read.
+// Frames without time or unparsable frames take the time of the previous frame of the source.
+// It returns the number of frames written out of order.
+func MergeFrames(sources []FrameSource, window time.Duration, fn func(frame []byte, t time.Time) error) (uint64, error) {
+	h := &mergeHeap{}
+	last := make([]time.Time, len(sources))
+	var seq uint64
+	next := func(n int) error {
+		frame, err := sources[n]()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dt := &dnstap.Dnstap{}
+		if err := proto.Unmarshal(frame, dt); err == nil {
+			if t := MessageTime(dt.GetMessage()); !t.IsZero() {
+				last[n] = t
+			}
+		}
+		seq++
+		heap.Push(h, &mergeHead{frame: frame, t: last[n], source: n, seq: seq})
+		return nil
+	}
+	for n := range sources {
+		if err := next(n); err != nil {
+			return 0, err
+		}
+	}
+
+	// reorder holds the frames of the last window
+	reorder := &mergeHeap{}
+	var latest, written time.Time
+	var inversions uint64
+	write := func(head *mergeHead) error {
+		if head.t.Before(written) {
+			inversions++
+		} else {
+			written = head.t
+		}
+		return fn(head.frame, head.t)
+	}
+	for h.Len() > 0 {
+		head := heap.Pop(h).(*mergeHead)
+		if err := next(head.source); err != nil {
+			return inversions, err
+		}
+		if head.t.After(latest) {
+			latest = head.t
+		}
+		heap.Push(reorder, head)
+		for reorder.Len() > 0 && (*reorder)[0].t.Add(window).Before(latest) {
+			if err := write(heap.Pop(reorder).(*mergeHead)); err != nil {
+				return inversions, err
+			}
+		}
+	}
+	for reorder.Len() > 0 {
+		if err := write(heap.Pop(reorder).(*mergeHead)); err != nil {
+			return inversions, err
+		}
+	}
+	return inversions, nil
+}
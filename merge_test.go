@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func newTestFrame(t *testing.T, qname string, sec uint64) []byte {
+	dt := newTestDnstap(t, dnstap.Message_CLIENT_QUERY, qname)
+	dt.Message.QueryTimeSec = &sec
+	frame, err := proto.Marshal(dt)
+	assert.NoError(t, err)
+	return frame
+}
+
+func newTestFrameSource(frames ...[]byte) dtap.FrameSource {
+	return func() ([]byte, error) {
+		if len(frames) == 0 {
+			return nil, io.EOF
+		}
+		frame := frames[0]
+		frames = frames[1:]
+		return frame, nil
+	}
+}
+
+func TestMergeFrames(t *testing.T) {
+	a1, a3 := newTestFrame(t, "a1.example.jp.", 1), newTestFrame(t, "a3.example.jp.", 3)
+	b2, b3 := newTestFrame(t, "b2.example.jp.", 2), newTestFrame(t, "b3.example.jp.", 3)
+	untimed := newTestFrame(t, "untimed.example.jp.", 0)
+	sources := []dtap.FrameSource{
+		newTestFrameSource(a1, a3),
+		newTestFrameSource(b2, untimed, b3),
+		newTestFrameSource(),
+	}
+	frames := [][]byte{}
+	times := []int64{}
+	inversions, err := dtap.MergeFrames(sources, 0, func(frame []byte, ts time.Time) error {
+		frames = append(frames, frame)
+		times = append(times, ts.Unix())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), inversions)
+	assert.Equal(t, [][]byte{a1, b2, untimed, a3, b3}, frames)
+	assert.Equal(t, []int64{1, 2, 2, 3, 3}, times)
+
+	// the sources aren't ordered, the frames within the window are sorted
+	a5, a2 := newTestFrame(t, "a5.example.jp.", 5), newTestFrame(t, "a2.example.jp.", 2)
+	b4, b1 := newTestFrame(t, "b4.example.jp.", 4), newTestFrame(t, "b1.example.jp.", 1)
+	merge := func(window time.Duration) ([]int64, uint64) {
+		times := []int64{}
+		sources := []dtap.FrameSource{
+			newTestFrameSource(a1, a3, a2, a5),
+			newTestFrameSource(b3, b4, b1),
+		}
+		inversions, err := dtap.MergeFrames(sources, window, func(frame []byte, ts time.Time) error {
+			times = append(times, ts.Unix())
+			return nil
+		})
+		assert.NoError(t, err)
+		return times, inversions
+	}
+	times, inversions = merge(3 * time.Second)
+	assert.Equal(t, []int64{1, 1, 2, 3, 3, 4, 5}, times)
+	assert.Equal(t, uint64(0), inversions)
+	times, inversions = merge(time.Second)
+	assert.Len(t, times, 7)
+	assert.Equal(t, uint64(1), inversions)
+}
+
+func TestFstrmFileWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtap-test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	frames := [][]byte{newTestFrame(t, "a.example.jp.", 1), newTestFrame(t, "b.example.jp.", 2)}
+	for _, name := range []string{"test.fstrm", "test.fstrm.gz", "test.fstrm.xz"} {
+		path := filepath.Join(dir, name)
+		w, err := dtap.NewFstrmFileWriter(path)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var size int64
+		for _, frame := range frames {
+			assert.NoError(t, w.Write(frame))
+			size += int64(len(frame)) + 4
+		}
+		assert.Equal(t, size, w.Size())
+		assert.NoError(t, w.Close())
+
+		i, err := dtap.NewDnstapFstrmFileInput(&dtap.InputFileConfig{Path: path})
+		if !assert.NoError(t, err) {
+			continue
+		}
+		for _, frame := range frames {
+			read, err := i.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, frame, read)
+		}
+		_, err = i.Next()
+		assert.Equal(t, io.EOF, err)
+		i.Close()
+	}
+	_, err = dtap.NewFstrmFileWriter(filepath.Join(dir, "test.fstrm.bz2"))
+	assert.Error(t, err)
+}
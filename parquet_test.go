@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestFlatParquetSchema(t *testing.T) {
+	schema, err := dtap.FlatParquetSchema()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	buf := &bytes.Buffer{}
+	w, err := writer.NewJSONWriterFromWriter(schema, buf, 1)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for _, qname := range []string{"www.example.jp.", "mail.example.jp."} {
+		data, err := dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, qname), &dtap.FlatConfig{})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		row, err := json.Marshal(data.ToMapString())
+		assert.NoError(t, err)
+		assert.NoError(t, w.Write(string(row)))
+	}
+	assert.NoError(t, w.WriteStop())
+
+	f, err := buffer.NewBufferFile(buf.Bytes())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	r, err := reader.NewParquetReader(f, nil, 1)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer r.ReadStop()
+	assert.Equal(t, int64(2), r.GetNumRows())
+	rows, err := r.ReadByNumber(2)
+	assert.NoError(t, err)
+	b, err := json.Marshal(rows)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"Qname":"mail.example.jp."`)
+	assert.Contains(t, string(b), `"Qtype":"A"`)
+}
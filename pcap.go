@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+)
+
+const (
+	pcapLinkNull     = 0
+	pcapLinkEthernet = 1
+	pcapLinkRaw      = 101
+	pcapLinkLoop     = 108
+	pcapLinkLinuxSLL = 113
+	pcapLinkIPv4     = 228
+	pcapLinkIPv6     = 229
+
+	dnsHeaderSize = 12
+)
+
+var ErrPcapNG = errors.New("pcapng isn't supported, convert it to pcap")
+
+// PcapReader reads the DNS messages of classic pcap files as dnstap messages.
+// UDP datagrams and TCP segments carrying whole messages are read,
+// IP fragments and messages split over TCP segments are skipped.
+type PcapReader struct {
+	// Identity is the identity of the dnstap messages.
+	Identity []byte
+	// QueryType and ResponseType are the message types of queries and responses.
+	QueryType    dnstap.Message_Type
+	ResponseType dnstap.Message_Type
+	// Port is the port of the DNS server.
+	Port uint16
+
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+}
+
+// NewPcapReader reads the pcap header of r.
+// Messages are read as CLIENT_QUERY and CLIENT_RESPONSE of port 53 by default.
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+	p := &PcapReader{
+		QueryType:    dnstap.Message_CLIENT_QUERY,
+		ResponseType: dnstap.Message_CLIENT_RESPONSE,
+		Port:         53,
+		r:            r,
+	}
+	switch binary.LittleEndian.Uint32(hdr) {
+	case 0xa1b2c3d4:
+		p.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		p.order, p.nano = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		p.order = binary.BigEndian
+	case 0x4d3cb2a1:
+		p.order, p.nano = binary.BigEndian, true
+	case 0x0a0d0d0a:
+		return nil, ErrPcapNG
+	default:
+		return nil, errors.New("not a pcap file")
+	}
+	p.linkType = p.order.Uint32(hdr[20:]) & 0x0fffffff
+	switch p.linkType {
+	case pcapLinkNull, pcapLinkEthernet, pcapLinkRaw, pcapLinkLoop, pcapLinkLinuxSLL, pcapLinkIPv4, pcapLinkIPv6:
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type: %d", p.linkType)
+	}
+	return p, nil
+}
+
+// Next returns the next DNS message.
+// It returns io.EOF at the end of the file.
+func (p *PcapReader) Next() (*dnstap.Dnstap, error) {
+	hdr := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(p.r, hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("truncated pcap record header: %w", err)
+			}
+			return nil, err
+		}
+		sec := p.order.Uint32(hdr)
+		frac := p.order.Uint32(hdr[4:])
+		caplen := p.order.Uint32(hdr[8:])
+		if caplen > 0x4000000 {
+			return nil, fmt.Errorf("invalid pcap record length: %d", caplen)
+		}
+		data := make([]byte, caplen)
+		if _, err := io.ReadFull(p.r, data); err != nil {
+			return nil, fmt.Errorf("truncated pcap record: %w", err)
+		}
+		nsec := frac
+		if !p.nano {
+			nsec = frac * 1000
+		}
+		if dt := p.decode(data, uint64(sec), nsec); dt != nil {
+			return dt, nil
+		}
+	}
+}
+
+// decode returns nil if the packet isn't a DNS message.
+func (p *PcapReader) decode(data []byte, sec uint64, nsec uint32) *dnstap.Dnstap {
+	ip, ok := p.network(data)
+	if !ok || len(ip) == 0 {
+		return nil
+	}
+	var family dnstap.SocketFamily
+	var src, dst net.IP
+	var proto byte
+	var payload []byte
+	switch ip[0] >> 4 {
+	case 4:
+		if len(ip) < 20 {
+			return nil
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		// skip fragments
+		if binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 {
+			return nil
+		}
+		if ihl < 20 || total < ihl || total > len(ip) {
+			return nil
+		}
+		family = dnstap.SocketFamily_INET
+		proto = ip[9]
+		src, dst = net.IP(ip[12:16]), net.IP(ip[16:20])
+		payload = ip[ihl:total]
+	case 6:
+		if len(ip) < 40 {
+			return nil
+		}
+		total := 40 + int(binary.BigEndian.Uint16(ip[4:]))
+		if total > len(ip) {
+			return nil
+		}
+		family = dnstap.SocketFamily_INET6
+		proto = ip[6]
+		src, dst = net.IP(ip[8:24]), net.IP(ip[24:40])
+		payload = ip[40:total]
+		// hop-by-hop, routing and destination options
+		for proto == 0 || proto == 43 || proto == 60 {
+			if len(payload) < 8 || len(payload) < (int(payload[1])+1)*8 {
+				return nil
+			}
+			proto, payload = payload[0], payload[(int(payload[1])+1)*8:]
+		}
+	default:
+		return nil
+	}
+
+	var protocol dnstap.SocketProtocol
+	var sport, dport uint16
+	var msg []byte
+	switch proto {
+	case 17:
+		if len(payload) < 8 {
+			return nil
+		}
+		protocol = dnstap.SocketProtocol_UDP
+		sport, dport = binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])
+		msg = payload[8:]
+	case 6:
+		if len(payload) < 20 {
+			return nil
+		}
+		off := int(payload[12]>>4) * 4
+		if off < 20 || off > len(payload) {
+			return nil
+		}
+		protocol = dnstap.SocketProtocol_TCP
+		sport, dport = binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])
+		seg := payload[off:]
+		if len(seg) < 2 || int(binary.BigEndian.Uint16(seg))+2 != len(seg) {
+			return nil
+		}
+		msg = seg[2:]
+	default:
+		return nil
+	}
+	if len(msg) < dnsHeaderSize || (sport != p.Port && dport != p.Port) {
+		return nil
+	}
+
+	msg = append([]byte{}, msg...)
+	dtType := dnstap.Dnstap_MESSAGE
+	m := &dnstap.Message{
+		SocketFamily:   &family,
+		SocketProtocol: &protocol,
+	}
+	// QR bit
+	if msg[2]&0x80 == 0 {
+		qport, rport := uint32(sport), uint32(dport)
+		m.Type = p.QueryType.Enum()
+		m.QueryAddress, m.ResponseAddress = append([]byte{}, src...), append([]byte{}, dst...)
+		m.QueryPort, m.ResponsePort = &qport, &rport
+		m.QueryTimeSec, m.QueryTimeNsec = &sec, &nsec
+		m.QueryMessage = msg
+	} else {
+		qport, rport := uint32(dport), uint32(sport)
+		m.Type = p.ResponseType.Enum()
+		m.QueryAddress, m.ResponseAddress = append([]byte{}, dst...), append([]byte{}, src...)
+		m.QueryPort, m.ResponsePort = &qport, &rport
+		m.ResponseTimeSec, m.ResponseTimeNsec = &sec, &nsec
+		m.ResponseMessage = msg
+	}
+	return &dnstap.Dnstap{
+		Type:     &dtType,
+		Identity: p.Identity,
+		Message:  m,
+	}
+}
+
+// network returns the IP packet of the link layer frame.
+func (p *PcapReader) network(data []byte) ([]byte, bool) {
+	switch p.linkType {
+	case pcapLinkNull, pcapLinkLoop:
+		if len(data) < 4 {
+			return nil, false
+		}
+		return data[4:], true
+	case pcapLinkEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType, data := binary.BigEndian.Uint16(data[12:]), data[14:]
+		// 802.1Q and 802.1ad tags
+		for etherType == 0x8100 || etherType == 0x88a8 {
+			if len(data) < 4 {
+				return nil, false
+			}
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+		return data, etherType == 0x0800 || etherType == 0x86dd
+	case pcapLinkLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(data[14:])
+		return data[16:], etherType == 0x0800 || etherType == 0x86dd
+	}
+	return data, true
+}
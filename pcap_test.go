@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func pcapRecord(buf *bytes.Buffer, sec, usec uint32, packet []byte) {
+	hdr := make([]byte, 16)
+	binary.LittleEndian.PutUint32(hdr, sec)
+	binary.LittleEndian.PutUint32(hdr[4:], usec)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(packet)))
+	buf.Write(hdr)
+	buf.Write(packet)
+}
+
+func ethernetIPv4UDP(src, dst net.IP, sport, dport uint16, payload []byte) []byte {
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp, sport)
+	binary.BigEndian.PutUint16(udp[2:], dport)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+	ip[8] = 64
+	ip[9] = 17
+	copy(ip[12:], src.To4())
+	copy(ip[16:], dst.To4())
+
+	eth := make([]byte, 14)
+	binary.BigEndian.PutUint16(eth[12:], 0x0800)
+	return append(append(eth, ip...), udp...)
+}
+
+func TestPcapReader(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.jp.", dns.TypeA)
+	query, err := q.Pack()
+	assert.NoError(t, err)
+	r := new(dns.Msg)
+	r.SetReply(q)
+	response, err := r.Pack()
+	assert.NoError(t, err)
+
+	client, server := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.53")
+	buf := &bytes.Buffer{}
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr, 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:], 1)
+	buf.Write(hdr)
+	pcapRecord(buf, 1600000000, 1000, ethernetIPv4UDP(client, server, 10053, 53, query))
+	pcapRecord(buf, 1600000000, 2000, ethernetIPv4UDP(client, server, 10053, 123, query))
+	pcapRecord(buf, 1600000000, 3000, ethernetIPv4UDP(server, client, 53, 10053, response))
+
+	p, err := dtap.NewPcapReader(buf)
+	assert.NoError(t, err)
+	p.Identity = []byte("pcap")
+
+	dt, err := p.Next()
+	assert.NoError(t, err)
+	msg := dt.GetMessage()
+	assert.Equal(t, "pcap", string(dt.GetIdentity()))
+	assert.Equal(t, dnstap.Message_CLIENT_QUERY, msg.GetType())
+	assert.Equal(t, dnstap.SocketProtocol_UDP, msg.GetSocketProtocol())
+	assert.Equal(t, "192.0.2.1", net.IP(msg.GetQueryAddress()).String())
+	assert.Equal(t, uint32(10053), msg.GetQueryPort())
+	assert.Equal(t, uint32(53), msg.GetResponsePort())
+	assert.Equal(t, uint32(1000000), msg.GetQueryTimeNsec())
+	assert.Equal(t, query, msg.GetQueryMessage())
+
+	// the message of port 123 is skipped
+	dt, err = p.Next()
+	assert.NoError(t, err)
+	msg = dt.GetMessage()
+	assert.Equal(t, dnstap.Message_CLIENT_RESPONSE, msg.GetType())
+	assert.Equal(t, "192.0.2.1", net.IP(msg.GetQueryAddress()).String())
+	assert.Equal(t, "192.0.2.53", net.IP(msg.GetResponseAddress()).String())
+	assert.Equal(t, uint64(1600000000), msg.GetResponseTimeSec())
+	assert.Equal(t, response, msg.GetResponseMessage())
+
+	_, err = p.Next()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = dtap.NewPcapReader(bytes.NewReader([]byte{0x0a, 0x0d, 0x0d, 0x0a, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}))
+	assert.Equal(t, dtap.ErrPcapNG, err)
+}
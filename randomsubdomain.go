@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	randomSubdomainPrecision     = 10
+	randomSubdomainBaselineAlpha = 0.1
+)
+
+type RandomSubdomainEvent struct {
+	Time         string   `json:"time" msg:"time"`
+	Domain       string   `json:"domain" msg:"domain"`
+	State        string   `json:"state" msg:"state"`
+	Reasons      []string `json:"reasons,omitempty" msg:"reasons"`
+	Queries      uint64   `json:"queries" msg:"queries"`
+	Responses    uint64   `json:"responses" msg:"responses"`
+	UniqueLabels uint64   `json:"unique_labels" msg:"unique_labels"`
+	UniqueRatio  float64  `json:"unique_ratio" msg:"unique_ratio"`
+	ErrorRatio   float64  `json:"error_ratio" msg:"error_ratio"`
+	Baseline     float64  `json:"baseline" msg:"baseline"`
+}
+
+type randomSubdomainStats struct {
+	queries   uint64
+	responses uint64
+	errors    uint64
+	labels    *HyperLogLog
+}
+
+// RandomSubdomainDetector tracks the queries per registered domain over the sliding window
+// made of slots, and flags the domains which look like under a random subdomain attack.
+type RandomSubdomainDetector struct {
+	config   *OutputRandomSubdomainConfig
+	slots    []map[string]*randomSubdomainStats
+	current  int
+	baseline map[string]float64
+	flagged  map[string]bool
+}
+
+func NewRandomSubdomainDetector(config *OutputRandomSubdomainConfig) *RandomSubdomainDetector {
+	d := &RandomSubdomainDetector{
+		config:   config,
+		slots:    make([]map[string]*randomSubdomainStats, config.GetSlots()),
+		baseline: map[string]float64{},
+		flagged:  map[string]bool{},
+	}
+	for i := range d.slots {
+		d.slots[i] = map[string]*randomSubdomainStats{}
+	}
+	return d
+}
+
+// Add counts the query or response.
+// New domains are ignored when the current slot already has MaxDomains domains.
+func (d *RandomSubdomainDetector) Add(data *DnstapFlatT) {
+	domain := data.RegisteredDomain()
+	slot := d.slots[d.current]
+	s, ok := slot[domain]
+	if !ok {
+		if uint(len(slot)) >= d.config.GetMaxDomains() {
+			return
+		}
+		s = &randomSubdomainStats{labels: NewHyperLogLog(randomSubdomainPrecision)}
+		slot[domain] = s
+	}
+	if strings.HasSuffix(data.Type, "_QUERY") {
+		s.queries++
+		qname := strings.ToLower(data.Qname)
+		s.labels.Add(strings.TrimSuffix(qname, domain))
+		return
+	}
+	s.responses++
+	if data.Rcode == "NXDOMAIN" || data.Rcode == "SERVFAIL" {
+		s.errors++
+	}
+}
+
+// Rotate drops the oldest slot.
+func (d *RandomSubdomainDetector) Rotate() {
+	d.current = (d.current + 1) % len(d.slots)
+	d.slots[d.current] = map[string]*randomSubdomainStats{}
+}
+
+// Evaluate checks the domains in the window and returns the events of
+// newly flagged domains (state alert) and no longer flagged domains (state clear).
+func (d *RandomSubdomainDetector) Evaluate(now time.Time) []*RandomSubdomainEvent {
+	window := map[string]*randomSubdomainStats{}
+	for _, slot := range d.slots {
+		for domain, s := range slot {
+			w, ok := window[domain]
+			if !ok {
+				w = &randomSubdomainStats{labels: NewHyperLogLog(randomSubdomainPrecision)}
+				window[domain] = w
+			}
+			w.queries += s.queries
+			w.responses += s.responses
+			w.errors += s.errors
+			w.labels.Merge(s.labels)
+		}
+	}
+	events := []*RandomSubdomainEvent{}
+	for domain, w := range window {
+		ev := d.evaluate(domain, w)
+		ev.Time = now.Format(time.RFC3339)
+		switch {
+		case len(ev.Reasons) > 0 && !d.flagged[domain]:
+			d.flagged[domain] = true
+			ev.State = "alert"
+			events = append(events, ev)
+		case len(ev.Reasons) == 0 && d.flagged[domain]:
+			delete(d.flagged, domain)
+			ev.State = "clear"
+			events = append(events, ev)
+		}
+		if !d.flagged[domain] {
+			if base, ok := d.baseline[domain]; ok {
+				d.baseline[domain] = base*(1-randomSubdomainBaselineAlpha) + float64(ev.UniqueLabels)*randomSubdomainBaselineAlpha
+			} else {
+				d.baseline[domain] = float64(ev.UniqueLabels)
+			}
+		}
+	}
+	for domain := range d.baseline {
+		if _, ok := window[domain]; !ok {
+			delete(d.baseline, domain)
+		}
+	}
+	for domain := range d.flagged {
+		if _, ok := window[domain]; !ok {
+			delete(d.flagged, domain)
+			events = append(events, &RandomSubdomainEvent{Time: now.Format(time.RFC3339), Domain: domain, State: "clear"})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Domain < events[j].Domain })
+	return events
+}
+
+func (d *RandomSubdomainDetector) evaluate(domain string, w *randomSubdomainStats) *RandomSubdomainEvent {
+	ev := &RandomSubdomainEvent{
+		Domain:       domain,
+		Queries:      w.queries,
+		Responses:    w.responses,
+		UniqueLabels: w.labels.Count(),
+		Baseline:     d.baseline[domain],
+	}
+	if ev.UniqueLabels > ev.Queries {
+		ev.UniqueLabels = ev.Queries
+	}
+	if w.queries > 0 {
+		ev.UniqueRatio = float64(ev.UniqueLabels) / float64(w.queries)
+	}
+	if w.responses > 0 {
+		ev.ErrorRatio = float64(w.errors) / float64(w.responses)
+	}
+	min := d.config.GetMinQueries()
+	if w.queries >= min && ev.UniqueRatio >= d.config.GetUniqueRatio() {
+		ev.Reasons = append(ev.Reasons, "unique_ratio")
+	}
+	if w.responses >= min && ev.ErrorRatio >= d.config.GetErrorRatio() {
+		ev.Reasons = append(ev.Reasons, "error_ratio")
+	}
+	if factor := d.config.GetBaselineFactor(); factor > 0 && ev.Baseline > 0 &&
+		ev.UniqueLabels >= min && float64(ev.UniqueLabels) > ev.Baseline*factor {
+		ev.Reasons = append(ev.Reasons, "baseline")
+	}
+	return ev
+}
+
+// Flagged returns the currently flagged domains.
+func (d *RandomSubdomainDetector) Flagged() []string {
+	domains := make([]string, 0, len(d.flagged))
+	for domain := range d.flagged {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
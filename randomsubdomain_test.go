@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestRandomSubdomainDetector(t *testing.T) {
+	config := &dtap.OutputRandomSubdomainConfig{Slots: 2}
+	d := dtap.NewRandomSubdomainDetector(config)
+	for i := 0; i < 200; i++ {
+		for _, qname := range []string{fmt.Sprintf("r%d.example.jp.", i), "www.example.com."} {
+			data, err := dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, qname), &config.Flat)
+			assert.NoError(t, err)
+			d.Add(data)
+		}
+	}
+	events := d.Evaluate(time.Now())
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "example.jp.", events[0].Domain)
+		assert.Equal(t, "alert", events[0].State)
+		assert.Equal(t, []string{"unique_ratio"}, events[0].Reasons)
+	}
+	assert.Equal(t, []string{"example.jp."}, d.Flagged())
+
+	d.Rotate()
+	assert.Len(t, d.Evaluate(time.Now()), 0)
+	d.Rotate()
+	events = d.Evaluate(time.Now())
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "example.jp.", events[0].Domain)
+		assert.Equal(t, "clear", events[0].State)
+	}
+	assert.Empty(t, d.Flagged())
+}
@@ -44,3 +44,12 @@ func (r *RBuf) Write(b []byte) {
 func (r *RBuf) Close() {
 	close(r.channel)
 }
+
+// Len returns the number of buffered frames.
+func (r *RBuf) Len() int {
+	return len(r.channel)
+}
+
+func (r *RBuf) Cap() int {
+	return cap(r.channel)
+}
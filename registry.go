@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Outputs are made again when the config is reloaded,
+// so their metrics and HTTP handlers are registered to be reused or replaced.
+
+// registerCollector registers c, or returns the same collector registered by the previous config.
+// A conflicting collector is logged and not exported until restart.
+func registerCollector(c prometheus.Collector) prometheus.Collector {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		log.Errorf("failed to register metrics: %v", err)
+	}
+	return c
+}
+
+type replaceableHandler struct {
+	mux     sync.RWMutex
+	handler http.Handler
+}
+
+func (h *replaceableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.RLock()
+	handler := h.handler
+	h.mux.RUnlock()
+	handler.ServeHTTP(w, r)
+}
+
+var (
+	handlersMux sync.Mutex
+	handlers    = map[string]*replaceableHandler{}
+)
+
+// handleHTTP registers handler to the path of http.DefaultServeMux, or replaces the handler of the previous config.
+func handleHTTP(path string, handler http.Handler) {
+	handlersMux.Lock()
+	defer handlersMux.Unlock()
+	if h, ok := handlers[path]; ok {
+		h.mux.Lock()
+		h.handler = handler
+		h.mux.Unlock()
+		return
+	}
+	h := &replaceableHandler{handler: handler}
+	handlers[path] = h
+	http.Handle(path, h)
+}
+
+// gaugeSnapshot exports the gauges set at once,
+// so a scrape never sees the gauges partially updated.
+type gaugeSnapshot struct {
+	desc    *prometheus.Desc
+	mux     sync.Mutex
+	metrics []prometheus.Metric
+}
+
+func newGaugeSnapshot(name, help string, labels []string) *gaugeSnapshot {
+	return &gaugeSnapshot{desc: prometheus.NewDesc(name, help, labels, nil)}
+}
+
+func (g *gaugeSnapshot) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *gaugeSnapshot) Collect(ch chan<- prometheus.Metric) {
+	g.mux.Lock()
+	metrics := g.metrics
+	g.mux.Unlock()
+	for _, m := range metrics {
+		ch <- m
+	}
+}
+
+// gaugeSnapshotValue is a gauge of the snapshot.
+type gaugeSnapshotValue struct {
+	labels []string
+	value  float64
+}
+
+// set replaces all gauges.
+func (g *gaugeSnapshot) set(values []gaugeSnapshotValue) {
+	metrics := make([]prometheus.Metric, 0, len(values))
+	for _, v := range values {
+		m, err := prometheus.NewConstMetric(g.desc, prometheus.GaugeValue, v.value, v.labels...)
+		if err != nil {
+			log.Debug(err)
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	g.mux.Lock()
+	g.metrics = metrics
+	g.mux.Unlock()
+}
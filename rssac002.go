@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	RSSAC002Metrics = []string{"traffic-volume", "traffic-sizes", "rcode-volume", "unique-sources"}
+
+	rssac002TrafficVolumeKeys = []string{
+		"dns-udp-queries-received-ipv4",
+		"dns-udp-queries-received-ipv6",
+		"dns-tcp-queries-received-ipv4",
+		"dns-tcp-queries-received-ipv6",
+		"dns-udp-responses-sent-ipv4",
+		"dns-udp-responses-sent-ipv6",
+		"dns-tcp-responses-sent-ipv4",
+		"dns-tcp-responses-sent-ipv6",
+	}
+	rssac002TrafficSizesKeys = []string{
+		"udp-request-sizes",
+		"udp-response-sizes",
+		"tcp-request-sizes",
+		"tcp-response-sizes",
+	}
+)
+
+// RSSAC002 accumulates the RSSAC002v4 metrics of a service in a day.
+// Unique sources are estimated with HyperLogLog.
+type RSSAC002 struct {
+	Service              string
+	Start                time.Time
+	trafficVolume        map[string]uint64
+	trafficSizes         map[string]map[int]uint64
+	rcodeVolume          map[int]uint64
+	sourcesIPv4          *HyperLogLog
+	sourcesIPv6          *HyperLogLog
+	sourcesIPv6Aggregate *HyperLogLog
+}
+
+func NewRSSAC002(service string, start time.Time) *RSSAC002 {
+	r := &RSSAC002{
+		Service:              service,
+		Start:                start,
+		trafficVolume:        map[string]uint64{},
+		trafficSizes:         map[string]map[int]uint64{},
+		rcodeVolume:          map[int]uint64{},
+		sourcesIPv4:          NewHyperLogLog(16),
+		sourcesIPv6:          NewHyperLogLog(16),
+		sourcesIPv6Aggregate: NewHyperLogLog(16),
+	}
+	for _, key := range rssac002TrafficSizesKeys {
+		r.trafficSizes[key] = map[int]uint64{}
+	}
+	return r
+}
+
+func rssac002Transport(family, protocol string) (string, string, bool) {
+	var p, f string
+	switch protocol {
+	case "UDP":
+		p = "udp"
+	case "TCP":
+		p = "tcp"
+	default:
+		return "", "", false
+	}
+	switch family {
+	case "INET":
+		f = "ipv4"
+	case "INET6":
+		f = "ipv6"
+	default:
+		return "", "", false
+	}
+	return p, f, true
+}
+
+// AddQuery counts a received query.
+// source and aggregate are the keys of the source address and its /64 prefix.
+func (r *RSSAC002) AddQuery(family, protocol string, size int, source, aggregate string) {
+	p, f, ok := rssac002Transport(family, protocol)
+	if !ok {
+		return
+	}
+	r.trafficVolume["dns-"+p+"-queries-received-"+f]++
+	r.trafficSizes[p+"-request-sizes"][size/16*16]++
+	if f == "ipv4" {
+		r.sourcesIPv4.Add(source)
+	} else {
+		r.sourcesIPv6.Add(source)
+		r.sourcesIPv6Aggregate.Add(aggregate)
+	}
+}
+
+// AddResponse counts a sent response.
+func (r *RSSAC002) AddResponse(family, protocol string, size int, rcode int) {
+	p, f, ok := rssac002Transport(family, protocol)
+	if !ok {
+		return
+	}
+	r.trafficVolume["dns-"+p+"-responses-sent-"+f]++
+	r.trafficSizes[p+"-response-sizes"][size/16*16]++
+	r.rcodeVolume[rcode]++
+}
+
+// YAML returns the report of the metric.
+func (r *RSSAC002) YAML(metric string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "---\nversion: rssac002v4\nservice: %s\nstart-period: %s\nmetric: %s\n",
+		r.Service, r.Start.UTC().Format("2006-01-02T15:04:05Z"), metric)
+	switch metric {
+	case "traffic-volume":
+		for _, key := range rssac002TrafficVolumeKeys {
+			fmt.Fprintf(buf, "%s: %d\n", key, r.trafficVolume[key])
+		}
+	case "traffic-sizes":
+		for _, key := range rssac002TrafficSizesKeys {
+			sizes := r.trafficSizes[key]
+			if len(sizes) == 0 {
+				fmt.Fprintf(buf, "%s: {}\n", key)
+				continue
+			}
+			fmt.Fprintf(buf, "%s:\n", key)
+			for _, bin := range sortedIntKeys(sizes) {
+				fmt.Fprintf(buf, "  %d-%d: %d\n", bin, bin+15, sizes[bin])
+			}
+		}
+	case "rcode-volume":
+		for _, rcode := range sortedIntKeys(r.rcodeVolume) {
+			fmt.Fprintf(buf, "%d: %d\n", rcode, r.rcodeVolume[rcode])
+		}
+	case "unique-sources":
+		fmt.Fprintf(buf, "num-sources-ipv4: %d\n", r.sourcesIPv4.Count())
+		fmt.Fprintf(buf, "num-sources-ipv6: %d\n", r.sourcesIPv6.Count())
+		fmt.Fprintf(buf, "num-sources-ipv6-aggregate: %d\n", r.sourcesIPv6Aggregate.Count())
+	}
+	return buf.Bytes()
+}
+
+// rssac002State is the counters of RSSAC002 persisted with the reports.
+type rssac002State struct {
+	TrafficVolume        map[string]uint64         `json:"traffic_volume"`
+	TrafficSizes         map[string]map[int]uint64 `json:"traffic_sizes"`
+	RcodeVolume          map[int]uint64            `json:"rcode_volume"`
+	SourcesIPv4          []byte                    `json:"sources_ipv4"`
+	SourcesIPv6          []byte                    `json:"sources_ipv6"`
+	SourcesIPv6Aggregate []byte                    `json:"sources_ipv6_aggregate"`
+}
+
+// Merge adds the counters of other to r.
+func (r *RSSAC002) Merge(other *RSSAC002) error {
+	for key, v := range other.trafficVolume {
+		r.trafficVolume[key] += v
+	}
+	for key, sizes := range other.trafficSizes {
+		if r.trafficSizes[key] == nil {
+			r.trafficSizes[key] = map[int]uint64{}
+		}
+		for bin, v := range sizes {
+			r.trafficSizes[key][bin] += v
+		}
+	}
+	for rcode, v := range other.rcodeVolume {
+		r.rcodeVolume[rcode] += v
+	}
+	if err := r.sourcesIPv4.Merge(other.sourcesIPv4); err != nil {
+		return err
+	}
+	if err := r.sourcesIPv6.Merge(other.sourcesIPv6); err != nil {
+		return err
+	}
+	return r.sourcesIPv6Aggregate.Merge(other.sourcesIPv6Aggregate)
+}
+
+func (r *RSSAC002) marshalState() ([]byte, error) {
+	state := &rssac002State{
+		TrafficVolume: r.trafficVolume,
+		TrafficSizes:  r.trafficSizes,
+		RcodeVolume:   r.rcodeVolume,
+	}
+	state.SourcesIPv4, _ = r.sourcesIPv4.MarshalBinary()
+	state.SourcesIPv6, _ = r.sourcesIPv6.MarshalBinary()
+	state.SourcesIPv6Aggregate, _ = r.sourcesIPv6Aggregate.MarshalBinary()
+	return json.Marshal(state)
+}
+
+func (r *RSSAC002) unmarshalState(b []byte) error {
+	state := &rssac002State{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return err
+	}
+	for key, v := range state.TrafficVolume {
+		r.trafficVolume[key] = v
+	}
+	for key, sizes := range state.TrafficSizes {
+		r.trafficSizes[key] = sizes
+	}
+	for rcode, v := range state.RcodeVolume {
+		r.rcodeVolume[rcode] = v
+	}
+	if err := r.sourcesIPv4.UnmarshalBinary(state.SourcesIPv4); err != nil {
+		return err
+	}
+	if err := r.sourcesIPv6.UnmarshalBinary(state.SourcesIPv6); err != nil {
+		return err
+	}
+	return r.sourcesIPv6Aggregate.UnmarshalBinary(state.SourcesIPv6Aggregate)
+}
+
+// WriteFiles writes the reports to dir as <service>-<YYYYMMDD>-<metric>.yaml.
+// The counters are also written to <service>-<YYYYMMDD>.state, and if it exists,
+// e.g. written at shutdown before a restart on the same day, r is merged with it first.
+func (r *RSSAC002) WriteFiles(dir string) error {
+	service := strings.Replace(r.Service, "/", "_", -1)
+	prefix := filepath.Join(dir, fmt.Sprintf("%s-%s", service, r.Start.UTC().Format("20060102")))
+	stateFile := prefix + ".state"
+	if b, err := ioutil.ReadFile(stateFile); err == nil {
+		prev := NewRSSAC002(r.Service, r.Start)
+		if err := prev.unmarshalState(b); err != nil {
+			return fmt.Errorf("failed to parse file %s err: %w", stateFile, err)
+		}
+		if err := r.Merge(prev); err != nil {
+			return fmt.Errorf("failed to merge file %s err: %w", stateFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read file %s err: %w", stateFile, err)
+	}
+	for _, metric := range RSSAC002Metrics {
+		filename := fmt.Sprintf("%s-%s.yaml", prefix, metric)
+		if err := ioutil.WriteFile(filename, r.YAML(metric), 0644); err != nil {
+			return fmt.Errorf("failed to write file %s err: %w", filename, err)
+		}
+	}
+	b, err := r.marshalState()
+	if err != nil {
+		return fmt.Errorf("failed to marshal state err: %w", err)
+	}
+	if err := ioutil.WriteFile(stateFile, b, 0644); err != nil {
+		return fmt.Errorf("failed to write file %s err: %w", stateFile, err)
+	}
+	return nil
+}
+
+func sortedIntKeys(m map[int]uint64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
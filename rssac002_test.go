@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestRSSAC002(t *testing.T) {
+	r := dtap.NewRSSAC002("a.example", time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC))
+	r.AddQuery("INET", "UDP", 40, "192.0.2.1", "")
+	r.AddQuery("INET", "UDP", 47, "192.0.2.1", "")
+	r.AddQuery("INET6", "TCP", 60, "2001:db8::1", "2001:db8::")
+	r.AddQuery("INET", "DOH", 60, "192.0.2.2", "")
+	r.AddResponse("INET", "UDP", 100, 0)
+	r.AddResponse("INET", "UDP", 120, 3)
+
+	assert.Equal(t, `---
+version: rssac002v4
+service: a.example
+start-period: 2020-09-13T00:00:00Z
+metric: traffic-volume
+dns-udp-queries-received-ipv4: 2
+dns-udp-queries-received-ipv6: 0
+dns-tcp-queries-received-ipv4: 0
+dns-tcp-queries-received-ipv6: 1
+dns-udp-responses-sent-ipv4: 2
+dns-udp-responses-sent-ipv6: 0
+dns-tcp-responses-sent-ipv4: 0
+dns-tcp-responses-sent-ipv6: 0
+`, string(r.YAML("traffic-volume")))
+	assert.Equal(t, `---
+version: rssac002v4
+service: a.example
+start-period: 2020-09-13T00:00:00Z
+metric: traffic-sizes
+udp-request-sizes:
+  32-47: 2
+udp-response-sizes:
+  96-111: 1
+  112-127: 1
+tcp-request-sizes:
+  48-63: 1
+tcp-response-sizes: {}
+`, string(r.YAML("traffic-sizes")))
+	assert.Contains(t, string(r.YAML("rcode-volume")), "0: 1\n3: 1\n")
+	assert.Contains(t, string(r.YAML("unique-sources")), "num-sources-ipv4: 1\nnum-sources-ipv6: 1\nnum-sources-ipv6-aggregate: 1\n")
+}
+
+func TestRSSAC002WriteFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtap-test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	day := time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC)
+
+	// a restart on the same day merges the counters written at shutdown
+	r := dtap.NewRSSAC002("a.example", day)
+	r.AddQuery("INET", "UDP", 40, "192.0.2.1", "")
+	r.AddResponse("INET", "UDP", 100, 0)
+	assert.NoError(t, r.WriteFiles(dir))
+	r = dtap.NewRSSAC002("a.example", day)
+	r.AddQuery("INET", "UDP", 40, "192.0.2.1", "")
+	r.AddQuery("INET", "UDP", 40, "192.0.2.2", "")
+	r.AddResponse("INET", "UDP", 100, 3)
+	assert.NoError(t, r.WriteFiles(dir))
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "a.example-20200913-traffic-volume.yaml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "dns-udp-queries-received-ipv4: 3\n")
+	b, err = ioutil.ReadFile(filepath.Join(dir, "a.example-20200913-traffic-sizes.yaml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "udp-request-sizes:\n  32-47: 3\n")
+	b, err = ioutil.ReadFile(filepath.Join(dir, "a.example-20200913-rcode-volume.yaml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "0: 1\n3: 1\n")
+	b, err = ioutil.ReadFile(filepath.Join(dir, "a.example-20200913-unique-sources.yaml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "num-sources-ipv4: 2\n")
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var IPHashSaltEpochGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "dtap_ip_hash_salt_epoch",
+	Help: "Start time of the current address hashing salt epoch in unix seconds.",
+}, []string{"interval"})
+
+// IPHashSaltEpoch returns the start time (unix seconds) of the epoch containing t.
+// Epochs are interval seconds long and begin offset seconds after 00:00 UTC.
+func IPHashSaltEpoch(t time.Time, interval, offset uint) int64 {
+	if interval == 0 {
+		return 0
+	}
+	sec := t.Unix() - int64(offset)
+	return sec - sec%int64(interval) + int64(offset)
+}
+
+// DeriveIPHashSalt derives the salt of an epoch from the master secret.
+// Anyone who knows the secret can recompute the salt of any epoch.
+func DeriveIPHashSalt(secret []byte, epoch int64) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(time.Unix(epoch, 0).UTC().Format(time.RFC3339)))
+	return mac.Sum(nil)
+}
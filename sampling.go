@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var SampledOutFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "dtap_sampled_out_frames_total",
+	Help: "The total number of frames dropped by sampling",
+}, []string{"output", "mode"})
+
+// Sampler decides which frames an output keeps.
+// client and qname modes are deterministic, a client or a qname is always or never sampled.
+type Sampler struct {
+	mode      string
+	rate      float64
+	threshold uint64
+	rand      *rand.Rand
+	counter   prometheus.Counter
+}
+
+// NewSampler returns nil if sampling is disabled.
+// output is the label of the sampled out counter, such as OutputFluent[0].
+func NewSampler(config *OutputSamplingConfig, output string) *Sampler {
+	if config.GetMode() == "" {
+		return nil
+	}
+	s := &Sampler{
+		mode:    config.GetMode(),
+		rate:    config.GetRate(),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		counter: SampledOutFrames.WithLabelValues(output, config.GetMode()),
+	}
+	if s.rate >= 1 {
+		s.threshold = math.MaxUint64
+	} else {
+		s.threshold = uint64(s.rate*(1<<63)) << 1
+	}
+	return s
+}
+
+// Sample returns the sampling rate of the frame and whether it is kept.
+// The rate is 0 if s is nil.
+func (s *Sampler) Sample(dt *dnstap.Dnstap) (float64, bool) {
+	if s == nil {
+		return 0, true
+	}
+	var keep bool
+	switch s.mode {
+	case "client":
+		keep = hash64(string(dt.GetMessage().GetQueryAddress())) <= s.threshold
+	case "qname":
+		keep = hash64(strings.ToLower(sampleQname(dt))) <= s.threshold
+	case "errors":
+		if rcode, ok := sampleRcode(dt); ok && rcode != dns.RcodeSuccess {
+			return 1, true
+		}
+		keep = s.rand.Uint64() <= s.threshold
+	default:
+		keep = s.rand.Uint64() <= s.threshold
+	}
+	if !keep {
+		s.counter.Inc()
+	}
+	return s.rate, keep
+}
+
+// SampleFrame is Sample for the outputs writing the raw frame,
+// the frame is unmarshaled only if s isn't nil.
+// A frame which can't be unmarshaled is dropped and counted as sampled out.
+func (s *Sampler) SampleFrame(frame []byte) bool {
+	if s == nil {
+		return true
+	}
+	dt := dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, &dt); err != nil {
+		s.counter.Inc()
+		return false
+	}
+	_, ok := s.Sample(&dt)
+	return ok
+}
+
+func sampleMessage(dt *dnstap.Dnstap) []byte {
+	if msg := dt.GetMessage().GetResponseMessage(); msg != nil {
+		return msg
+	}
+	return dt.GetMessage().GetQueryMessage()
+}
+
+func sampleQname(dt *dnstap.Dnstap) string {
+	m := dns.Msg{}
+	if err := m.Unpack(sampleMessage(dt)); err != nil || len(m.Question) == 0 {
+		return ""
+	}
+	return m.Question[0].Name
+}
+
+// sampleRcode returns the rcode of the response message.
+func sampleRcode(dt *dnstap.Dnstap) (int, bool) {
+	msg := dt.GetMessage().GetResponseMessage()
+	if msg == nil {
+		return 0, false
+	}
+	m := dns.Msg{}
+	if err := m.Unpack(msg); err != nil {
+		return 0, false
+	}
+	return m.Rcode, true
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestSampler(t *testing.T) {
+	assert.Nil(t, dtap.NewSampler(&dtap.OutputSamplingConfig{}, "test"))
+	assert.NotNil(t, (&dtap.OutputSamplingConfig{Mode: "random"}).Validate())
+	assert.NotNil(t, (&dtap.OutputSamplingConfig{Mode: "unknown", Rate: 0.5}).Validate())
+
+	s := dtap.NewSampler(&dtap.OutputSamplingConfig{Mode: "client", Rate: 0.5}, "test")
+	var kept int
+	for i := 0; i < 1000; i++ {
+		dt := newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp.")
+		dt.Message.QueryAddress = net.ParseIP(fmt.Sprintf("10.0.%d.%d", i/256, i%256)).To4()
+		rate, ok := s.Sample(dt)
+		assert.Equal(t, 0.5, rate)
+		_, again := s.Sample(dt)
+		assert.Equal(t, ok, again)
+		if ok {
+			kept++
+		}
+	}
+	assert.InDelta(t, 500, kept, 100)
+
+	s = dtap.NewSampler(&dtap.OutputSamplingConfig{Mode: "errors", Rate: 0.01}, "test")
+	m := new(dns.Msg)
+	m.SetRcode(new(dns.Msg).SetQuestion("www.example.jp.", dns.TypeA), dns.RcodeNameError)
+	bs, err := m.Pack()
+	assert.NoError(t, err)
+	dt := newTestDnstap(t, dnstap.Message_CLIENT_RESPONSE, "www.example.jp.")
+	dt.Message.ResponseMessage = bs
+	for i := 0; i < 100; i++ {
+		rate, ok := s.Sample(dt)
+		assert.True(t, ok)
+		assert.Equal(t, 1.0, rate)
+	}
+}
+
+func TestSamplerSampleFrame(t *testing.T) {
+	var s *dtap.Sampler
+	assert.True(t, s.SampleFrame([]byte("not dnstap")))
+
+	// a broken frame is dropped and counted
+	s = dtap.NewSampler(&dtap.OutputSamplingConfig{Mode: "random", Rate: 0.000001}, "OutputFile[1]")
+	assert.False(t, s.SampleFrame([]byte("not dnstap")))
+
+	bs, err := proto.Marshal(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp."))
+	assert.NoError(t, err)
+	var kept int
+	for i := 0; i < 100; i++ {
+		if s.SampleFrame(bs) {
+			kept++
+		}
+	}
+	assert.Equal(t, float64(101-kept), testutil.ToFloat64(dtap.SampledOutFrames.WithLabelValues("OutputFile[1]", "random")))
+}
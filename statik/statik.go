@@ -8,6 +8,6 @@ import (
 )
 
 func init() {
-	data := "PK\x03\x04\x14\x00\x08\x00\x08\x00\x85\x0d0O\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00	\x00	\x00flat.avscUT\x05\x00\x01\xeb\xe8~]\xb4\x94\xcdj\xeb0\x10\x85\xf7~\n\xa1u\x16w\x9d\xf5\xa5O\xd0])f*\x8dcQYrf&%?\xe4\xdd\x8bU\x1aJ\xab\xd8\xaeP7\xc6\x8c\xfc}>:\x08]\x1a\xa5\xb4\x9cF\xd4[\xa5	M$\xab7\xd3,\xc0\x90f\xff\x03\x0b\x8c\x0f\x1e\xe4\xf1c\xa1s\xe8-\xeb\xadzj\x94R\xea\x92\x9e_\x00q\x03\xb2\xc00\xa6\xcf\xd3\xd2\xa7\x9f\x85\\\xd8\xe94\xben\xf2\xf8\xfe\x80tj'\xc9]\xfe6\xb7\xd8\xc1\xc1\xcb\xb4\xa4\x9b\x15V\xb0\x96\x90\xf9\x97\xe2\xd5\xde\xb6\x07\xee\xab\xcb\xc7H\xf2S\xea\x82\xe4\x8c\xfff\x85\x84<\xc6\xc0XR\xef*o\xfd\x82\xbf\xabkw|\xf3\xffE\xcd\xe7\x18j\xd6\x8c\x86\xdb\x80\x99\x94\xc5Fg1\x88\x93SEe\x12\xdc\xd3\xcd\x92\x1c\xcd+J\xdb\xc1\xe0\xfc\xfdDk\x14#E\x89&\xfa2\xc9\x1b\x12\xbb\x18*v\x82G!\xa8\xe8\x13o\xcb\xb6\xc6\xa5\xa0\xf4\x8el)\xdc\xc5\x03I_J\xef\xd3K\x19j<\xcc\xdcF\xf3l\xf9A\x1e\x90\x19v\xd8\xb2;g\x0c\xd3\x952\xdf\xf5\xd1e\xaaZ\xc4\xc8D[\x18\x182\x87\xf3%F\x8f\x10\x16\xa2\x9aB\x902;\\\x07\x96F\x85\xd2?\x9aE\xb0Q\xea\xb9\xb9\xbe\x07\x00\x00\xff\xffPK\x07\x08\xa5+\xb8\xfc?\x01\x00\x00J	\x00\x00PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x85\x0d0O\xa5+\xb8\xfc?\x01\x00\x00J	\x00\x00	\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa4\x81\x00\x00\x00\x00flat.avscUT\x05\x00\x01\xeb\xe8~]PK\x05\x06\x00\x00\x00\x00\x01\x00\x01\x00@\x00\x00\x00\x7f\x01\x00\x00\x00\x00"
+	data := "PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00	\x00	\x00flat.avscUT\x05\x00\x01\x80Cm8\xb4\x96\xbf\x8e\xdb0\x0c\xc6w?\x85\xe0\xf9\x86\xce7\x17}\x82nE!0\x12\x9d\x08\x95E\x85\xa4\x8b\xf3\x15\xf7\xee\x85]\xa08\xb4\xb6\x910\xba-P\xf2\xfdD}\xfc\x97_\x9ds\xbd\xce\x15\xfbg\xd73\x06\xe2\xd8?-g\x05\xc6\xf5\xecs\x11\x85\xfa%\x83~\xfd\xf3\xc5\x900G\xe9\x9f\xdd\xb7\xce9\xe7\x16\x80s\xef\x04\x9aF\x14\x85\xb1\xae?w\xee\x1d_\x94S9\xf7\xab\xe2\xedi[~\x9d\x90g\xbf@v\xf5\x7f\xcf#\x0e0e]d}w\x03\x15bd\x14\xb9\x13|3\xd7_@.\xcd\xe1\x95X\xff\x87\xa6\xa2[>|:\x8c\x96Q*\x15A\x8b\xbd7q\xdb\x1b\xfc/\xba\xb5\xc7\x02Y=V\n\x1b\x89\xcbT\xce\x0f\x98\xfc\x11\x89{\xa5ro_\x1cq1\x88/\xa8\x0d\xfdL\x11\x8b&\x9d\x1b\"\xd7\x1c\xef\xe1\x0e\x9f'\x14~\xa0\xfa\x01\xc6\x94\xe7\x87\x10\x95I)P\xb6A~\"K\xa2\xb2+6$\xeeE\x19\x1a\xf24G\xa3\xc5V\xa1^\x12G\xabx\xa0\x89\xf5bU_\xd7\x0f\xa6\xe7^C\x06\x11[5^\xed\x85<\xa2\x08\x9c\xd1Kz\xddh\xffe\x17\x1c\xca\xf5%E\x83\x8c\x03\xc5\xfdisx#\xd5C\xadaR\xc5\"~\x8a\xf5\xc0\x82\xbb'5lt\xd0\x89(#\x94\xe3`4\x18\x85\x1c\xadBk\xa8`\xbd1X\x85kwy,\xcaT7\x86n\xa4\xe9\x94qkW\x1d\xaf\xd5e\x19\xa3\xa8\xcfp\xc2\xdc\xa8\x00b:'\xf5\x0c\x9a\xa8]\xa0\x81\x8aP\x81\xa2\x9e\xa7\xd2\xa8R#\x8d\x90\x8a?\xcd\x8a\xd2\xea\x7f\x8aLRSH4\xc9~\x17l@\x07\xc8\x827\x82=#H\xd3\xbd'0\xd6\x8cK\xca\xd0PZ\x9ds\xdf\xbb\xb7\xdf\x03\x00PK\x07\x08R\x9f\xd5X\xb4\x01\x00\x00\xf3\x0c\x00\x00PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(R\x9f\xd5X\xb4\x01\x00\x00\xf3\x0c\x00\x00	\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xb4\x81\x00\x00\x00\x00flat.avscUT\x05\x00\x01\x80Cm8PK\x05\x06\x00\x00\x00\x00\x01\x00\x01\x00@\x00\x00\x00\xf4\x01\x00\x00\x00\x00"
 	fs.Register(data)
 }
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// latencyBucketBase is the ratio of adjacent latency buckets.
+// Percentiles are accurate within 1%.
+const latencyBucketBase = 1.01
+
+type StatsRow struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// StatsLatency is the response latency percentiles in milliseconds.
+type StatsLatency struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+type StatsReport struct {
+	Start         *time.Time    `json:"start,omitempty"`
+	End           *time.Time    `json:"end,omitempty"`
+	Messages      uint64        `json:"messages"`
+	Types         []StatsRow    `json:"types"`
+	Qtypes        []StatsRow    `json:"qtypes"`
+	Rcodes        []StatsRow    `json:"rcodes"`
+	Transports    []StatsRow    `json:"transports"`
+	TopQnames     []StatsRow    `json:"top_qnames"`
+	TopClients    []StatsRow    `json:"top_clients"`
+	Latency       *StatsLatency `json:"latency,omitempty"`
+	QuerySizes    []StatsRow    `json:"query_sizes"`
+	ResponseSizes []StatsRow    `json:"response_sizes"`
+}
+
+// Stats summarizes flattened messages for offline investigations.
+// qtypes, transports, qnames and clients are counted on queries,
+// rcodes, latencies and response sizes are counted on responses.
+// Top qnames and clients are estimated with Space-Saving.
+type Stats struct {
+	messages      uint64
+	types         map[string]uint64
+	qtypes        map[string]uint64
+	rcodes        map[string]uint64
+	transports    map[string]uint64
+	qnames        *SpaceSaving
+	clients       *SpaceSaving
+	latencies     map[int]uint64
+	latencyCount  uint64
+	latencyMax    time.Duration
+	querySizes    map[int]uint64
+	responseSizes map[int]uint64
+}
+
+// NewStats makes Stats which keeps capacity counters of qnames and clients.
+func NewStats(capacity int) *Stats {
+	return &Stats{
+		types:         map[string]uint64{},
+		qtypes:        map[string]uint64{},
+		rcodes:        map[string]uint64{},
+		transports:    map[string]uint64{},
+		qnames:        NewSpaceSaving(capacity),
+		clients:       NewSpaceSaving(capacity),
+		latencies:     map[int]uint64{},
+		querySizes:    map[int]uint64{},
+		responseSizes: map[int]uint64{},
+	}
+}
+
+func (s *Stats) Add(data *DnstapFlatT) {
+	s.messages++
+	s.types[data.Type]++
+	if strings.HasSuffix(data.Type, "_QUERY") {
+		s.qtypes[data.Qtype]++
+		s.transports[data.SocketFamily+"/"+data.SocketProtocol]++
+		s.qnames.Add(data.Qname, 1)
+		client := data.QueryAddressHash
+		if client == "" {
+			client = data.QueryAddress.String()
+		}
+		s.clients.Add(client, 1)
+		s.querySizes[data.MessageSize/16*16]++
+		return
+	}
+	s.rcodes[data.Rcode]++
+	s.responseSizes[data.MessageSize/16*16]++
+	if latency, ok := data.Latency(); ok {
+		s.latencyCount++
+		s.latencies[latencyBucket(latency)]++
+		if latency > s.latencyMax {
+			s.latencyMax = latency
+		}
+	}
+}
+
+func latencyBucket(d time.Duration) int {
+	if d < time.Microsecond {
+		return 0
+	}
+	return int(math.Log(float64(d/time.Microsecond)) / math.Log(latencyBucketBase))
+}
+
+// latencyPercentile returns the upper bound of the bucket including the p-th latency in milliseconds.
+func (s *Stats) latencyPercentile(p float64) float64 {
+	buckets := sortedIntKeys(s.latencies)
+	rank := uint64(math.Ceil(p * float64(s.latencyCount)))
+	var n uint64
+	for _, b := range buckets {
+		n += s.latencies[b]
+		if n >= rank {
+			ms := math.Pow(latencyBucketBase, float64(b+1)) / 1000
+			return math.Min(ms, float64(s.latencyMax)/float64(time.Millisecond))
+		}
+	}
+	return float64(s.latencyMax) / float64(time.Millisecond)
+}
+
+// Report returns the tables with top entries of qnames and clients.
+func (s *Stats) Report(top int) *StatsReport {
+	r := &StatsReport{
+		Messages:      s.messages,
+		Types:         statsRows(s.types),
+		Qtypes:        statsRows(s.qtypes),
+		Rcodes:        statsRows(s.rcodes),
+		Transports:    statsRows(s.transports),
+		TopQnames:     topStatsRows(s.qnames, top),
+		TopClients:    topStatsRows(s.clients, top),
+		QuerySizes:    sizeStatsRows(s.querySizes),
+		ResponseSizes: sizeStatsRows(s.responseSizes),
+	}
+	if s.latencyCount > 0 {
+		r.Latency = &StatsLatency{
+			Count: s.latencyCount,
+			P50:   s.latencyPercentile(0.5),
+			P90:   s.latencyPercentile(0.9),
+			P99:   s.latencyPercentile(0.99),
+			Max:   float64(s.latencyMax) / float64(time.Millisecond),
+		}
+	}
+	return r
+}
+
+func statsRows(m map[string]uint64) []StatsRow {
+	rows := make([]StatsRow, 0, len(m))
+	for key, count := range m {
+		rows = append(rows, StatsRow{Key: key, Count: count})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count == rows[j].Count {
+			return rows[i].Key < rows[j].Key
+		}
+		return rows[i].Count > rows[j].Count
+	})
+	return rows
+}
+
+func topStatsRows(s *SpaceSaving, top int) []StatsRow {
+	rows := []StatsRow{}
+	for _, e := range s.Entries() {
+		if len(rows) >= top {
+			break
+		}
+		rows = append(rows, StatsRow{Key: e.Key, Count: e.Count})
+	}
+	return rows
+}
+
+func sizeStatsRows(m map[int]uint64) []StatsRow {
+	rows := make([]StatsRow, 0, len(m))
+	for _, bin := range sortedIntKeys(m) {
+		rows = append(rows, StatsRow{Key: strconv.Itoa(bin) + "-" + strconv.Itoa(bin+15), Count: m[bin]})
+	}
+	return rows
+}
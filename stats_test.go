@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestStats(t *testing.T) {
+	config := &dtap.FlatConfig{IPv4Mask: 24}
+	s := dtap.NewStats(100)
+	for _, qname := range []string{"a.example.jp.", "a.example.jp.", "b.example.jp."} {
+		data, err := dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, qname), config)
+		assert.NoError(t, err)
+		s.Add(data)
+	}
+	for _, ms := range []uint32{1, 2, 3, 4, 100} {
+		dt := newTestDnstap(t, dnstap.Message_CLIENT_RESPONSE, "a.example.jp.")
+		m := new(dns.Msg)
+		m.SetQuestion("a.example.jp.", dns.TypeA)
+		m.Response = true
+		m.Rcode = dns.RcodeNameError
+		bs, err := m.Pack()
+		assert.NoError(t, err)
+		sec := dt.Message.GetQueryTimeSec()
+		nsec := ms * 1000000
+		dt.Message.ResponseTimeSec = &sec
+		dt.Message.ResponseTimeNsec = &nsec
+		dt.Message.QueryMessage = nil
+		dt.Message.ResponseMessage = bs
+		data, err := dtap.FlatDnstap(dt, config)
+		assert.NoError(t, err)
+		s.Add(data)
+	}
+
+	r := s.Report(1)
+	assert.Equal(t, uint64(8), r.Messages)
+	assert.Equal(t, []dtap.StatsRow{{Key: "CLIENT_RESPONSE", Count: 5}, {Key: "CLIENT_QUERY", Count: 3}}, r.Types)
+	assert.Equal(t, []dtap.StatsRow{{Key: "A", Count: 3}}, r.Qtypes)
+	assert.Equal(t, []dtap.StatsRow{{Key: "NXDOMAIN", Count: 5}}, r.Rcodes)
+	assert.Equal(t, []dtap.StatsRow{{Key: "INET/UDP", Count: 3}}, r.Transports)
+	assert.Equal(t, []dtap.StatsRow{{Key: "a.example.jp.", Count: 2}}, r.TopQnames)
+	assert.Equal(t, []dtap.StatsRow{{Key: "192.168.0.0", Count: 3}}, r.TopClients)
+	assert.Equal(t, []dtap.StatsRow{{Key: "16-31", Count: 3}}, r.QuerySizes)
+	if assert.NotNil(t, r.Latency) {
+		assert.Equal(t, uint64(5), r.Latency.Count)
+		assert.InDelta(t, 3, r.Latency.P50, 0.03)
+		assert.InDelta(t, 100, r.Latency.P90, 1)
+		assert.Equal(t, 100.0, r.Latency.Max)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SummaryDatasets is the list of supported datasets.
+// qtype_rcode is made from response messages, the others are made from query messages.
+var SummaryDatasets = []string{
+	"qtype",
+	"qtype_rcode",
+	"client_subnet",
+	"qname_length",
+	"edns_udp_size",
+	"transport",
+	"opcode",
+}
+
+type SummaryRow struct {
+	Dataset string   `json:"dataset"`
+	Keys    []string `json:"keys"`
+	Count   uint64   `json:"count"`
+}
+
+// Key returns the i-th key or empty string.
+func (r SummaryRow) Key(i int) string {
+	if i < len(r.Keys) {
+		return r.Keys[i]
+	}
+	return ""
+}
+
+// Summary aggregates flattened messages into DSC like datasets.
+type Summary struct {
+	datasets []string
+	counts   map[string]map[string]uint64
+}
+
+func NewSummary(datasets []string) *Summary {
+	s := &Summary{
+		datasets: datasets,
+		counts:   map[string]map[string]uint64{},
+	}
+	for _, name := range datasets {
+		s.counts[name] = map[string]uint64{}
+	}
+	return s
+}
+
+func (s *Summary) Add(data *DnstapFlatT) {
+	isQuery := strings.HasSuffix(data.Type, "_QUERY")
+	for _, name := range s.datasets {
+		if (name == "qtype_rcode") == isQuery {
+			continue
+		}
+		var keys []string
+		switch name {
+		case "qtype_rcode":
+			keys = []string{data.Qtype, data.Rcode}
+		case "qtype":
+			keys = []string{data.Qtype}
+		case "client_subnet":
+			keys = []string{data.QueryAddress.String()}
+		case "qname_length":
+			keys = []string{strconv.Itoa(len(data.Qname))}
+		case "edns_udp_size":
+			keys = []string{strconv.Itoa(int(data.EdnsUDPSize))}
+		case "transport":
+			keys = []string{data.SocketFamily, data.SocketProtocol}
+		case "opcode":
+			keys = []string{data.Opcode}
+		}
+		s.counts[name][strings.Join(keys, "\x00")]++
+	}
+}
+
+// Rows returns the counts sorted by dataset and count.
+func (s *Summary) Rows() []SummaryRow {
+	rows := []SummaryRow{}
+	for _, name := range s.datasets {
+		start := len(rows)
+		for key, count := range s.counts[name] {
+			rows = append(rows, SummaryRow{
+				Dataset: name,
+				Keys:    strings.Split(key, "\x00"),
+				Count:   count,
+			})
+		}
+		sub := rows[start:]
+		sort.Slice(sub, func(i, j int) bool {
+			if sub[i].Count == sub[j].Count {
+				return strings.Join(sub[i].Keys, ",") < strings.Join(sub[j].Keys, ",")
+			}
+			return sub[i].Count > sub[j].Count
+		})
+	}
+	return rows
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestSummary(t *testing.T) {
+	config := &dtap.FlatConfig{}
+	s := dtap.NewSummary(dtap.SummaryDatasets)
+	for _, qname := range []string{"www.example.jp.", "www.example.jp.", "example.jp."} {
+		data, err := dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, qname), config)
+		assert.NoError(t, err)
+		s.Add(data)
+	}
+	data, err := dtap.FlatDnstap(newTestDnstap(t, dnstap.Message_CLIENT_RESPONSE, "example.jp."), config)
+	assert.NoError(t, err)
+	s.Add(data)
+
+	rows := map[string][]dtap.SummaryRow{}
+	for _, row := range s.Rows() {
+		rows[row.Dataset] = append(rows[row.Dataset], row)
+	}
+	assert.Equal(t, []dtap.SummaryRow{{Dataset: "qtype", Keys: []string{"A"}, Count: 3}}, rows["qtype"])
+	assert.Equal(t, []dtap.SummaryRow{{Dataset: "qtype_rcode", Keys: []string{"A", "NOERROR"}, Count: 1}}, rows["qtype_rcode"])
+	assert.Equal(t, []dtap.SummaryRow{
+		{Dataset: "qname_length", Keys: []string{"15"}, Count: 2},
+		{Dataset: "qname_length", Keys: []string{"11"}, Count: 1},
+	}, rows["qname_length"])
+	assert.Equal(t, []dtap.SummaryRow{{Dataset: "client_subnet", Keys: []string{"192.168.0.0"}, Count: 3}}, rows["client_subnet"])
+	assert.Equal(t, []dtap.SummaryRow{{Dataset: "transport", Keys: []string{"INET", "UDP"}, Count: 3}}, rows["transport"])
+}
+
+func TestDnstapSummaryOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtap-test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	config := &dtap.OutputSummaryConfig{
+		Interval: 3600,
+		Datasets: []string{"qtype"},
+		Format:   "csv",
+		Path:     filepath.Join(dir, "summary.csv"),
+	}
+	assert.Nil(t, config.Validate())
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test"})
+	o := dtap.NewDnstapSummaryOutput(config, &dtap.DnstapOutputParams{
+		Name:        "OutputSummary[0]",
+		BufferSize:  128,
+		InCounter:   counter,
+		LostCounter: counter,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+	frame, err := proto.Marshal(newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp."))
+	assert.NoError(t, err)
+	o.SetMessage(frame)
+	assert.True(t, waitFor(func() bool { return o.Status().Frames == 1 }))
+
+	// a broken frame is reported as the error of the output
+	o.SetMessage([]byte("broken"))
+	assert.True(t, waitFor(func() bool { return o.Status().LastError != "" }))
+	cancel()
+	<-done
+
+	b, err := ioutil.ReadFile(config.Path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "start,interval,dataset,key1,key2,count", lines[0])
+		assert.True(t, strings.HasSuffix(lines[1], ",3600,qtype,A,,1"), lines[1])
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// SuspiciousScorer computes the DNS tunneling and DGA heuristics of flattened messages.
+// The bytes per registered domain are counted over tumbling windows.
+type SuspiciousScorer struct {
+	config      *FlatSuspiciousConfig
+	qtypes      map[string]bool
+	mux         sync.Mutex
+	windowStart time.Time
+	bytes       map[string]uint64
+}
+
+func NewSuspiciousScorer(config *FlatSuspiciousConfig) *SuspiciousScorer {
+	s := &SuspiciousScorer{
+		config: config,
+		qtypes: map[string]bool{},
+		bytes:  map[string]uint64{},
+	}
+	for _, qtype := range config.GetQtypes() {
+		s.qtypes[strings.ToUpper(qtype)] = true
+	}
+	return s
+}
+
+// Score sets the scoring fields of data.
+// t is the timestamp of the message.
+func (s *SuspiciousScorer) Score(data *DnstapFlatT, t time.Time) {
+	if s == nil {
+		return
+	}
+	data.scored = true
+	name := strings.TrimSuffix(strings.ToLower(data.Qname), ".")
+	if suffix, _ := publicsuffix.PublicSuffix(name); suffix != name {
+		name = strings.TrimSuffix(name, "."+suffix)
+	} else {
+		name = ""
+	}
+	var chars, digits, run int
+	counts := map[rune]int{}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > data.LongestLabel {
+			data.LongestLabel = len(label)
+		}
+		run = 0
+		for _, c := range label {
+			chars++
+			counts[c]++
+			switch {
+			case c >= '0' && c <= '9':
+				digits++
+				run = 0
+			case c >= 'a' && c <= 'z' && !strings.ContainsRune("aeiou", c):
+				run++
+				if run > data.ConsonantRun {
+					data.ConsonantRun = run
+				}
+			default:
+				run = 0
+			}
+		}
+	}
+	if chars > 0 {
+		data.DigitRatio = float64(digits) / float64(chars)
+		for _, n := range counts {
+			p := float64(n) / float64(chars)
+			data.QnameEntropy -= p * math.Log2(p)
+		}
+	}
+	data.DomainBytes = s.addBytes(data.RegisteredDomain(), uint64(data.MessageSize), t)
+
+	reasons := []string{}
+	if data.QnameEntropy >= s.config.GetEntropy() {
+		reasons = append(reasons, "entropy")
+	}
+	if uint(data.LongestLabel) >= s.config.GetLabelLength() {
+		reasons = append(reasons, "label_length")
+	}
+	if data.DigitRatio >= s.config.GetDigitRatio() {
+		reasons = append(reasons, "digit_ratio")
+	}
+	if uint(data.ConsonantRun) >= s.config.GetConsonantRun() {
+		reasons = append(reasons, "consonant_run")
+	}
+	if s.qtypes[data.Qtype] {
+		reasons = append(reasons, "qtype")
+	}
+	if data.DomainBytes >= s.config.GetDomainBytes() {
+		reasons = append(reasons, "domain_bytes")
+	}
+	if uint(len(reasons)) >= s.config.GetThreshold() {
+		data.Suspicious = true
+		data.SuspiciousReason = strings.Join(reasons, ",")
+	}
+}
+
+// addBytes returns the bytes of the domain in the current window including size.
+// New domains are not counted when the window already has MaxDomains domains.
+func (s *SuspiciousScorer) addBytes(domain string, size uint64, t time.Time) uint64 {
+	window := time.Duration(s.config.GetWindow()) * time.Second
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !t.Before(s.windowStart.Add(window)) {
+		s.windowStart = t.Truncate(window)
+		s.bytes = map[string]uint64{}
+	}
+	if _, ok := s.bytes[domain]; !ok && uint(len(s.bytes)) >= s.config.GetMaxDomains() {
+		return 0
+	}
+	s.bytes[domain] += size
+	return s.bytes[domain]
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+type TopKEntry struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+}
+
+// TopKSketch counts keys and returns the heavy hitters.
+type TopKSketch interface {
+	Add(key string, count uint64)
+	// Entries returns the tracked keys sorted by count.
+	Entries() []TopKEntry
+}
+
+// SpaceSaving is the Space-Saving heavy hitters sketch.
+// It keeps at most capacity counters, the count of a key is overestimated at most Error.
+type SpaceSaving struct {
+	capacity int
+	counters map[string]*spaceSavingCounter
+	heap     spaceSavingHeap
+}
+
+type spaceSavingCounter struct {
+	TopKEntry
+	index int
+}
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	return &SpaceSaving{
+		capacity: capacity,
+		counters: map[string]*spaceSavingCounter{},
+		heap:     spaceSavingHeap{},
+	}
+}
+
+func (s *SpaceSaving) Add(key string, count uint64) {
+	if c, ok := s.counters[key]; ok {
+		c.Count += count
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+	if len(s.counters) < s.capacity {
+		c := &spaceSavingCounter{TopKEntry: TopKEntry{Key: key, Count: count}}
+		heap.Push(&s.heap, c)
+		s.counters[key] = c
+		return
+	}
+	// replace the minimum counter
+	c := s.heap[0]
+	delete(s.counters, c.Key)
+	c.Key = key
+	c.Error = c.Count
+	c.Count += count
+	s.counters[key] = c
+	heap.Fix(&s.heap, c.index)
+}
+
+// Entries returns all counters sorted by count.
+func (s *SpaceSaving) Entries() []TopKEntry {
+	return MergeTopK(s)
+}
+
+// MergeTopK sums counters of the sketches and returns them sorted by count.
+func MergeTopK(sketches ...*SpaceSaving) []TopKEntry {
+	lists := make([][]TopKEntry, 0, len(sketches))
+	for _, s := range sketches {
+		entries := make([]TopKEntry, 0, len(s.counters))
+		for _, c := range s.counters {
+			entries = append(entries, c.TopKEntry)
+		}
+		lists = append(lists, entries)
+	}
+	return mergeTopKEntries(lists...)
+}
+
+type spaceSavingHeap []*spaceSavingCounter
+
+func (h spaceSavingHeap) Len() int           { return len(h) }
+func (h spaceSavingHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h spaceSavingHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *spaceSavingHeap) Push(x interface{}) {
+	c := x.(*spaceSavingCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+func (h *spaceSavingHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return c
+}
+
+// CountMin is the Count-Min sketch of depth rows of width counters.
+// The count of a key is overestimated at most e/width of the total count with probability 1-e^-depth.
+type CountMin struct {
+	width  int
+	counts [][]uint64
+	total  uint64
+}
+
+func NewCountMin(width, depth int) *CountMin {
+	c := &CountMin{width: width, counts: make([][]uint64, depth)}
+	for i := range c.counts {
+		c.counts[i] = make([]uint64, width)
+	}
+	return c
+}
+
+// index returns the counter of the row by double hashing.
+func (c *CountMin) index(h1, h2 uint32, row int) int {
+	return int((h1 + uint32(row)*h2) % uint32(c.width))
+}
+
+func countMinHash(key string) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// Add counts the key and returns the estimate.
+func (c *CountMin) Add(key string, count uint64) uint64 {
+	h1, h2 := countMinHash(key)
+	c.total += count
+	estimate := uint64(math.MaxUint64)
+	for row := range c.counts {
+		i := c.index(h1, h2, row)
+		c.counts[row][i] += count
+		if c.counts[row][i] < estimate {
+			estimate = c.counts[row][i]
+		}
+	}
+	return estimate
+}
+
+// Estimate returns the count of the key.
+func (c *CountMin) Estimate(key string) uint64 {
+	h1, h2 := countMinHash(key)
+	estimate := uint64(math.MaxUint64)
+	for row := range c.counts {
+		if v := c.counts[row][c.index(h1, h2, row)]; v < estimate {
+			estimate = v
+		}
+	}
+	return estimate
+}
+
+// ErrorBound returns the overestimation bound e/width of the total count.
+func (c *CountMin) ErrorBound() uint64 {
+	return uint64(math.Ceil(math.E * float64(c.total) / float64(c.width)))
+}
+
+// CountMinTopK tracks the capacity keys of the largest Count-Min estimates.
+// Unlike SpaceSaving, the counts of keys don't depend on the order of them.
+type CountMinTopK struct {
+	capacity int
+	sketch   *CountMin
+	counters map[string]*spaceSavingCounter
+	heap     spaceSavingHeap
+}
+
+func NewCountMinTopK(capacity, width, depth int) *CountMinTopK {
+	return &CountMinTopK{
+		capacity: capacity,
+		sketch:   NewCountMin(width, depth),
+		counters: map[string]*spaceSavingCounter{},
+		heap:     spaceSavingHeap{},
+	}
+}
+
+func (s *CountMinTopK) Add(key string, count uint64) {
+	estimate := s.sketch.Add(key, count)
+	if c, ok := s.counters[key]; ok {
+		c.Count = estimate
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+	if len(s.counters) < s.capacity {
+		c := &spaceSavingCounter{TopKEntry: TopKEntry{Key: key, Count: estimate}}
+		heap.Push(&s.heap, c)
+		s.counters[key] = c
+		return
+	}
+	// replace the minimum candidate if the key exceeds it
+	c := s.heap[0]
+	if estimate <= c.Count {
+		return
+	}
+	delete(s.counters, c.Key)
+	c.Key = key
+	c.Count = estimate
+	s.counters[key] = c
+	heap.Fix(&s.heap, c.index)
+}
+
+// Entries returns the candidates sorted by count, Error is the overestimation bound.
+func (s *CountMinTopK) Entries() []TopKEntry {
+	bound := s.sketch.ErrorBound()
+	res := make([]TopKEntry, 0, len(s.counters))
+	for _, c := range s.counters {
+		res = append(res, TopKEntry{Key: c.Key, Count: c.Count, Error: bound})
+	}
+	return mergeTopKEntries(res)
+}
+
+// mergeTopKEntries sums the entries of the same key and sorts them by count.
+func mergeTopKEntries(lists ...[]TopKEntry) []TopKEntry {
+	m := map[string]*TopKEntry{}
+	for _, entries := range lists {
+		for _, c := range entries {
+			e, ok := m[c.Key]
+			if !ok {
+				e = &TopKEntry{Key: c.Key}
+				m[c.Key] = e
+			}
+			e.Count += c.Count
+			e.Error += c.Error
+		}
+	}
+	res := make([]TopKEntry, 0, len(m))
+	for _, e := range m {
+		res = append(res, *e)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count == res[j].Count {
+			return res[i].Key < res[j].Key
+		}
+		return res[i].Count > res[j].Count
+	})
+	return res
+}
+
+// SlidingTopK counts keys over the sliding window made of slots sketches.
+type SlidingTopK struct {
+	newSketch func() TopKSketch
+	slots     []TopKSketch
+	current   int
+}
+
+func NewSlidingTopK(slots int, newSketch func() TopKSketch) *SlidingTopK {
+	s := &SlidingTopK{
+		newSketch: newSketch,
+		slots:     make([]TopKSketch, slots),
+	}
+	for i := range s.slots {
+		s.slots[i] = newSketch()
+	}
+	return s
+}
+
+func (s *SlidingTopK) Add(key string, count uint64) {
+	s.slots[s.current].Add(key, count)
+}
+
+// Rotate drops the oldest slot.
+func (s *SlidingTopK) Rotate() {
+	s.current = (s.current + 1) % len(s.slots)
+	s.slots[s.current] = s.newSketch()
+}
+
+// Entries returns the counters in the window sorted by count.
+func (s *SlidingTopK) Entries() []TopKEntry {
+	lists := make([][]TopKEntry, 0, len(s.slots))
+	for _, slot := range s.slots {
+		lists = append(lists, slot.Entries())
+	}
+	return mergeTopKEntries(lists...)
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := dtap.NewSpaceSaving(3)
+	for i := 0; i < 10; i++ {
+		s.Add("a", 1)
+	}
+	for i := 0; i < 5; i++ {
+		s.Add("b", 1)
+	}
+	s.Add("c", 1)
+	s.Add("d", 1)
+	entries := s.Entries()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, dtap.TopKEntry{Key: "a", Count: 10}, entries[0])
+	assert.Equal(t, dtap.TopKEntry{Key: "b", Count: 5}, entries[1])
+	assert.Equal(t, dtap.TopKEntry{Key: "d", Count: 2, Error: 1}, entries[2])
+}
+
+func TestSlidingTopK(t *testing.T) {
+	s := dtap.NewSlidingTopK(2, func() dtap.TopKSketch { return dtap.NewSpaceSaving(10) })
+	s.Add("a", 3)
+	s.Rotate()
+	s.Add("a", 1)
+	s.Add("b", 2)
+	assert.Equal(t, []dtap.TopKEntry{{Key: "a", Count: 4}, {Key: "b", Count: 2}}, s.Entries())
+	s.Rotate()
+	assert.Equal(t, []dtap.TopKEntry{{Key: "b", Count: 2}, {Key: "a", Count: 1}}, s.Entries())
+}
+
+func TestCountMinTopK(t *testing.T) {
+	s := dtap.NewCountMinTopK(2, 1024, 4)
+	for i := 0; i < 100; i++ {
+		s.Add(strconv.Itoa(i), 1)
+	}
+	for i := 0; i < 10; i++ {
+		s.Add("a", 1)
+	}
+	for i := 0; i < 5; i++ {
+		s.Add("b", 1)
+	}
+	entries := s.Entries()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "a", entries[0].Key)
+		assert.Equal(t, "b", entries[1].Key)
+		// estimates are never smaller than the counts
+		assert.True(t, entries[0].Count >= 10 && entries[0].Count <= 10+entries[0].Error)
+		assert.True(t, entries[1].Count >= 5 && entries[1].Count <= 5+entries[1].Error)
+	}
+
+	sliding := dtap.NewSlidingTopK(2, func() dtap.TopKSketch { return dtap.NewCountMinTopK(10, 1024, 4) })
+	sliding.Add("a", 3)
+	sliding.Rotate()
+	sliding.Add("a", 1)
+	assert.Equal(t, uint64(4), sliding.Entries()[0].Count)
+}
+
+func TestOutputTopKConfig(t *testing.T) {
+	cfg := `[[OutputTopK]]
+Name = "dtap_topk_qname"
+Keys = ["Qname"]
+Sketch = "Count-Min"
+[[OutputTopK]]
+Name = "dtap_topk_client"
+Keys = ["QueryAddressHash"]
+Path = "/topk/dtap_topk_qname"
+[[OutputTopK]]
+Name = "dtap_topk_metrics"
+Keys = ["Qtype"]
+Path = "/metrics"
+`
+	c, err := dtap.NewConfigFromReader(bytes.NewBufferString(cfg))
+	assert.NoError(t, err)
+	errs := c.Validate()
+	assert.Equal(t, "count-min", c.OutputTopK[0].GetSketch())
+	if assert.Len(t, errs, 2) {
+		assert.Contains(t, errs[0].Error(), "OutputTopK[1]: Path /topk/dtap_topk_qname is used by OutputTopK[0]")
+		assert.Contains(t, errs[1].Error(), "OutputTopK[2]: Path /metrics is used by dtap")
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"strings"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+)
+
+// Transformer rewrites dnstap frames before they are sent to the outputs.
+type Transformer struct {
+	config *TransformConfig
+	redact []string
+	hash   []string
+}
+
+// NewTransformer returns nil if no transform is configured.
+func NewTransformer(config *TransformConfig) *Transformer {
+	if !config.Enabled() {
+		return nil
+	}
+	t := &Transformer{config: config}
+	for _, suffix := range config.RedactSuffixes {
+		t.redact = append(t.redact, strings.ToLower(dns.Fqdn(suffix)))
+	}
+	for _, suffix := range config.HashSuffixes {
+		t.hash = append(t.hash, strings.ToLower(dns.Fqdn(suffix)))
+	}
+	return t
+}
+
+func (t *Transformer) hasNameRules() bool {
+	return len(t.redact) > 0 || len(t.hash) > 0
+}
+
+// Transform returns the rewritten frame.
+func (t *Transformer) Transform(frame []byte) ([]byte, error) {
+	if t == nil {
+		return frame, nil
+	}
+	dt := dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, &dt); err != nil {
+		return nil, fmt.Errorf("failed to parse dnstap frame: %w", err)
+	}
+	if t.config.Identity != "" {
+		dt.Identity = []byte(t.config.Identity)
+	}
+	if t.config.Extra != "" {
+		dt.Extra = []byte(t.config.Extra)
+	}
+	if msg := dt.GetMessage(); msg != nil {
+		if t.config.StripMessage {
+			msg.QueryMessage = nil
+			msg.ResponseMessage = nil
+		} else {
+			msg.QueryMessage = t.message(msg.QueryMessage)
+			msg.ResponseMessage = t.message(msg.ResponseMessage)
+		}
+		if len(msg.QueryZone) > 0 && t.hasNameRules() {
+			msg.QueryZone = t.wireName(msg.QueryZone)
+		}
+	}
+	return proto.Marshal(&dt)
+}
+
+// message rewrites the wire format message.
+// If the message can't be rewritten, it is dropped not to leak names or ECS.
+func (t *Transformer) message(b []byte) []byte {
+	if b == nil || (!t.hasNameRules() && !t.config.DropECS) {
+		return b
+	}
+	m := dns.Msg{}
+	if err := m.Unpack(b); err != nil {
+		return nil
+	}
+	if t.hasNameRules() {
+		for i := range m.Question {
+			m.Question[i].Name = t.Name(m.Question[i].Name)
+		}
+		m.Answer = t.rrs(m.Answer)
+		m.Ns = t.rrs(m.Ns)
+		m.Extra = t.rrs(m.Extra)
+	}
+	if t.config.DropECS {
+		if opt := m.IsEdns0(); opt != nil {
+			options := opt.Option[:0]
+			for _, o := range opt.Option {
+				if _, ok := o.(*dns.EDNS0_SUBNET); !ok {
+					options = append(options, o)
+				}
+			}
+			opt.Option = options
+		}
+	}
+	out, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// rrs rewrites the names of the records.
+// Records of unknown types are dropped because names in their RDATA can't be rewritten.
+func (t *Transformer) rrs(rrs []dns.RR) []dns.RR {
+	res := rrs[:0]
+	for _, rr := range rrs {
+		if _, ok := rr.(*dns.RFC3597); ok {
+			continue
+		}
+		rr.Header().Name = t.Name(rr.Header().Name)
+		t.rdata(reflect.ValueOf(rr).Elem())
+		res = append(res, rr)
+	}
+	return res
+}
+
+// rdata rewrites the fields of names, which have the domain-name or cdomain-name tag in miekg/dns.
+func (t *Transformer) rdata(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f, field := v.Type().Field(i), v.Field(i)
+		if f.Type == reflect.TypeOf(dns.RR_Header{}) {
+			continue
+		}
+		if f.Anonymous && field.Kind() == reflect.Struct {
+			// HTTPS embeds SVCB
+			t.rdata(field)
+			continue
+		}
+		if !strings.HasSuffix(f.Tag.Get("dns"), "domain-name") {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(t.Name(field.String()))
+		case reflect.Slice:
+			for n := 0; n < field.Len(); n++ {
+				if e := field.Index(n); e.Kind() == reflect.String {
+					e.SetString(t.Name(e.String()))
+				}
+			}
+		}
+	}
+}
+
+func (t *Transformer) wireName(b []byte) []byte {
+	name, _, err := dns.UnpackDomainName(b, 0)
+	if err != nil {
+		return nil
+	}
+	buf := make([]byte, 255)
+	n, err := dns.PackDomainName(t.Name(name), buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+// Name returns the redacted or hashed name.
+// The labels below the matched suffix are replaced by "redacted" or their HMAC-SHA256 with HashKey,
+// redact suffixes are matched first.
+func (t *Transformer) Name(name string) string {
+	lower := strings.ToLower(name)
+	for _, suffix := range t.redact {
+		if lower == suffix {
+			return name
+		}
+		if strings.HasSuffix(lower, "."+suffix) {
+			return "redacted." + suffix
+		}
+	}
+	for _, suffix := range t.hash {
+		if strings.HasSuffix(lower, "."+suffix) {
+			prefix := strings.TrimSuffix(lower, "."+suffix)
+			mac := hmac.New(sha256.New, []byte(t.config.HashKey))
+			mac.Write([]byte(prefix))
+			return fmt.Sprintf("%x", mac.Sum(nil)[:8]) + "." + suffix
+		}
+	}
+	return name
+}
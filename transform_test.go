@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2019 Manabu Sonoda
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dtap_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mimuret/dtap"
+)
+
+func transformTestFrame(t *testing.T, tr *dtap.Transformer, dt *dnstap.Dnstap) *dnstap.Dnstap {
+	frame, err := proto.Marshal(dt)
+	assert.NoError(t, err)
+	frame, err = tr.Transform(frame)
+	assert.NoError(t, err)
+	res := &dnstap.Dnstap{}
+	assert.NoError(t, proto.Unmarshal(frame, res))
+	return res
+}
+
+func TestTransformer(t *testing.T) {
+	assert.Nil(t, dtap.NewTransformer(&dtap.TransformConfig{}))
+	assert.NotNil(t, (&dtap.TransformConfig{RedactSuffixes: []string{"."}}).Validate())
+	assert.NotNil(t, (&dtap.TransformConfig{HashSuffixes: []string{"example.jp"}}).Validate())
+
+	// a broken message is dropped not to leak the client subnet
+	ecs := dtap.NewTransformer(&dtap.TransformConfig{DropECS: true})
+	broken := newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp.")
+	broken.Message.QueryMessage = broken.Message.QueryMessage[:5]
+	assert.Nil(t, transformTestFrame(t, ecs, broken).Message.QueryMessage)
+
+	config := &dtap.TransformConfig{
+		RedactSuffixes: []string{"secret.example.jp"},
+		HashSuffixes:   []string{"example.jp"},
+		HashKey:        "key",
+		Identity:       "dtap1",
+		DropECS:        true,
+	}
+	assert.Nil(t, config.Validate())
+	tr := dtap.NewTransformer(config)
+	assert.Equal(t, "redacted.secret.example.jp.", tr.Name("WWW.secret.example.jp."))
+	assert.Equal(t, "secret.example.jp.", tr.Name("secret.example.jp."))
+	assert.Equal(t, tr.Name("www.example.jp."), tr.Name("WWW.Example.JP."))
+	assert.NotEqual(t, "www.example.jp.", tr.Name("www.example.jp."))
+	assert.Equal(t, "www.example.com.", tr.Name("www.example.com."))
+
+	m := new(dns.Msg)
+	m.SetQuestion("host.secret.example.jp.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4(),
+	})
+	bs, err := m.Pack()
+	assert.NoError(t, err)
+	dt := newTestDnstap(t, dnstap.Message_CLIENT_QUERY, "www.example.jp.")
+	dt.Message.QueryMessage = bs
+	res := transformTestFrame(t, tr, dt)
+	assert.Equal(t, "dtap1", string(res.Identity))
+	out := new(dns.Msg)
+	assert.NoError(t, out.Unpack(res.Message.QueryMessage))
+	assert.Equal(t, "redacted.secret.example.jp.", out.Question[0].Name)
+	if assert.NotNil(t, out.IsEdns0()) {
+		assert.Empty(t, out.IsEdns0().Option)
+	}
+
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("www"))
+	assert.Equal(t, fmt.Sprintf("%x", mac.Sum(nil)[:8])+".example.jp.", tr.Name("www.example.jp."))
+
+	// names in RDATA of every type are rewritten, unknown types are dropped
+	r := new(dns.Msg)
+	r.SetQuestion("host.secret.example.jp.", dns.TypeANY)
+	r.Response = true
+	for _, s := range []string{
+		"host.secret.example.jp. 300 IN NSEC next.secret.example.jp. A RRSIG NSEC",
+		"host.secret.example.jp. 300 IN HTTPS 1 svc.secret.example.jp. alpn=h2",
+		"host.secret.example.jp. 300 IN NAPTR 100 10 \"S\" \"SIP+D2U\" \"\" sip.secret.example.jp.",
+		"host.secret.example.jp. 300 IN RP admin.secret.example.jp. txt.secret.example.jp.",
+		"host.secret.example.jp. 300 IN KX 10 kx.secret.example.jp.",
+		"host.secret.example.jp. 300 IN RRSIG A 8 4 300 20200101000000 20190101000000 1 signer.secret.example.jp. AAAA",
+		"host.secret.example.jp. 300 IN TYPE65000 \\# 4 01020304",
+	} {
+		rr, err := dns.NewRR(s)
+		if !assert.NoError(t, err, s) {
+			t.FailNow()
+		}
+		r.Answer = append(r.Answer, rr)
+	}
+	bs, err = r.Pack()
+	assert.NoError(t, err)
+	dt = newTestDnstap(t, dnstap.Message_CLIENT_RESPONSE, "www.example.jp.")
+	dt.Message.ResponseMessage = bs
+	res = transformTestFrame(t, tr, dt)
+	out = new(dns.Msg)
+	assert.NoError(t, out.Unpack(res.Message.ResponseMessage))
+	assert.Len(t, out.Answer, 6)
+	for _, rr := range out.Answer {
+		assert.NotContains(t, strings.Replace(rr.String(), "redacted.secret", "", -1), ".secret", rr.String())
+	}
+
+	tr = dtap.NewTransformer(&dtap.TransformConfig{StripMessage: true})
+	res = transformTestFrame(t, tr, dt)
+	assert.Nil(t, res.Message.QueryMessage)
+	assert.Equal(t, dt.Message.QueryAddress, res.Message.QueryAddress)
+}